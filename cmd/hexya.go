@@ -64,4 +64,6 @@ func init() {
 	initServer()
 	initUpdateDB()
 	initI18n()
+	initOpenAPI()
+	initRecompute()
 }
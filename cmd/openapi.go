@@ -0,0 +1,85 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"text/template"
+
+	"github.com/hexya-erp/hexya/hexya/controllers"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const openAPIFileName string = "openapi.go"
+
+var openAPICmd = &cobra.Command{
+	Use:   "openapi [projectDir]",
+	Short: "Generate the OpenAPI document of the project",
+	Long: `Generate the OpenAPI 3 document describing the models of the project in 'projectDir'.
+If projectDir is omitted, defaults to the current directory.
+The document is written to the file given by --output, or to the standard output.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		generateAndRunFile(projectDir, openAPIFileName, openAPITemplate)
+	},
+}
+
+// GenerateOpenAPI writes the OpenAPI document of the models API. It is meant
+// to be called from a project start file which imports all the project's module.
+func GenerateOpenAPI(config map[string]interface{}) {
+	setupConfig(config)
+	connectToDB()
+	models.BootStrap()
+	var doc *controllers.OpenAPIDocument
+	err := models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		doc = controllers.NewOpenAPIDocument(env)
+	})
+	if err != nil {
+		log.Panic("Unable to generate OpenAPI document", "error", err)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Panic("Unable to marshal OpenAPI document", "error", err)
+	}
+	output := viper.GetString("OpenAPI.Output")
+	if output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := ioutil.WriteFile(output, data, 0644); err != nil {
+		log.Panic("Unable to write OpenAPI document", "file", output, "error", err)
+	}
+	log.Info("OpenAPI document generated", "file", output)
+}
+
+func initOpenAPI() {
+	openAPICmd.PersistentFlags().StringP("output", "O", "", "File to which the OpenAPI document is written. Defaults to stdout.")
+	viper.BindPFlag("OpenAPI.Output", openAPICmd.PersistentFlags().Lookup("output"))
+	HexyaCmd.AddCommand(openAPICmd)
+}
+
+var openAPITemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by hexya-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/hexya-erp/hexya/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.GenerateOpenAPI({{ .Config }})
+}
+`))
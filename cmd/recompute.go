@@ -0,0 +1,94 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"text/template"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const recomputeFileName string = "recompute.go"
+
+var recomputeCmd = &cobra.Command{
+	Use:   "recompute [projectDir]",
+	Short: "Recompute stored computed fields",
+	Long: `Recompute the stored computed fields given by --fields of the model given by --model
+in the project in 'projectDir', and store the new values in the database.
+If projectDir is omitted, defaults to the current directory.
+If no fields are given, all the stored computed fields of the model are recomputed.
+Records can be filtered with a domain, such as "[('Name', 'ilike', 'John')]".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if viper.GetString("Recompute.Model") == "" {
+			log.Panic("The model to recompute must be given with --model")
+		}
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		generateAndRunFile(projectDir, recomputeFileName, recomputeTemplate)
+	},
+}
+
+// Recompute recomputes the stored computed fields of the model given in the
+// configuration. It is meant to be called from a project start file which
+// imports all the project's module.
+func Recompute(config map[string]interface{}) {
+	setupConfig(config)
+	connectToDB()
+	models.BootStrap()
+	modelName := viper.GetString("Recompute.Model")
+	model := models.Registry.MustGet(modelName)
+	cond, err := model.ParseDomain(viper.GetString("Recompute.Domain"))
+	if err != nil {
+		log.Panic("Invalid domain", "model", modelName, "error", err)
+	}
+	var fields []models.FieldNamer
+	for _, field := range viper.GetStringSlice("Recompute.Fields") {
+		fields = append(fields, models.FieldName(field))
+	}
+	err = models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		rs := env.Pool(modelName).FetchAll()
+		if cond != nil {
+			rs = env.Pool(modelName).Search(cond)
+		}
+		rs.Recompute(viper.GetInt("Recompute.BatchSize"), fields...)
+	})
+	if err != nil {
+		log.Panic("Unable to recompute fields", "model", modelName, "error", err)
+	}
+	log.Info("Fields recomputed successfully", "model", modelName)
+}
+
+func initRecompute() {
+	recomputeCmd.PersistentFlags().StringP("model", "m", "", "Name of the model whose fields are recomputed.")
+	viper.BindPFlag("Recompute.Model", recomputeCmd.PersistentFlags().Lookup("model"))
+	recomputeCmd.PersistentFlags().StringSliceP("fields", "f", []string{}, "Comma separated list of the fields to recompute (ex: Age,Total). Defaults to all stored computed fields.")
+	viper.BindPFlag("Recompute.Fields", recomputeCmd.PersistentFlags().Lookup("fields"))
+	recomputeCmd.PersistentFlags().StringP("domain", "d", "", "Domain of the records to recompute. Defaults to all records.")
+	viper.BindPFlag("Recompute.Domain", recomputeCmd.PersistentFlags().Lookup("domain"))
+	recomputeCmd.PersistentFlags().Int("batch-size", models.RecomputeBatchSize, "Number of records recomputed in each batch.")
+	viper.BindPFlag("Recompute.BatchSize", recomputeCmd.PersistentFlags().Lookup("batch-size"))
+	HexyaCmd.AddCommand(recomputeCmd)
+}
+
+var recomputeTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by hexya-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/hexya-erp/hexya/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.Recompute({{ .Config }})
+}
+`))
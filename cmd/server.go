@@ -20,6 +20,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/gin-gonic/gin"
@@ -88,12 +90,32 @@ func StartServer(config map[string]interface{}) {
 	controllers.BootStrap()
 	menus.BootStrap()
 	server.PostInit()
+	models.Bus.Start()
+	startJobQueue()
 	srv := server.GetServer()
 	address := fmt.Sprintf("%s:%s", viper.GetString("Server.Interface"), viper.GetString("Server.Port"))
 	log.Info("Hexya is up and running", "address", address)
 	srv.Run(address)
 }
 
+// startJobQueue configures the channels of the job queue from the
+// "Server.JobChannels" config key and starts its workers.
+func startJobQueue() {
+	for _, chanConfig := range viper.GetStringSlice("Server.JobChannels") {
+		tokens := strings.Split(chanConfig, ":")
+		concurrency := 1
+		if len(tokens) > 1 {
+			var err error
+			concurrency, err = strconv.Atoi(tokens[1])
+			if err != nil {
+				log.Panic("Invalid job channel concurrency", "channel", chanConfig, "error", err)
+			}
+		}
+		models.Jobs.SetChannel(tokens[0], concurrency)
+	}
+	models.Jobs.Start()
+}
+
 // setupConfig takes the given config map and stores it into the viper configuration
 // It also initializes the logger
 func setupConfig(config map[string]interface{}) {
@@ -132,6 +154,10 @@ func initServer() {
 	viper.BindPFlag("Server.Port", serverCmd.PersistentFlags().Lookup("port"))
 	serverCmd.PersistentFlags().StringSliceP("languages", "l", []string{}, "Comma separated list of language codes to load (ex: fr,de,es).")
 	viper.BindPFlag("Server.Languages", serverCmd.PersistentFlags().Lookup("languages"))
+	serverCmd.PersistentFlags().String("graphql-route", "/graphql", "Route of the GraphQL endpoint.")
+	viper.BindPFlag("Server.GraphQLRoute", serverCmd.PersistentFlags().Lookup("graphql-route"))
+	serverCmd.PersistentFlags().StringSlice("job-channels", []string{models.DefaultJobChannel, models.WebhookJobChannel + ":4"}, "Comma separated list of job channels to process, with their number of workers (ex: root:2,webhooks:4,mail:1).")
+	viper.BindPFlag("Server.JobChannels", serverCmd.PersistentFlags().Lookup("job-channels"))
 	HexyaCmd.AddCommand(serverCmd)
 }
 
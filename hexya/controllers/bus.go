@@ -0,0 +1,70 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/server"
+)
+
+// busKeepAlive is the interval at which a comment is sent on idle
+// event streams so that proxies do not close the connection.
+const busKeepAlive = 30 * time.Second
+
+// declareBusControllers adds the /bus/events route to the registry.
+func declareBusControllers() {
+	bus := Registry.AddGroup("/bus")
+	bus.AddMiddleWare(restAuthenticate)
+	bus.AddController(http.MethodGet, "/events", BusEvents)
+}
+
+// BusEvents is the controller of the /bus/events route.
+//
+// It streams as server-sent events the record changes published on
+// models.Bus that the authenticated user is allowed to read. Events are
+// named after the kind of change ("create", "write" or "unlink") and their
+// data is the JSON encoded models.RecordChange.
+//
+// The optional "models" query parameter is a comma separated list of
+// model names to which the events are restricted.
+func BusEvents(c *server.Context) {
+	modelsFilter := make(map[string]bool)
+	if mList := c.Query("models"); mList != "" {
+		for _, modelName := range strings.Split(mList, ",") {
+			modelsFilter[strings.TrimSpace(modelName)] = true
+		}
+	}
+	sub := models.Bus.Subscribe(c.UID())
+	defer models.Bus.Unsubscribe(sub)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	clientGone := c.Writer.CloseNotify()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-clientGone:
+			return false
+		case change, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			if len(modelsFilter) > 0 && !modelsFilter[change.Model] {
+				return true
+			}
+			c.SSEvent(string(change.Event), change)
+		case <-time.After(busKeepAlive):
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		return true
+	})
+}
@@ -4,17 +4,38 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/server"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-func performRequest(r http.Handler, method, path string) *httptest.ResponseRecorder {
+func performRequest(r http.Handler, method, path string, authorization ...string) *httptest.ResponseRecorder {
 	req, _ := http.NewRequest(method, path, nil)
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization[0])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func performJSONRequest(r http.Handler, method, path, body string, authorization ...string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization[0])
+	}
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 	return w
@@ -104,5 +125,261 @@ func TestControllers(t *testing.T) {
 			So(r.Code, ShouldEqual, http.StatusOK)
 			So(r.Body.String(), ShouldEqual, "hexya-middleware-before/pong-middleware")
 		})
+		Convey("Testing bearer authentication middleware", func() {
+			token, _ := security.APIKeys.NewKey(5, "Test key", 0, "rpc")
+			grp := registry.GetGroup("/test")
+			grp.AddMiddleWare(server.BearerAuth("rpc"))
+			grp.AddController(http.MethodGet, "/whoami", func(ctx *server.Context) {
+				ctx.String(http.StatusOK, "%d", ctx.UID())
+			})
+			srv := newServer()
+			registry.createRoutes(srv.Group("/"))
+			r := performRequest(srv, http.MethodGet, "/test/whoami")
+			So(r.Code, ShouldEqual, http.StatusUnauthorized)
+			r = performRequest(srv, http.MethodGet, "/test/whoami", "Bearer wrong.token")
+			So(r.Code, ShouldEqual, http.StatusUnauthorized)
+			r = performRequest(srv, http.MethodGet, "/test/whoami", "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(r.Body.String(), ShouldEqual, "5")
+			otherToken, _ := security.APIKeys.NewKey(5, "Other key", 0, "read")
+			r = performRequest(srv, http.MethodGet, "/test/whoami", "Bearer "+otherToken)
+			So(r.Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestRPCModel(t *testing.T) {
+	Convey("Testing the /rpc/model controller", t, func() {
+		registry := newGroup("/")
+		rpc := registry.AddGroup("/rpc")
+		rpc.AddMiddleWare(restAuthenticate)
+		rpc.AddController(http.MethodPost, "/model", ModelCall)
+		srv := newServer()
+		registry.createRoutes(srv.Group("/"))
+		token, _ := security.APIKeys.NewKey(security.SuperUserID, "RPC key", 0)
+		Convey("Unauthenticated requests should be rejected", func() {
+			r := performJSONRequest(srv, http.MethodPost, "/rpc/model",
+				`{"jsonrpc": "2.0", "id": 2, "method": "call", "params": {"model": "User", "method": "SearchAll"}}`)
+			So(r.Code, ShouldEqual, http.StatusUnauthorized)
+			r = performJSONRequest(srv, http.MethodPost, "/rpc/model",
+				`{"jsonrpc": "2.0", "id": 2, "method": "call", "params": {"model": "User", "method": "SearchAll"}}`, "Bearer wrong.token")
+			So(r.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Malformed requests should return a parse error", func() {
+			r := performJSONRequest(srv, http.MethodPost, "/rpc/model", `{"jsonrpc": "2.0", "id": 1,`, "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusOK)
+			var resp server.ResponseError
+			So(json.Unmarshal(r.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Error.Code, ShouldEqual, server.RPCParseError)
+		})
+		Convey("Unknown models should return a method not found error", func() {
+			r := performJSONRequest(srv, http.MethodPost, "/rpc/model",
+				`{"jsonrpc": "2.0", "id": 3, "method": "call", "params": {"model": "NoModel", "method": "Search"}}`, "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusOK)
+			var resp server.ResponseError
+			So(json.Unmarshal(r.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.ID, ShouldEqual, 3)
+			So(resp.Error.Code, ShouldEqual, server.RPCMethodNotFound)
+		})
+		Convey("Batch requests should return a response for each request", func() {
+			r := performJSONRequest(srv, http.MethodPost, "/rpc/model", `[
+				{"jsonrpc": "2.0", "id": 4, "method": "call", "params": {"model": "NoModel", "method": "Search"}},
+				{"jsonrpc": "2.0", "id": 5, "method": "call", "params": "wrong"}
+			]`, "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusOK)
+			var resp []server.ResponseError
+			So(json.Unmarshal(r.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp, ShouldHaveLength, 2)
+			So(resp[0].ID, ShouldEqual, 4)
+			So(resp[0].Error.Code, ShouldEqual, server.RPCMethodNotFound)
+			So(resp[1].ID, ShouldEqual, 5)
+			So(resp[1].Error.Code, ShouldEqual, server.RPCInvalidRequest)
+		})
+	})
+	Convey("Testing JSON arguments conversion", t, func() {
+		methType := reflect.TypeOf(func(models.RecordCollection, string, models.FieldMapper, ...models.FieldNamer) {})
+		mixin := models.Registry.MustGet("CommonMixin")
+		Convey("Arguments should be converted to the method parameter types", func() {
+			args := []json.RawMessage{
+				json.RawMessage(`"John"`),
+				json.RawMessage(`{"Name": "John", "Nums": 2}`),
+				json.RawMessage(`"Name"`),
+				json.RawMessage(`"Email"`),
+			}
+			res, err := models.ConvertJSONArgs(models.Environment{}, mixin, methType, args)
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveLength, 3)
+			So(res[0], ShouldEqual, "John")
+			So(res[1], ShouldResemble, models.FieldMap{"Name": "John", "Nums": float64(2)})
+			So(res[2], ShouldResemble, []models.FieldNamer{models.FieldName("Name"), models.FieldName("Email")})
+		})
+		Convey("Variadic arguments can be omitted", func() {
+			res, err := models.ConvertJSONArgs(models.Environment{}, mixin, methType, []json.RawMessage{
+				json.RawMessage(`"John"`),
+				json.RawMessage(`{}`),
+			})
+			So(err, ShouldBeNil)
+			So(res, ShouldHaveLength, 3)
+			So(res[2], ShouldBeEmpty)
+		})
+		Convey("Wrong arguments should return an error", func() {
+			_, err := models.ConvertJSONArgs(models.Environment{}, mixin, methType, []json.RawMessage{json.RawMessage(`"John"`)})
+			So(err, ShouldNotBeNil)
+			_, err = models.ConvertJSONArgs(models.Environment{}, mixin, methType, []json.RawMessage{
+				json.RawMessage(`12`),
+				json.RawMessage(`{}`),
+			})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Domains should be checked against the model of the method", func() {
+			condMethType := reflect.TypeOf(func(models.RecordCollection, *models.Condition) {})
+			_, err := models.ConvertJSONArgs(models.Environment{}, mixin, condMethType, []json.RawMessage{
+				json.RawMessage(`[["NoField", "=", 1]]`),
+			})
+			So(err, ShouldNotBeNil)
+			_, err = models.ConvertJSONArgs(models.Environment{}, mixin, condMethType, []json.RawMessage{
+				json.RawMessage(`"[('ID', '=', "`),
+			})
+			So(err, ShouldNotBeNil)
+			_, err = models.ConvertJSONArgs(models.Environment{}, mixin, condMethType, []json.RawMessage{
+				json.RawMessage(`12`),
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestREST(t *testing.T) {
+	Convey("Testing the REST API controllers", t, func() {
+		registry := newGroup("/")
+		api := registry.AddGroup("/api")
+		api.AddMiddleWare(restAuthenticate)
+		api.AddController(http.MethodGet, "/:model", RESTSearch)
+		api.AddController(http.MethodGet, "/:model/:id", RESTRead)
+		srv := newServer()
+		registry.createRoutes(srv.Group("/"))
+		token, _ := security.APIKeys.NewKey(security.SuperUserID, "REST key", 0)
+		Convey("Unauthenticated requests should be rejected", func() {
+			r := performRequest(srv, http.MethodGet, "/api/CommonMixin")
+			So(r.Code, ShouldEqual, http.StatusUnauthorized)
+			r = performRequest(srv, http.MethodGet, "/api/CommonMixin", "Bearer wrong.token")
+			So(r.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Unknown models and mixins should not be found", func() {
+			r := performRequest(srv, http.MethodGet, "/api/NoModel", "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+			So(r.Body.String(), ShouldContainSubstring, "unknown model NoModel")
+			r = performRequest(srv, http.MethodGet, "/api/CommonMixin/1", "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Invalid limits and offsets should be rejected", func() {
+			r := performRequest(srv, http.MethodGet, "/api/User?limit=0", "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusBadRequest)
+			r = performRequest(srv, http.MethodGet, "/api/User?limit=5000", "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusBadRequest)
+			r = performRequest(srv, http.MethodGet, "/api/User?offset=-1", "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusBadRequest)
+			r = performRequest(srv, http.MethodGet, "/api/User?limit=abc", "Bearer "+token)
+			So(r.Code, ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Models errors should be mapped to HTTP statuses", func() {
+			So(restErrorStatus(models.NewAccessError("denied")), ShouldEqual, http.StatusForbidden)
+			So(restErrorStatus(models.NewValidationError("invalid")), ShouldEqual, http.StatusBadRequest)
+			So(restErrorStatus(models.NewMissingError("missing")), ShouldEqual, http.StatusNotFound)
+			So(restErrorStatus(models.NewConcurrencyError("conflict")), ShouldEqual, http.StatusConflict)
+			So(restErrorStatus(errors.New("boom")), ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	Convey("Testing OpenAPI schemas of Go types", t, func() {
+		visited := make(map[reflect.Type]bool)
+		Convey("Simple types should be described", func() {
+			s, ok := openAPITypeSchema(reflect.TypeOf(int64(0)), visited)
+			So(ok, ShouldBeTrue)
+			So(s.Type, ShouldEqual, "integer")
+			So(s.Format, ShouldEqual, "int64")
+			s, _ = openAPITypeSchema(reflect.TypeOf(0), visited)
+			So(s.Format, ShouldEqual, "int64")
+			s, _ = openAPITypeSchema(reflect.TypeOf(uint32(0)), visited)
+			So(s.Format, ShouldEqual, "int64")
+			s, _ = openAPITypeSchema(reflect.TypeOf(int32(0)), visited)
+			So(s.Format, ShouldEqual, "int32")
+			s, _ = openAPITypeSchema(reflect.TypeOf(uint16(0)), visited)
+			So(s.Format, ShouldEqual, "int32")
+			s, ok = openAPITypeSchema(reflect.TypeOf([]string{}), visited)
+			So(ok, ShouldBeTrue)
+			So(s.Type, ShouldEqual, "array")
+			So(s.Items.Type, ShouldEqual, "string")
+			s, ok = openAPITypeSchema(reflect.TypeOf(map[string]bool{}), visited)
+			So(ok, ShouldBeTrue)
+			So(s.Type, ShouldEqual, "object")
+			So(s.AdditionalProperties.Type, ShouldEqual, "boolean")
+		})
+		Convey("Models types should be described as converted by /rpc/model", func() {
+			s, ok := openAPITypeSchema(fieldMapperType, visited)
+			So(ok, ShouldBeTrue)
+			So(s.Type, ShouldEqual, "object")
+			s, ok = openAPITypeSchema(fieldNamerType, visited)
+			So(ok, ShouldBeTrue)
+			So(s.Type, ShouldEqual, "string")
+			s, ok = openAPITypeSchema(conditionType, visited)
+			So(ok, ShouldBeTrue)
+			So(s.Type, ShouldEqual, "array")
+			s, ok = openAPITypeSchema(recordCollectionType, visited)
+			So(ok, ShouldBeTrue)
+			So(s.Properties, ShouldContainKey, "ids")
+		})
+		Convey("Types that cannot be represented in JSON should be rejected", func() {
+			_, ok := openAPITypeSchema(reflect.TypeOf(func() {}), visited)
+			So(ok, ShouldBeFalse)
+			_, ok = openAPITypeSchema(reflect.TypeOf(models.Environment{}), visited)
+			So(ok, ShouldBeFalse)
+			_, ok = openAPITypeSchema(reflect.TypeOf(map[int]string{}), visited)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestGraphQL(t *testing.T) {
+	Convey("Testing GraphQL helpers", t, func() {
+		Convey("JSON literals should be converted like JSON values", func() {
+			literal := &ast.ObjectValue{Fields: []*ast.ObjectField{
+				{Name: &ast.Name{Value: "name"}, Value: &ast.StringValue{Value: "John"}},
+				{Name: &ast.Name{Value: "age"}, Value: &ast.IntValue{Value: "42"}},
+				{Name: &ast.Name{Value: "tags"}, Value: &ast.ListValue{Values: []ast.Value{
+					&ast.IntValue{Value: "1"}, &ast.BooleanValue{Value: true}}}},
+			}}
+			So(graphQLLiteralValue(literal), ShouldResemble, map[string]interface{}{
+				"name": "John",
+				"age":  float64(42),
+				"tags": []interface{}{float64(1), true},
+			})
+		})
+		Convey("Selected fields should be collected with their sub selections", func() {
+			profileSel := &ast.Field{Name: &ast.Name{Value: "email"}}
+			fragments := map[string]ast.Definition{
+				"userFields": &ast.FragmentDefinition{SelectionSet: &ast.SelectionSet{Selections: []ast.Selection{
+					&ast.Field{Name: &ast.Name{Value: "name"}},
+				}}},
+			}
+			selections := []ast.Selection{
+				&ast.Field{Name: &ast.Name{Value: "id"}},
+				&ast.Field{Name: &ast.Name{Value: "profile_id"}, SelectionSet: &ast.SelectionSet{
+					Selections: []ast.Selection{profileSel}}},
+				&ast.InlineFragment{SelectionSet: &ast.SelectionSet{Selections: []ast.Selection{
+					&ast.Field{Name: &ast.Name{Value: "age"}},
+				}}},
+				&ast.FragmentSpread{Name: &ast.Name{Value: "userFields"}},
+			}
+			res := make(map[string][]ast.Selection)
+			graphQLSelectedFields(selections, fragments, res)
+			So(res, ShouldHaveLength, 4)
+			So(res, ShouldContainKey, "name")
+			So(res, ShouldContainKey, "age")
+			So(res["id"], ShouldBeEmpty)
+			So(res["profile_id"], ShouldResemble, []ast.Selection{profileSel})
+		})
 	})
 }
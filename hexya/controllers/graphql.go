@@ -0,0 +1,525 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/server"
+	"github.com/spf13/viper"
+)
+
+// defaultGraphQLRoute is the route of the GraphQL endpoint if
+// the Server.GraphQLRoute configuration key is not set.
+const defaultGraphQLRoute = "/graphql"
+
+var (
+	// graphQLSchema is the GraphQL schema of the models, built at bootstrap
+	graphQLSchema graphql.Schema
+	// graphQLFieldInfos holds the FieldInfo of all fields of the models
+	// of graphQLSchema, by model name and field JSON name.
+	graphQLFieldInfos map[string]map[string]*models.FieldInfo
+	// graphQLJSON is the scalar type of values that have no GraphQL type,
+	// such as domains and field values maps.
+	graphQLJSON = graphql.NewScalar(graphql.ScalarConfig{
+		Name:        "JSON",
+		Description: "Any JSON value",
+		Serialize: func(value interface{}) interface{} {
+			return value
+		},
+		ParseValue: func(value interface{}) interface{} {
+			return value
+		},
+		ParseLiteral: graphQLLiteralValue,
+	})
+)
+
+// A graphQLRequest is a GraphQL request as sent over HTTP
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLEnvKey is the key of the models.Environment in the context of GraphQL resolvers
+type graphQLEnvKey struct{}
+
+// declareGraphQLControllers builds the GraphQL schema of the models and adds
+// the GraphQL endpoint to the registry.
+//
+// The endpoint route is given by the Server.GraphQLRoute configuration key
+// and defaults to /graphql. It must not conflict with the routes of other
+// controllers, such as /api/:model.
+func declareGraphQLControllers() {
+	err := models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		graphQLSchema = newGraphQLSchema(env)
+	})
+	if err != nil {
+		log.Panic("Unable to build GraphQL schema", "error", err)
+	}
+	route := viper.GetString("Server.GraphQLRoute")
+	if route == "" {
+		route = defaultGraphQLRoute
+	}
+	grp := Registry.AddGroup(route)
+	grp.AddMiddleWare(restAuthenticate)
+	grp.AddController(http.MethodGet, "", GraphQL)
+	grp.AddController(http.MethodPost, "", GraphQL)
+}
+
+// GraphQL executes the GraphQL request of the client.
+//
+// POST requests must have a JSON body with "query", "operationName" and
+// "variables" keys. GET requests give the same values as query parameters,
+// variables being JSON encoded.
+//
+// The whole request is executed in a single transaction, which is rolled
+// back if any error occurs.
+func GraphQL(c *server.Context) {
+	var req graphQLRequest
+	switch c.Request.Method {
+	case http.MethodGet:
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if vars := c.Query("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				restError(c, http.StatusBadRequest, fmt.Errorf("invalid variables: %s", err))
+				return
+			}
+		}
+	default:
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+			restError(c, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %s", err))
+			return
+		}
+	}
+	var result *graphql.Result
+	err := models.ExecuteInNewEnvironment(c.UID(), func(env models.Environment) {
+		result = graphql.Do(graphql.Params{
+			Schema:         graphQLSchema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        context.WithValue(context.Background(), graphQLEnvKey{}, env),
+		})
+		if result.HasErrors() {
+			// We panic to roll back the transaction
+			panic(errors.New(result.Errors[0].Message))
+		}
+	})
+	if result == nil || (err != nil && !result.HasErrors()) {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	for i, e := range result.Errors {
+		// Errors of resolvers include the stack trace
+		result.Errors[i].Message = strings.SplitN(e.Message, "\n", 2)[0]
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// newGraphQLSchema returns the GraphQL schema of all the models of the registry.
+//
+// Each model has an object type with its fields, relation fields being
+// resolved as nested objects. The following queries are defined for each model:
+//
+// - <Model>(id) returns a single record
+//
+// - <Model>Search(filter, order, limit, offset) returns a list of records,
+// filter being a serialized domain (see models.Model.ParseDomain)
+//
+// - <Model>Count(filter) returns the number of records matching filter
+//
+// The following mutations are defined for each model:
+//
+// - <Model>Create(values) creates a record and returns it
+//
+// - <Model>Write(ids, values) updates records and returns them
+//
+// - <Model>Unlink(ids) deletes records and returns their number
+func newGraphQLSchema(env models.Environment) graphql.Schema {
+	objects := make(map[string]*graphql.Object)
+	graphQLFieldInfos = make(map[string]map[string]*models.FieldInfo)
+	queries := make(graphql.Fields)
+	mutations := make(graphql.Fields)
+	for _, model := range models.Registry.All() {
+		if model.IsMixin() {
+			continue
+		}
+		fInfos := env.Pool(model.Name()).Call("FieldsGet", models.FieldsGetArgs{}).(map[string]*models.FieldInfo)
+		graphQLFieldInfos[model.Name()] = fInfos
+		object := graphql.NewObject(graphql.ObjectConfig{
+			Name:   model.Name(),
+			Fields: graphQLObjectFields(fInfos, objects),
+		})
+		objects[model.Name()] = object
+		addGraphQLQueries(queries, model, object)
+		addGraphQLMutations(mutations, model, object)
+	}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: queries}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutations}),
+	})
+	if err != nil {
+		log.Panic("Invalid GraphQL schema", "error", err)
+	}
+	return schema
+}
+
+// graphQLObjectFields returns the fields of the object type of a model with the given fields.
+//
+// objects are the object types of all models, by name. Since they are not all
+// created when this function is called, fields are only computed when the
+// schema is built.
+func graphQLObjectFields(fInfos map[string]*models.FieldInfo, objects map[string]*graphql.Object) graphql.FieldsThunk {
+	return func() graphql.Fields {
+		res := make(graphql.Fields)
+		for fName, fInfo := range fInfos {
+			res[fName] = graphQLField(fName, fInfo, objects)
+		}
+		return res
+	}
+}
+
+// graphQLField returns the GraphQL field of the model field described by fInfo.
+func graphQLField(fName string, fInfo *models.FieldInfo, objects map[string]*graphql.Object) *graphql.Field {
+	res := graphql.Field{
+		Description: fInfo.String,
+		Type:        graphQLScalarType(fInfo.Type),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return graphQLFieldValue(p.Source.(models.RecordCollection), fName), nil
+		},
+	}
+	relObject, isObject := objects[fInfo.Relation]
+	switch {
+	case fName == "id":
+		res.Type = graphql.NewNonNull(graphql.Int)
+	case fInfo.Type.Is2OneRelationType() && isObject:
+		res.Type = relObject
+		res.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+			relRS, ok := p.Source.(models.RecordCollection).Get(fName).(models.RecordSet)
+			if !ok || relRS.Len() == 0 {
+				return nil, nil
+			}
+			return relRS.Collection(), nil
+		}
+	case fInfo.Type.Is2ManyRelationType() && isObject:
+		res.Type = graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(relObject)))
+		res.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+			relRS, ok := p.Source.(models.RecordCollection).Get(fName).(models.RecordSet)
+			if !ok {
+				return []models.RecordCollection{}, nil
+			}
+			return relRS.Collection().Records(), nil
+		}
+	}
+	return &res
+}
+
+// graphQLScalarType returns the GraphQL type of the values of non relation fields of the given type.
+func graphQLScalarType(typ fieldtype.Type) graphql.Output {
+	switch typ {
+	case fieldtype.Boolean:
+		return graphql.Boolean
+	case fieldtype.Integer:
+		return graphql.Int
+	case fieldtype.Float:
+		return graphql.Float
+	case fieldtype.Binary, fieldtype.Char, fieldtype.Date, fieldtype.DateTime, fieldtype.HTML,
+		fieldtype.Reference, fieldtype.Selection, fieldtype.Text:
+		return graphql.String
+	}
+	return graphQLJSON
+}
+
+// graphQLFieldValue returns the value of the given field of the rec record,
+// converted for its GraphQL type.
+func graphQLFieldValue(rec models.RecordCollection, fName string) interface{} {
+	switch value := rec.Get(fName).(type) {
+	case dates.Date:
+		if value.IsZero() {
+			return nil
+		}
+		return value.String()
+	case dates.DateTime:
+		if value.IsZero() {
+			return nil
+		}
+		return value.String()
+	case models.RecordSet:
+		// Relation to a model without object type
+		return value.Ids()
+	default:
+		return value
+	}
+}
+
+// addGraphQLQueries adds the queries of the given model to queries.
+func addGraphQLQueries(queries graphql.Fields, model *models.Model, object *graphql.Object) {
+	queries[model.Name()] = &graphql.Field{
+		Description: fmt.Sprintf("Returns the %s record with the given id", model.Name()),
+		Type:        object,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			rs := model.Browse(graphQLEnv(p), []int64{int64(p.Args["id"].(int))})
+			rs = graphQLLoad(rs, p.Info)
+			if rs.IsEmpty() {
+				return nil, nil
+			}
+			return rs, nil
+		},
+	}
+	queries[model.Name()+"Search"] = &graphql.Field{
+		Description: fmt.Sprintf("Returns the %s records matching the given filter domain", model.Name()),
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(object))),
+		Args: graphql.FieldConfigArgument{
+			"filter": &graphql.ArgumentConfig{Type: graphQLJSON, Description: "Serialized domain"},
+			"order":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"limit":  &graphql.ArgumentConfig{Type: graphql.Int},
+			"offset": &graphql.ArgumentConfig{Type: graphql.Int},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			rs, err := graphQLSearch(p, model)
+			if err != nil {
+				return nil, err
+			}
+			if order, ok := p.Args["order"].([]interface{}); ok && len(order) > 0 {
+				exprs := make([]string, len(order))
+				for i, o := range order {
+					exprs[i] = o.(string)
+				}
+				rs = rs.Call("OrderBy", exprs).(models.RecordSet).Collection()
+			}
+			if limit, ok := p.Args["limit"].(int); ok {
+				rs = rs.Call("Limit", limit).(models.RecordSet).Collection()
+			}
+			if offset, ok := p.Args["offset"].(int); ok {
+				rs = rs.Call("Offset", offset).(models.RecordSet).Collection()
+			}
+			return graphQLLoad(rs, p.Info).Records(), nil
+		},
+	}
+	queries[model.Name()+"Count"] = &graphql.Field{
+		Description: fmt.Sprintf("Returns the number of %s records matching the given filter domain", model.Name()),
+		Type:        graphql.NewNonNull(graphql.Int),
+		Args: graphql.FieldConfigArgument{
+			"filter": &graphql.ArgumentConfig{Type: graphQLJSON, Description: "Serialized domain"},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			rs, err := graphQLSearch(p, model)
+			if err != nil {
+				return nil, err
+			}
+			return rs.Call("SearchCount").(int), nil
+		},
+	}
+}
+
+// addGraphQLMutations adds the mutations of the given model to mutations.
+func addGraphQLMutations(mutations graphql.Fields, model *models.Model, object *graphql.Object) {
+	idsArg := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int)))}
+	valuesArg := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphQLJSON), Description: "Field values by field name"}
+	mutations[model.Name()+"Create"] = &graphql.Field{
+		Description: fmt.Sprintf("Creates a %s record with the given values", model.Name()),
+		Type:        object,
+		Args:        graphql.FieldConfigArgument{"values": valuesArg},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			values, err := graphQLValues(p)
+			if err != nil {
+				return nil, err
+			}
+			rs := graphQLEnv(p).Pool(model.Name())
+			rs = rs.Call("Create", restConvertValues(rs, values)).(models.RecordSet).Collection()
+			return graphQLLoad(rs, p.Info), nil
+		},
+	}
+	mutations[model.Name()+"Write"] = &graphql.Field{
+		Description: fmt.Sprintf("Updates the %s records with the given ids with the given values", model.Name()),
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(object))),
+		Args:        graphql.FieldConfigArgument{"ids": idsArg, "values": valuesArg},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			values, err := graphQLValues(p)
+			if err != nil {
+				return nil, err
+			}
+			rs := model.Browse(graphQLEnv(p), graphQLIDs(p))
+			rs.Call("Write", restConvertValues(rs, values))
+			return graphQLLoad(rs, p.Info).Records(), nil
+		},
+	}
+	mutations[model.Name()+"Unlink"] = &graphql.Field{
+		Description: fmt.Sprintf("Deletes the %s records with the given ids", model.Name()),
+		Type:        graphql.NewNonNull(graphql.Int),
+		Args:        graphql.FieldConfigArgument{"ids": idsArg},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			rs := model.Browse(graphQLEnv(p), graphQLIDs(p))
+			return rs.Call("Unlink").(int64), nil
+		},
+	}
+}
+
+// graphQLEnv returns the Environment of the current GraphQL request
+func graphQLEnv(p graphql.ResolveParams) models.Environment {
+	return p.Context.Value(graphQLEnvKey{}).(models.Environment)
+}
+
+// graphQLSearch returns the records of model matching the filter argument of p.
+func graphQLSearch(p graphql.ResolveParams, model *models.Model) (models.RecordCollection, error) {
+	rs := graphQLEnv(p).Pool(model.Name())
+	filter, ok := p.Args["filter"]
+	if !ok || filter == nil {
+		return rs.Call("FetchAll").(models.RecordSet).Collection(), nil
+	}
+	cond, err := model.ParseDomain(filter)
+	if err != nil {
+		return rs, fmt.Errorf("invalid filter: %s", err)
+	}
+	if cond == nil {
+		return rs.Call("FetchAll").(models.RecordSet).Collection(), nil
+	}
+	return rs.Call("Search", cond).(models.RecordSet).Collection(), nil
+}
+
+// graphQLIDs returns the ids argument of p
+func graphQLIDs(p graphql.ResolveParams) []int64 {
+	args, _ := p.Args["ids"].([]interface{})
+	res := make([]int64, len(args))
+	for i, arg := range args {
+		res[i] = int64(arg.(int))
+	}
+	return res
+}
+
+// graphQLValues returns the values argument of p
+func graphQLValues(p graphql.ResolveParams) (models.FieldMap, error) {
+	values, ok := p.Args["values"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("values must be an object")
+	}
+	return models.FieldMap(values), nil
+}
+
+// graphQLLoad loads the fields of rs that are selected in the current GraphQL
+// request and returns the loaded RecordCollection.
+//
+// Selected relation fields are loaded recursively for all records of rs at
+// once, so that nested objects are resolved from the cache instead of being
+// fetched record by record.
+func graphQLLoad(rs models.RecordCollection, info graphql.ResolveInfo) models.RecordCollection {
+	var selections []ast.Selection
+	for _, field := range info.FieldASTs {
+		if field.SelectionSet != nil {
+			selections = append(selections, field.SelectionSet.Selections...)
+		}
+	}
+	return graphQLLoadSelections(rs, selections, info.Fragments)
+}
+
+// graphQLLoadSelections loads the fields of rs given by selections and recursively
+// the fields of their related records given by their sub selections.
+func graphQLLoadSelections(rs models.RecordCollection, selections []ast.Selection, fragments map[string]ast.Definition) models.RecordCollection {
+	fInfos := graphQLFieldInfos[rs.ModelName()]
+	selected := make(map[string][]ast.Selection)
+	graphQLSelectedFields(selections, fragments, selected)
+	var fields []string
+	for fName := range selected {
+		fInfo, ok := fInfos[fName]
+		if !ok {
+			continue
+		}
+		if fInfo.Store || fInfo.Type.IsNonStoredRelationType() {
+			fields = append(fields, fName)
+		}
+	}
+	rs = rs.Load(fields...)
+	for fName, subSelections := range selected {
+		fInfo, ok := fInfos[fName]
+		if !ok || len(subSelections) == 0 {
+			continue
+		}
+		if _, ok := graphQLFieldInfos[fInfo.Relation]; !ok {
+			continue
+		}
+		var ids []int64
+		for _, rec := range rs.Records() {
+			if relRS, ok := rec.Get(fName).(models.RecordSet); ok {
+				ids = append(ids, relRS.Ids()...)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		relModel := models.Registry.MustGet(fInfo.Relation)
+		graphQLLoadSelections(relModel.Browse(rs.Env(), ids), subSelections, fragments)
+	}
+	return rs
+}
+
+// graphQLSelectedFields adds to res the names of the fields selected by selections,
+// with their sub selections. Fragments are expanded.
+func graphQLSelectedFields(selections []ast.Selection, fragments map[string]ast.Definition, res map[string][]ast.Selection) {
+	for _, selection := range selections {
+		switch sel := selection.(type) {
+		case *ast.Field:
+			subSelections := res[sel.Name.Value]
+			if sel.SelectionSet != nil {
+				subSelections = append(subSelections, sel.SelectionSet.Selections...)
+			}
+			res[sel.Name.Value] = subSelections
+		case *ast.InlineFragment:
+			graphQLSelectedFields(sel.SelectionSet.Selections, fragments, res)
+		case *ast.FragmentSpread:
+			if fragment, ok := fragments[sel.Name.Value].(*ast.FragmentDefinition); ok {
+				graphQLSelectedFields(fragment.SelectionSet.Selections, fragments, res)
+			}
+		}
+	}
+}
+
+// graphQLLiteralValue returns the value of the given literal of a JSON argument.
+//
+// Numbers are returned as float64 as encoding/json does for variables.
+func graphQLLiteralValue(value ast.Value) interface{} {
+	switch val := value.(type) {
+	case *ast.StringValue:
+		return val.Value
+	case *ast.EnumValue:
+		return val.Value
+	case *ast.BooleanValue:
+		return val.Value
+	case *ast.IntValue:
+		res, _ := strconv.ParseFloat(val.Value, 64)
+		return res
+	case *ast.FloatValue:
+		res, _ := strconv.ParseFloat(val.Value, 64)
+		return res
+	case *ast.ListValue:
+		res := make([]interface{}, len(val.Values))
+		for i, v := range val.Values {
+			res[i] = graphQLLiteralValue(v)
+		}
+		return res
+	case *ast.ObjectValue:
+		res := make(map[string]interface{})
+		for _, field := range val.Fields {
+			res[field.Name.Value] = graphQLLiteralValue(field.Value)
+		}
+		return res
+	}
+	return nil
+}
@@ -11,12 +11,18 @@ import (
 var log *logging.Logger
 
 // BootStrap creates the actual controllers from the controllers registry.
-// This function must be called before starting the http server.
+// This function must be called after bootstrapping the models and
+// before starting the http server.
 func BootStrap() {
+	declareGraphQLControllers()
 	Registry.createRoutes(server.GetServer().Group("/"))
 }
 
 func init() {
 	log = logging.GetLogger("controllers")
 	Registry = newGroup("/")
+	declareRPCModelControllers()
+	declareRESTControllers()
+	declareOpenAPIControllers()
+	declareBusControllers()
 }
@@ -0,0 +1,539 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+	"github.com/hexya-erp/hexya/hexya/server"
+)
+
+const (
+	// openAPIVersion is the version of the OpenAPI specification of the generated documents
+	openAPIVersion = "3.0.1"
+	// hexyaAPIVersion is the version of the API described in the generated documents
+	hexyaAPIVersion = "0.1"
+)
+
+// An OpenAPIDocument is an OpenAPI 3 document describing the models API
+type OpenAPIDocument struct {
+	OpenAPI    string                      `json:"openapi"`
+	Info       OpenAPIInfo                 `json:"info"`
+	Paths      map[string]*OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents           `json:"components"`
+}
+
+// OpenAPIInfo is the info object of an OpenAPIDocument
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIComponents holds the reusable schemas of an OpenAPIDocument
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+// An OpenAPIPathItem describes the operations available on a path
+type OpenAPIPathItem struct {
+	Get    *OpenAPIOperation `json:"get,omitempty"`
+	Post   *OpenAPIOperation `json:"post,omitempty"`
+	Patch  *OpenAPIOperation `json:"patch,omitempty"`
+	Delete *OpenAPIOperation `json:"delete,omitempty"`
+}
+
+// An OpenAPIOperation describes a single API operation on a path
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+// An OpenAPIParameter describes a single path or query parameter of an operation
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+// An OpenAPIRequestBody describes the body of a request
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+// An OpenAPIResponse describes a single response of an operation
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// An OpenAPIMediaType gives the schema of a request or response body
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// An OpenAPISchema describes a data type
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Title                string                    `json:"title,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	ReadOnly             bool                      `json:"readOnly,omitempty"`
+	Enum                 []interface{}             `json:"enum,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	OneOf                []*OpenAPISchema          `json:"oneOf,omitempty"`
+	// Relation is the related model of a relation field
+	Relation string `json:"x-hexya-relation,omitempty"`
+	// FieldType is the hexya type of a model field
+	FieldType fieldtype.Type `json:"x-hexya-type,omitempty"`
+	// Parameters are the schemas of the positional arguments of a method call
+	Parameters []*OpenAPISchema `json:"x-hexya-parameters,omitempty"`
+}
+
+var (
+	dateType          = reflect.TypeOf(dates.Date{})
+	dateTimeType      = reflect.TypeOf(dates.DateTime{})
+	fieldMapType      = reflect.TypeOf(models.FieldMap{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// declareOpenAPIControllers adds the route that serves the OpenAPI document
+func declareOpenAPIControllers() {
+	doc := Registry.AddGroup("/doc")
+	doc.AddMiddleWare(restAuthenticate)
+	doc.AddController(http.MethodGet, "/openapi.json", OpenAPI)
+}
+
+// OpenAPI is the controller that serves the OpenAPI document of the models API
+func OpenAPI(c *server.Context) {
+	var doc *OpenAPIDocument
+	err := models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		doc = NewOpenAPIDocument(env)
+	})
+	if err != nil {
+		restError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+// NewOpenAPIDocument returns the OpenAPI document describing the REST API
+// (/api) and the model methods available through /rpc/model for all models
+// of the registry. Models must have been bootstrapped.
+//
+// Field descriptions are translated in the language of the context of env.
+func NewOpenAPIDocument(env models.Environment) *OpenAPIDocument {
+	doc := OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: OpenAPIInfo{
+			Title:   "Hexya API",
+			Version: hexyaAPIVersion,
+		},
+		Paths: make(map[string]*OpenAPIPathItem),
+		Components: OpenAPIComponents{
+			Schemas: make(map[string]*OpenAPISchema),
+		},
+	}
+	var calls []*OpenAPISchema
+	for _, model := range models.Registry.All() {
+		if model.IsMixin() {
+			continue
+		}
+		doc.Components.Schemas[model.Name()] = openAPIModelSchema(env, model)
+		doc.addRESTPaths(model)
+		for _, methName := range model.Methods().AllNames() {
+			callSchema, ok := openAPIMethodCallSchema(model, model.Methods().MustGet(methName))
+			if !ok {
+				continue
+			}
+			schemaName := fmt.Sprintf("%s.%s", model.Name(), methName)
+			doc.Components.Schemas[schemaName] = callSchema
+			calls = append(calls, &OpenAPISchema{Ref: openAPIRef(schemaName)})
+		}
+	}
+	doc.addRPCPath(calls)
+	return &doc
+}
+
+// openAPIRef returns the reference to the given component schema name
+func openAPIRef(name string) string {
+	return fmt.Sprintf("#/components/schemas/%s", name)
+}
+
+// openAPIModelSchema returns the schema of the records of the given model
+func openAPIModelSchema(env models.Environment, model *models.Model) *OpenAPISchema {
+	res := OpenAPISchema{
+		Type:       "object",
+		Title:      model.Name(),
+		Properties: make(map[string]*OpenAPISchema),
+	}
+	fInfos := env.Pool(model.Name()).Call("FieldsGet", models.FieldsGetArgs{}).(map[string]*models.FieldInfo)
+	for fName, fInfo := range fInfos {
+		res.Properties[fName] = openAPIFieldSchema(fInfo)
+		if fInfo.Required {
+			res.Required = append(res.Required, fName)
+		}
+	}
+	if idSchema, ok := res.Properties["id"]; ok {
+		idSchema.ReadOnly = true
+	}
+	sort.Strings(res.Required)
+	return &res
+}
+
+// openAPIFieldSchema returns the schema of the field described by fInfo
+func openAPIFieldSchema(fInfo *models.FieldInfo) *OpenAPISchema {
+	res := OpenAPISchema{
+		Title:       fInfo.String,
+		Description: fInfo.Help,
+		FieldType:   fInfo.Type,
+		Relation:    fInfo.Relation,
+		Nullable:    !fInfo.Required,
+		ReadOnly:    fInfo.ReadOnly || !fInfo.Store && !fInfo.Type.IsNonStoredRelationType(),
+	}
+	switch fInfo.Type {
+	case fieldtype.Boolean:
+		res.Type = "boolean"
+		res.Nullable = false
+	case fieldtype.Integer:
+		res.Type = "integer"
+		res.Format = "int64"
+	case fieldtype.Float:
+		res.Type = "number"
+		res.Format = "double"
+	case fieldtype.Date:
+		res.Type = "string"
+		res.Format = "date"
+	case fieldtype.DateTime:
+		res.Type = "string"
+		res.Format = "date-time"
+	case fieldtype.Binary:
+		res.Type = "string"
+		res.Format = "byte"
+	case fieldtype.Selection:
+		res.Type = "string"
+		keys := make([]string, 0, len(fInfo.Selection))
+		for key := range fInfo.Selection {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			res.Enum = append(res.Enum, key)
+		}
+	case fieldtype.Many2One, fieldtype.One2One, fieldtype.Rev2One:
+		res.Type = "integer"
+		res.Format = "int64"
+		res.Description = strings.TrimSpace(fmt.Sprintf("%s\nID of the related %s record.", res.Description, fInfo.Relation))
+	case fieldtype.One2Many, fieldtype.Many2Many:
+		res.Type = "array"
+		res.Items = &OpenAPISchema{Type: "integer", Format: "int64"}
+		res.Nullable = false
+		res.Description = strings.TrimSpace(fmt.Sprintf("%s\nIDs of the related %s records.", res.Description, fInfo.Relation))
+	default:
+		res.Type = "string"
+	}
+	return &res
+}
+
+// addRESTPaths adds the REST API paths of the given model to this document
+func (doc *OpenAPIDocument) addRESTPaths(model *models.Model) {
+	name := model.Name()
+	ref := &OpenAPISchema{Ref: openAPIRef(name)}
+	jsonContent := func(schema *OpenAPISchema) map[string]*OpenAPIMediaType {
+		return map[string]*OpenAPIMediaType{"application/json": {Schema: schema}}
+	}
+	idParam := OpenAPIParameter{Name: "id", In: "path", Required: true, Schema: &OpenAPISchema{Type: "integer", Format: "int64"}}
+	fieldsParam := OpenAPIParameter{Name: "fields", In: "query", Description: "Comma separated list of fields to return",
+		Schema: &OpenAPISchema{Type: "string"}}
+	notFound := &OpenAPIResponse{Description: "Record not found"}
+	doc.Paths[fmt.Sprintf("/api/%s", name)] = &OpenAPIPathItem{
+		Get: &OpenAPIOperation{
+			OperationID: fmt.Sprintf("search%s", name),
+			Summary:     fmt.Sprintf("Search %s records", name),
+			Tags:        []string{name},
+			Parameters: []OpenAPIParameter{
+				fieldsParam,
+				{Name: "domain", In: "query", Description: "JSON or Python literal serialized domain to filter records on", Schema: &OpenAPISchema{Type: "string"}},
+				{Name: "order", In: "query", Description: "Comma separated list of order expressions", Schema: &OpenAPISchema{Type: "string"}},
+				{Name: "limit", In: "query", Schema: &OpenAPISchema{Type: "integer"}},
+				{Name: "offset", In: "query", Schema: &OpenAPISchema{Type: "integer"}},
+			},
+			Responses: map[string]*OpenAPIResponse{
+				"200": {
+					Description: fmt.Sprintf("Matching %s records", name),
+					Content: jsonContent(&OpenAPISchema{
+						Type: "object",
+						Properties: map[string]*OpenAPISchema{
+							"count":   {Type: "integer"},
+							"records": {Type: "array", Items: ref},
+						},
+					}),
+				},
+			},
+		},
+		Post: &OpenAPIOperation{
+			OperationID: fmt.Sprintf("create%s", name),
+			Summary:     fmt.Sprintf("Create a %s record", name),
+			Tags:        []string{name},
+			Parameters:  []OpenAPIParameter{fieldsParam},
+			RequestBody: &OpenAPIRequestBody{Required: true, Content: jsonContent(ref)},
+			Responses: map[string]*OpenAPIResponse{
+				"201": {Description: fmt.Sprintf("Created %s record", name), Content: jsonContent(ref)},
+			},
+		},
+	}
+	doc.Paths[fmt.Sprintf("/api/%s/{id}", name)] = &OpenAPIPathItem{
+		Get: &OpenAPIOperation{
+			OperationID: fmt.Sprintf("read%s", name),
+			Summary:     fmt.Sprintf("Read a %s record", name),
+			Tags:        []string{name},
+			Parameters:  []OpenAPIParameter{idParam, fieldsParam},
+			Responses: map[string]*OpenAPIResponse{
+				"200": {Description: fmt.Sprintf("%s record", name), Content: jsonContent(ref)},
+				"404": notFound,
+			},
+		},
+		Patch: &OpenAPIOperation{
+			OperationID: fmt.Sprintf("write%s", name),
+			Summary:     fmt.Sprintf("Update a %s record", name),
+			Tags:        []string{name},
+			Parameters:  []OpenAPIParameter{idParam, fieldsParam},
+			RequestBody: &OpenAPIRequestBody{Required: true, Content: jsonContent(ref)},
+			Responses: map[string]*OpenAPIResponse{
+				"200": {Description: fmt.Sprintf("Updated %s record", name), Content: jsonContent(ref)},
+				"404": notFound,
+			},
+		},
+		Delete: &OpenAPIOperation{
+			OperationID: fmt.Sprintf("unlink%s", name),
+			Summary:     fmt.Sprintf("Delete a %s record", name),
+			Tags:        []string{name},
+			Parameters:  []OpenAPIParameter{idParam},
+			Responses: map[string]*OpenAPIResponse{
+				"204": {Description: "Record deleted"},
+				"404": notFound,
+			},
+		},
+	}
+}
+
+// addRPCPath adds the /rpc/model path to this document.
+// calls are the references to the params schemas of all the methods
+func (doc *OpenAPIDocument) addRPCPath(calls []*OpenAPISchema) {
+	request := &OpenAPISchema{
+		Type: "object",
+		Properties: map[string]*OpenAPISchema{
+			"jsonrpc": {Type: "string", Enum: []interface{}{"2.0"}},
+			"id":      {Type: "integer", Format: "int64"},
+			"method":  {Type: "string"},
+			"params":  {OneOf: calls},
+		},
+		Required: []string{"jsonrpc", "id", "params"},
+	}
+	doc.Paths["/rpc/model"] = &OpenAPIPathItem{
+		Post: &OpenAPIOperation{
+			OperationID: "callModelMethod",
+			Summary:     "Call a method on records of a model",
+			Tags:        []string{"rpc"},
+			RequestBody: &OpenAPIRequestBody{
+				Required: true,
+				Content: map[string]*OpenAPIMediaType{
+					"application/json": {Schema: &OpenAPISchema{OneOf: []*OpenAPISchema{request, {Type: "array", Items: request}}}},
+				},
+			},
+			Responses: map[string]*OpenAPIResponse{
+				"200": {Description: "JSON-RPC response or batch of responses"},
+			},
+		},
+	}
+}
+
+// openAPIMethodCallSchema returns the schema of the params of a call to the given
+// method through /rpc/model. The second returned value is false if a parameter or
+// the result of the method cannot be represented in JSON.
+func openAPIMethodCallSchema(model *models.Model, method *models.Method) (*OpenAPISchema, bool) {
+	methType := method.MethodType()
+	if methType == nil {
+		return nil, false
+	}
+	var params []*OpenAPISchema
+	for i := 1; i < methType.NumIn(); i++ {
+		paramType := methType.In(i)
+		if methType.IsVariadic() && i == methType.NumIn()-1 {
+			paramType = paramType.Elem()
+		}
+		schema, ok := openAPITypeSchema(paramType, make(map[reflect.Type]bool))
+		if !ok {
+			return nil, false
+		}
+		params = append(params, schema)
+	}
+	if methType.NumOut() > 0 {
+		if _, ok := openAPITypeSchema(methType.Out(0), make(map[reflect.Type]bool)); !ok {
+			return nil, false
+		}
+	}
+	minArgs := len(params)
+	var maxArgs *int
+	if methType.IsVariadic() {
+		minArgs--
+	} else {
+		maxArgs = &minArgs
+	}
+	argsSchema := OpenAPISchema{
+		Type:       "array",
+		MinItems:   &minArgs,
+		MaxItems:   maxArgs,
+		Items:      &OpenAPISchema{},
+		Parameters: params,
+	}
+	return &OpenAPISchema{
+		Type:        "object",
+		Title:       fmt.Sprintf("%s.%s", model.Name(), method.Name()),
+		Description: method.Doc(),
+		Properties: map[string]*OpenAPISchema{
+			"model":  {Type: "string", Enum: []interface{}{model.Name()}},
+			"method": {Type: "string", Enum: []interface{}{method.Name()}},
+			"ids":    {Type: "array", Items: &OpenAPISchema{Type: "integer", Format: "int64"}},
+			"args":   &argsSchema,
+			"kwargs": {
+				Type:       "object",
+				Properties: map[string]*OpenAPISchema{"context": {Type: "object"}},
+			},
+		},
+		Required: []string{"model", "method"},
+	}, true
+}
+
+// openAPITypeSchema returns the schema of the JSON representation of values
+// of the given type, as converted by the /rpc/model controller.
+// The second returned value is false if typ cannot be represented in JSON.
+//
+// visited holds the struct types being described to stop recursion.
+func openAPITypeSchema(typ reflect.Type, visited map[reflect.Type]bool) (*OpenAPISchema, bool) {
+	switch {
+	case typ == recordCollectionType || typ == recordSetType:
+		return &OpenAPISchema{
+			Type: "object",
+			Properties: map[string]*OpenAPISchema{
+				"model": {Type: "string"},
+				"ids":   {Type: "array", Items: &OpenAPISchema{Type: "integer", Format: "int64"}},
+			},
+		}, true
+	case typ.Implements(recordSetType):
+		return &OpenAPISchema{Type: "array", Items: &OpenAPISchema{Type: "integer", Format: "int64"}}, true
+	case typ == fieldMapType || typ == fieldMapperType:
+		return &OpenAPISchema{Type: "object"}, true
+	case typ == fieldNamerType:
+		return &OpenAPISchema{Type: "string"}, true
+	case typ == conditionType:
+		return &OpenAPISchema{Type: "array", Description: "Serialized domain", Items: &OpenAPISchema{}}, true
+	case typ == dateType:
+		return &OpenAPISchema{Type: "string", Format: "date"}, true
+	case typ == dateTimeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}, true
+	case typ.Implements(jsonMarshalerType):
+		return &OpenAPISchema{}, true
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}, true
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}, true
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}, true
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}, true
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}, true
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}, true
+	case reflect.Interface:
+		if typ.NumMethod() > 0 {
+			return nil, false
+		}
+		return &OpenAPISchema{}, true
+	case reflect.Ptr:
+		res, ok := openAPITypeSchema(typ.Elem(), visited)
+		if ok {
+			res.Nullable = true
+		}
+		return res, ok
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}, true
+		}
+		items, ok := openAPITypeSchema(typ.Elem(), visited)
+		if !ok {
+			return nil, false
+		}
+		return &OpenAPISchema{Type: "array", Items: items}, true
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String {
+			return nil, false
+		}
+		values, ok := openAPITypeSchema(typ.Elem(), visited)
+		if !ok {
+			return nil, false
+		}
+		return &OpenAPISchema{Type: "object", AdditionalProperties: values}, true
+	case reflect.Struct:
+		if visited[typ] {
+			return &OpenAPISchema{Type: "object"}, true
+		}
+		visited[typ] = true
+		defer delete(visited, typ)
+		res := OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.PkgPath != "" {
+				// unexported field
+				continue
+			}
+			if field.Anonymous {
+				// We do not flatten embedded structs
+				return nil, false
+			}
+			name := field.Name
+			if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			fSchema, ok := openAPITypeSchema(field.Type, visited)
+			if !ok {
+				return nil, false
+			}
+			res.Properties[name] = fSchema
+		}
+		if len(res.Properties) == 0 && typ.NumField() > 0 {
+			// Only unexported fields, such as Environment
+			return nil, false
+		}
+		return &res, true
+	}
+	return nil, false
+}
@@ -0,0 +1,394 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/server"
+)
+
+const (
+	// restDefaultLimit is the number of records returned by a
+	// REST search if no limit is given.
+	restDefaultLimit = 80
+	// restMaxLimit is the maximum number of records returned by a REST search
+	restMaxLimit = 1000
+)
+
+// A restSearchResult is the response of a REST search
+type restSearchResult struct {
+	Count   int               `json:"count"`
+	Records []models.FieldMap `json:"records"`
+}
+
+// declareRESTControllers adds the /api routes to the registry
+func declareRESTControllers() {
+	api := Registry.AddGroup("/api")
+	api.AddMiddleWare(restAuthenticate)
+	api.AddController(http.MethodGet, "/:model", RESTSearch)
+	api.AddController(http.MethodGet, "/:model/:id", RESTRead)
+	api.AddController(http.MethodPost, "/:model", RESTCreate)
+	api.AddController(http.MethodPatch, "/:model/:id", RESTWrite)
+	api.AddController(http.MethodDelete, "/:model/:id", RESTUnlink)
+}
+
+// restAuthenticate is the middleware of the /api routes.
+//
+// Requests with an "Authorization: Bearer" header are authenticated with
+// API keys. Other requests must have a user logged in their session.
+func restAuthenticate(c *server.Context) {
+	if strings.HasPrefix(c.Request.Header.Get("Authorization"), "Bearer ") {
+		server.BearerAuth()(c)
+		return
+	}
+	if c.UID() == 0 {
+		restError(c, http.StatusUnauthorized, errors.New("authentication required"))
+	}
+}
+
+// RESTSearch returns the records of the model given in the URL.
+//
+// The following query parameters are accepted:
+//
+// - fields: comma separated list of fields to return. All fields if omitted.
+//
+// - domain: JSON or Python literal serialized domain to filter records on
+// (see models.Model.ParseDomain).
+//
+// - order: comma separated list of order expressions, such as "Name desc".
+//
+// - limit and offset for paging. limit defaults to 80 and must be between 1
+// and 1000, offset must not be negative.
+func RESTSearch(c *server.Context) {
+	limit, err := restIntQuery(c, "limit", restDefaultLimit, 1, restMaxLimit)
+	if err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	offset, err := restIntQuery(c, "offset", 0, 0, math.MaxInt32)
+	if err != nil {
+		restError(c, http.StatusBadRequest, err)
+		return
+	}
+	model, ok := restModel(c)
+	if !ok {
+		return
+	}
+	cond, err := model.ParseDomain(c.Query("domain"))
+	if err != nil {
+		restError(c, http.StatusBadRequest, fmt.Errorf("invalid domain: %s", err))
+		return
+	}
+	var res restSearchResult
+	restExecute(c, func(env models.Environment) {
+		rs := env.Pool(model.Name())
+		if cond != nil {
+			rs = rs.Call("Search", cond).(models.RecordSet).Collection()
+		} else {
+			rs = rs.Call("FetchAll").(models.RecordSet).Collection()
+		}
+		res.Count = rs.Call("SearchCount").(int)
+		if order := c.Query("order"); order != "" {
+			rs = rs.Call("OrderBy", strings.Split(order, ",")).(models.RecordSet).Collection()
+		}
+		rs = rs.Call("Limit", limit).(models.RecordSet).Collection()
+		rs = rs.Call("Offset", offset).(models.RecordSet).Collection()
+		res.Records = restReadRecords(rs, restFields(c))
+	}, func() {
+		c.JSON(http.StatusOK, res)
+	})
+}
+
+// RESTRead returns the record with the id and model given in the URL.
+// The fields query parameter restricts the returned fields.
+func RESTRead(c *server.Context) {
+	model, ok := restModel(c)
+	if !ok {
+		return
+	}
+	id, ok := restID(c)
+	if !ok {
+		return
+	}
+	var records []models.FieldMap
+	restExecute(c, func(env models.Environment) {
+		rs := restRecord(env, model, id)
+		if rs.IsEmpty() {
+			return
+		}
+		records = restReadRecords(rs, restFields(c))
+	}, func() {
+		if len(records) == 0 {
+			restError(c, http.StatusNotFound, fmt.Errorf("record %d not found", id))
+			return
+		}
+		c.JSON(http.StatusOK, records[0])
+	})
+}
+
+// RESTCreate creates a record of the model given in the URL with
+// the values given as a JSON object in the request body.
+//
+// It returns the created record with status 201.
+func RESTCreate(c *server.Context) {
+	model, ok := restModel(c)
+	if !ok {
+		return
+	}
+	var data models.FieldMap
+	if err := json.NewDecoder(c.Request.Body).Decode(&data); err != nil {
+		restError(c, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %s", err))
+		return
+	}
+	var records []models.FieldMap
+	restExecute(c, func(env models.Environment) {
+		rs := env.Pool(model.Name())
+		rs = rs.Call("Create", restConvertValues(rs, data)).(models.RecordSet).Collection()
+		records = restReadRecords(rs, restFields(c))
+	}, func() {
+		c.JSON(http.StatusCreated, records[0])
+	})
+}
+
+// RESTWrite updates the record with the id and model given in the URL with
+// the values given as a JSON object in the request body.
+//
+// It returns the updated record.
+func RESTWrite(c *server.Context) {
+	model, ok := restModel(c)
+	if !ok {
+		return
+	}
+	id, ok := restID(c)
+	if !ok {
+		return
+	}
+	var data models.FieldMap
+	if err := json.NewDecoder(c.Request.Body).Decode(&data); err != nil {
+		restError(c, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %s", err))
+		return
+	}
+	var records []models.FieldMap
+	restExecute(c, func(env models.Environment) {
+		rs := restRecord(env, model, id)
+		if rs.IsEmpty() {
+			return
+		}
+		rs.Call("Write", restConvertValues(rs, data))
+		records = restReadRecords(rs, restFields(c))
+	}, func() {
+		if len(records) == 0 {
+			restError(c, http.StatusNotFound, fmt.Errorf("record %d not found", id))
+			return
+		}
+		c.JSON(http.StatusOK, records[0])
+	})
+}
+
+// RESTUnlink deletes the record with the id and model given in the URL.
+//
+// It returns status 204 on success and status 404 if the record does not
+// exist or cannot be deleted by the user because of record rules.
+func RESTUnlink(c *server.Context) {
+	model, ok := restModel(c)
+	if !ok {
+		return
+	}
+	id, ok := restID(c)
+	if !ok {
+		return
+	}
+	var found bool
+	restExecute(c, func(env models.Environment) {
+		rs := restRecord(env, model, id)
+		if rs.IsEmpty() {
+			return
+		}
+		found = rs.Call("Unlink").(int64) > 0
+	}, func() {
+		if !found {
+			restError(c, http.StatusNotFound, fmt.Errorf("record %d not found", id))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// restModel returns the model given in the URL of the request. It accepts
+// both model names and table names. If the model does not exist or is a
+// mixin, the request is aborted with a 404 status and false is returned.
+func restModel(c *server.Context) (*models.Model, bool) {
+	model, ok := models.Registry.Get(c.Param("model"))
+	if !ok || model.IsMixin() {
+		restError(c, http.StatusNotFound, fmt.Errorf("unknown model %s", c.Param("model")))
+		return nil, false
+	}
+	return model, true
+}
+
+// restRecord returns the record of model with the given id. The returned
+// RecordCollection is empty if the record does not exist or if it is hidden
+// to the user of env by record rules.
+func restRecord(env models.Environment, model *models.Model, id int64) models.RecordCollection {
+	rs := env.Pool(model.Name())
+	return rs.Call("Search", model.Field("ID").Equals(id)).(models.RecordSet).Collection().Fetch()
+}
+
+// restID returns the record id given in the URL of the request. If the id is
+// not a valid integer, the request is aborted with a 400 status and false is returned.
+func restID(c *server.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		restError(c, http.StatusBadRequest, fmt.Errorf("invalid id %s", c.Param("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+// restIntQuery returns the integer value of the given query parameter,
+// or defaultValue if it is not set. It returns an error if the value is
+// not an integer between min and max.
+func restIntQuery(c *server.Context, key string, defaultValue, min, max int) (int, error) {
+	val := c.Query(key)
+	if val == "" {
+		return defaultValue, nil
+	}
+	res, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter: %s", key, val)
+	}
+	if res < min || res > max {
+		return 0, fmt.Errorf("invalid %s parameter: %d is not between %d and %d", key, res, min, max)
+	}
+	return res, nil
+}
+
+// restFields returns the list of fields given in the fields query parameter
+func restFields(c *server.Context) []string {
+	fields := c.Query("fields")
+	if fields == "" {
+		return nil
+	}
+	return strings.Split(fields, ",")
+}
+
+// restExecute executes fnct in a new Environment with the authenticated user
+// and calls onSuccess if the transaction has been committed.
+// Otherwise, it writes the error in the response.
+func restExecute(c *server.Context, fnct func(models.Environment), onSuccess func()) {
+	err := models.ExecuteInNewEnvironment(c.UID(), fnct)
+	if err != nil {
+		restError(c, restErrorStatus(err), err)
+		return
+	}
+	onSuccess()
+}
+
+// restErrorStatus returns the HTTP status of the response to a request
+// that failed with the given error.
+func restErrorStatus(err error) int {
+	switch err.(type) {
+	case models.AccessError:
+		return http.StatusForbidden
+	case models.ValidationError, models.UserWarning:
+		return http.StatusBadRequest
+	case models.MissingError:
+		return http.StatusNotFound
+	case models.ConcurrencyError:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// restError aborts the request with the given status and the
+// first line of the given error message as JSON.
+// The user message is given for models errors.
+func restError(c *server.Context, status int, err error) {
+	msg := strings.SplitN(err.Error(), "\n", 2)[0]
+	if mErr, ok := err.(models.Error); ok {
+		msg = mErr.UserMessage()
+	}
+	c.JSON(status, gin.H{"error": msg})
+	c.Abort()
+}
+
+// restReadRecords reads the given fields of the records of rs and returns them
+// in a form suitable for JSON serialization. If fields is empty, all fields are read.
+//
+// Relation fields are returned as an id (or nil) for to-one relations and
+// as a list of ids for to-many relations.
+func restReadRecords(rs models.RecordCollection, fields []string) []models.FieldMap {
+	fInfos := rs.Call("FieldsGet", models.FieldsGetArgs{}).(map[string]*models.FieldInfo)
+	if len(fields) == 0 {
+		for fName := range fInfos {
+			fields = append(fields, fName)
+		}
+	}
+	res := rs.Call("Read", fields).([]models.FieldMap)
+	for _, rec := range res {
+		for fName, value := range rec {
+			relRS, ok := value.(models.RecordSet)
+			if !ok {
+				continue
+			}
+			fInfo, ok := fInfos[rs.Model().JSONizeFieldName(fName)]
+			if ok && fInfo.Type.Is2OneRelationType() {
+				rec[fName] = nil
+				if relRS.Len() > 0 {
+					rec[fName] = relRS.Ids()[0]
+				}
+				continue
+			}
+			rec[fName] = relRS.Ids()
+		}
+	}
+	return res
+}
+
+// restConvertValues converts the given values decoded from JSON so that they
+// can be used to create or update records of rs.
+//
+// Relation fields are expected as an id for to-one relations and as a list
+// of ids for to-many relations.
+func restConvertValues(rs models.RecordCollection, data models.FieldMap) models.FieldMap {
+	fInfos := rs.Call("FieldsGet", models.FieldsGetArgs{}).(map[string]*models.FieldInfo)
+	res := make(models.FieldMap)
+	for fName, value := range data {
+		res[fName] = value
+		fInfo, ok := fInfos[rs.Model().JSONizeFieldName(fName)]
+		if !ok {
+			continue
+		}
+		switch {
+		case fInfo.Type.Is2OneRelationType():
+			if id, ok := value.(float64); ok {
+				res[fName] = int64(id)
+			}
+		case fInfo.Type.Is2ManyRelationType():
+			vals, _ := value.([]interface{})
+			ids := make([]int64, 0, len(vals))
+			for _, v := range vals {
+				if id, ok := v.(float64); ok {
+					ids = append(ids, int64(id))
+				}
+			}
+			res[fName] = ids
+		case fInfo.Type == fieldtype.Integer:
+			if val, ok := value.(float64); ok {
+				res[fName] = int64(val)
+			}
+		}
+	}
+	return res
+}
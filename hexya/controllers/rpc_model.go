@@ -0,0 +1,183 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/server"
+)
+
+// A modelCallParams holds the parameters of a call to the /rpc/model route
+type modelCallParams struct {
+	Model  string                     `json:"model"`
+	Method string                     `json:"method"`
+	IDs    []int64                    `json:"ids"`
+	Args   []json.RawMessage          `json:"args"`
+	KWArgs map[string]json.RawMessage `json:"kwargs"`
+}
+
+var (
+	recordCollectionType = reflect.TypeOf(models.RecordCollection{})
+	recordSetType        = reflect.TypeOf((*models.RecordSet)(nil)).Elem()
+	fieldMapperType      = reflect.TypeOf((*models.FieldMapper)(nil)).Elem()
+	fieldNamerType       = reflect.TypeOf((*models.FieldNamer)(nil)).Elem()
+	conditionType        = reflect.TypeOf(&models.Condition{})
+)
+
+// declareRPCModelControllers adds the /rpc/model route to the registry.
+func declareRPCModelControllers() {
+	rpc := Registry.AddGroup("/rpc")
+	rpc.AddMiddleWare(restAuthenticate)
+	rpc.AddController(http.MethodPost, "/model", ModelCall)
+}
+
+// ModelCall is the controller of the /rpc/model route.
+//
+// It expects a JSON-RPC request (or a batch of requests) whose params are
+// {"model", "method", "ids", "args", "kwargs"} and calls the given method on
+// the records of the given model with the given ids. The call is executed
+// in a new transaction as the authenticated user, so that access rights,
+// record rules and method permissions apply.
+//
+// args are converted to the types of the method parameters by
+// models.ConvertJSONArgs. RecordSet parameters are given as an id or a list
+// of ids for typed RecordSets, and as {"model": ..., "ids": [...]} otherwise.
+// Conditions are given as serialized domains, either as lists or as strings,
+// whose field paths are checked (see models.Model.ParseDomain). The only supported kwarg is "context"
+// which sets the context of the call.
+//
+// RecordSets returned by the method are sent back as lists of ids.
+//
+// Requests must be authenticated, either with a session or with an API key
+// (see restAuthenticate). Anonymous requests are rejected with status 401.
+func ModelCall(c *server.Context) {
+	if c.UID() == 0 {
+		restError(c, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []server.RequestRPC
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			c.JSON(http.StatusOK, rpcError(0, server.RPCParseError, "Parse error", err))
+			return
+		}
+		if len(reqs) == 0 {
+			c.JSON(http.StatusOK, rpcError(0, server.RPCInvalidRequest, "Invalid Request", errors.New("empty batch")))
+			return
+		}
+		res := make([]interface{}, len(reqs))
+		for i, req := range reqs {
+			res[i] = executeModelCall(c.UID(), req)
+		}
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	var req server.RequestRPC
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.JSON(http.StatusOK, rpcError(0, server.RPCParseError, "Parse error", err))
+		return
+	}
+	c.JSON(http.StatusOK, executeModelCall(c.UID(), req))
+}
+
+// executeModelCall executes the given model call request as the given
+// user and returns the ResponseRPC or ResponseError to send back.
+func executeModelCall(uid int64, req server.RequestRPC) interface{} {
+	var params modelCallParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return rpcError(req.ID, server.RPCInvalidRequest, "Invalid Request", err)
+	}
+	model, ok := models.Registry.Get(params.Model)
+	if !ok {
+		return rpcError(req.ID, server.RPCMethodNotFound, "Method not found", fmt.Errorf("unknown model %s", params.Model))
+	}
+	if !model.Methods().Has(params.Method) {
+		return rpcError(req.ID, server.RPCMethodNotFound, "Method not found",
+			fmt.Errorf("unknown method %s in model %s", params.Method, params.Model))
+	}
+	var context *types.Context
+	for key, val := range params.KWArgs {
+		if key != "context" {
+			return rpcError(req.ID, server.RPCInvalidParams, "Invalid params", fmt.Errorf("unsupported keyword argument %s", key))
+		}
+		context = types.NewContext()
+		if err := json.Unmarshal(val, context); err != nil {
+			return rpcError(req.ID, server.RPCInvalidParams, "Invalid params", err)
+		}
+	}
+	var (
+		res      interface{}
+		paramErr error
+	)
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		rc := env.Pool(params.Model)
+		if context != nil {
+			rc = rc.WithNewContext(context)
+		}
+		if len(params.IDs) > 0 {
+			rc = rc.Call("Browse", params.IDs).(models.RecordSet).Collection()
+		}
+		args, err := models.ConvertJSONArgs(rc.Env(), model, rc.MethodType(params.Method), params.Args)
+		if err != nil {
+			paramErr = err
+			return
+		}
+		res = convertRPCResult(rc.Call(params.Method, args...))
+	})
+	switch {
+	case paramErr != nil:
+		return rpcError(req.ID, server.RPCInvalidParams, "Invalid params", paramErr)
+	case err != nil:
+		return server.ResponseError{
+			JsonRPC: "2.0",
+			ID:      req.ID,
+			Error:   server.NewJSONRPCError(err),
+		}
+	}
+	return server.ResponseRPC{
+		JsonRPC: "2.0",
+		ID:      req.ID,
+		Result:  res,
+	}
+}
+
+// rpcError returns a ResponseError for the given request id, code, message and error
+func rpcError(id int64, code int, message string, err error) server.ResponseError {
+	return server.ResponseError{
+		JsonRPC: "2.0",
+		ID:      id,
+		Error: server.JSONRPCError{
+			Code:    code,
+			Message: message,
+			Data: server.JSONRPCErrorData{
+				Arguments: message,
+				Debug:     err.Error(),
+			},
+		},
+	}
+}
+
+// convertRPCResult converts the result of a method call so that it can be
+// marshalled to JSON. RecordSets are converted to their ids.
+func convertRPCResult(res interface{}) interface{} {
+	if rs, ok := res.(models.RecordSet); ok {
+		return rs.Collection().Ids()
+	}
+	return res
+}
@@ -113,6 +113,23 @@ func declareCRUDMethods() {
 			return rc.create(data)
 		})
 
+	commonMixin.AddMethod("CreateMulti",
+		`CreateMulti inserts records in the database from the given data with
+		multi-row queries. It requires the permission to execute Create.
+		If Create has been extended on this model, CreateMulti calls Create
+		for each record instead, so that the extensions are applied.
+		Returns the created RecordCollection, in the order of data.`,
+		func(rc RecordCollection, data []FieldMapper) RecordCollection {
+			if rc.model.methods.MustGet("Create").isExtended() {
+				ids := make([]int64, len(data))
+				for i, d := range data {
+					ids[i] = rc.Call("Create", d).(RecordSet).Ids()[0]
+				}
+				return rc.withIds(ids)
+			}
+			return rc.createMulti(data)
+		}).AllowGroup(security.GroupEveryone)
+
 	commonMixin.AddMethod("Read",
 		`Read reads the database and returns a slice of FieldMap of the given model`,
 		func(rc RecordCollection, fields []string) []FieldMap {
@@ -384,6 +401,12 @@ func declareSearchMethods() {
 			return rc.GroupBy(exprs...)
 		}).AllowGroup(security.GroupEveryone)
 
+	commonMixin.AddMethod("Having",
+		`Having returns a new grouped RecordSet whose aggregate rows are filtered with the given condition`,
+		func(rc RecordCollection, cond *Condition) RecordCollection {
+			return rc.Having(cond)
+		}).AllowGroup(security.GroupEveryone)
+
 	commonMixin.AddMethod("Aggregates",
 		`Aggregates returns the result of this RecordSet query, which must by a grouped query.`,
 		func(rc RecordCollection, exprs ...FieldNamer) []GroupAggregateRow {
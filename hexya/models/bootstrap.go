@@ -48,7 +48,24 @@ func BootStrap() {
 	processDepends()
 	checkFieldMethodsExist()
 	checkComputeMethodsSignature()
+	checkDefaultOrders()
 	setupSecurity()
+	setupCompanyRules()
+}
+
+// checkDefaultOrders panics if the default order of a model
+// cannot be used to order non grouped queries.
+func checkDefaultOrders() {
+	for _, mi := range Registry.registryByName {
+		if mi.isMixin() {
+			continue
+		}
+		for _, order := range mi.defaultOrder {
+			if _, suffix := splitGroupExpr(parseOrderExpr(mi, order).field); suffix != "" {
+				log.Panic("Default order expressions must be fields", "model", mi.name, "order", order)
+			}
+		}
+	}
 }
 
 // createModelLinks create links with related Model
@@ -93,6 +110,10 @@ func injectMixInModel(mixInMI, mi *Model) {
 	if mixed[modelCouple{model: mi, mixIn: mixInMI}] {
 		return
 	}
+	// Add mixIn default order if the target model has none
+	if len(mi.defaultOrder) == 0 {
+		mi.defaultOrder = mixInMI.defaultOrder
+	}
 	// Add mixIn fields
 	for fName, fi := range mixInMI.fields.registryByName {
 		if _, exists := mi.fields.registryByName[fName]; exists {
@@ -216,6 +237,7 @@ func syncRelatedFieldInfo() {
 func SyncDatabase() {
 	adapter := adapters[db.DriverName()]
 	dbTables := adapter.tables()
+	newComputedFields := make(map[*Model][]FieldNamer)
 	// Create or update existing tables
 	for tableName, model := range Registry.registryByTableName {
 		if model.isMixin() {
@@ -229,7 +251,9 @@ func SyncDatabase() {
 		if _, ok := dbTables[tableName]; !ok {
 			createDBTable(model.tableName)
 		}
-		updateDBColumns(model)
+		if fields := updateDBColumns(model); len(fields) > 0 {
+			newComputedFields[model] = fields
+		}
 		updateDBIndexes(model)
 	}
 	// Setup constraints
@@ -260,6 +284,24 @@ func SyncDatabase() {
 		}
 	}
 	updateDBSequences()
+	recomputeNewFields(newComputedFields)
+}
+
+// recomputeNewFields computes the values of the stored computed
+// fields of each model for which a column has just been created.
+func recomputeNewFields(fields map[*Model][]FieldNamer) {
+	if len(fields) == 0 {
+		return
+	}
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		for model, modelFields := range fields {
+			log.Info("Computing new stored fields", "model", model.name, "fields", modelFields)
+			env.Pool(model.name).FetchAll().Recompute(RecomputeBatchSize, modelFields...)
+		}
+	})
+	if err != nil {
+		log.Panic("Unable to compute new stored fields", "error", err)
+	}
 }
 
 // buildSQLErrorSubstitutionMap populates the sqlErrors map of the
@@ -332,8 +374,16 @@ func dropDBTable(tableName string) {
 }
 
 // updateDBColumns synchronizes the colums of the database with the
-// given Model.
-func updateDBColumns(mi *Model) {
+// given Model. It returns the stored computed fields whose column has
+// just been created, so that their values can be computed.
+//
+// If the parent_path column is created, the paths of existing records
+// are computed once all the columns have been created.
+func updateDBColumns(mi *Model) []FieldNamer {
+	var (
+		newComputedFields []FieldNamer
+		newParentPath     bool
+	)
 	adapter := adapters[db.DriverName()]
 	dbColumns := adapter.columns(mi.tableName)
 	// create or update columns from registry data
@@ -344,6 +394,12 @@ func updateDBColumns(mi *Model) {
 		dbColData, ok := dbColumns[colName]
 		if !ok {
 			createDBColumn(fi)
+			if fi.isComputedField() {
+				newComputedFields = append(newComputedFields, FieldName(fi.name))
+			}
+			if fi.name == "ParentPath" {
+				newParentPath = true
+			}
 		}
 		if dbColData.DataType != adapter.typeSQL(fi) {
 			updateDBColumnDataType(fi)
@@ -356,13 +412,20 @@ func updateDBColumns(mi *Model) {
 			dbColData.ColumnDefault.String != adapter.fieldSQLDefault(fi) {
 			updateDBColumnDefault(fi)
 		}
+		if _, ok := dbColumns[fi.textSearchColumn()]; fi.fullTextSearch && !ok {
+			createDBTextSearchColumn(fi)
+		}
 	}
 	// drop columns that no longer exist
 	for colName := range dbColumns {
-		if _, ok := mi.fields.registryByJSON[colName]; !ok {
+		if _, ok := mi.fields.registryByJSON[colName]; !ok && !mi.isTextSearchColumn(colName) {
 			dropDBColumn(mi.tableName, colName)
 		}
 	}
+	if newParentPath {
+		updateDBParentPaths(mi)
+	}
+	return newComputedFields
 }
 
 // createDBColumn insert the column described by Field in the database
@@ -378,6 +441,25 @@ func createDBColumn(fi *Field) {
 	dbExecuteNoTx(query)
 }
 
+// createDBTextSearchColumn creates the column that holds the text search vector
+// of the given Field in the database, and fills it for the existing records.
+//
+// Vectors are computed with the text search configuration of the field.
+func createDBTextSearchColumn(fi *Field) {
+	adapter := adapters[db.DriverName()]
+	tableName := adapter.quoteTableName(fi.model.tableName)
+	query := fmt.Sprintf(`
+		ALTER TABLE %s
+		ADD COLUMN %s tsvector
+	`, tableName, fi.textSearchColumn())
+	dbExecuteNoTx(query)
+	query = fmt.Sprintf(`
+		UPDATE %s
+		SET %s = %s
+	`, tableName, fi.textSearchColumn(), adapter.textSearchVectorSQL(fi.json, fi.textSearchConfig()))
+	dbExecuteNoTx(query)
+}
+
 // updateDBColumnDataType updates the data type in database for the given Field
 func updateDBColumnDataType(fi *Field) {
 	adapter := adapters[db.DriverName()]
@@ -511,6 +593,16 @@ func updateDBIndexes(m *Model) {
 		case !fi.index && indexInDB:
 			dropColumnIndex(m.tableName, colName)
 		}
+		if fi.fullTextSearch && fi.isStored() {
+			// Text search indexes are dropped with their column
+			tsColName := fi.textSearchColumn()
+			if !adapter.indexExists(m.tableName, fmt.Sprintf("%s_%s_index", m.tableName, tsColName)) {
+				createTextSearchIndex(m.tableName, tsColName)
+			}
+		}
+	}
+	if m.hasParentPathField() && !adapter.indexExists(m.tableName, fmt.Sprintf("%s_parent_path_pattern_index", m.tableName)) {
+		createParentPathIndex(m.tableName)
 	}
 }
 
@@ -523,6 +615,37 @@ func createColumnIndex(tableName, colName string) {
 	dbExecuteNoTx(query)
 }
 
+// createTextSearchIndex creates a GIN index for the text search
+// vector column colName in the given table
+func createTextSearchIndex(tableName, colName string) {
+	adapter := adapters[db.DriverName()]
+	query := fmt.Sprintf(`
+		CREATE INDEX %s ON %s USING gin (%s)
+	`, fmt.Sprintf("%s_%s_index", tableName, colName), adapter.quoteTableName(tableName), colName)
+	dbExecuteNoTx(query)
+}
+
+// createParentPathIndex creates an index on the parent_path column of
+// the given table that can be used for LIKE prefix matches.
+func createParentPathIndex(tableName string) {
+	adapter := adapters[db.DriverName()]
+	query := fmt.Sprintf(`
+		CREATE INDEX %s ON %s (parent_path text_pattern_ops)
+	`, fmt.Sprintf("%s_parent_path_pattern_index", tableName), adapter.quoteTableName(tableName))
+	dbExecuteNoTx(query)
+}
+
+// updateDBParentPaths sets the parent_path column of the records of
+// the given model's table if it has a ParentPath field. It is called
+// when the column is created, so that paths are computed for records
+// created before the field was added.
+func updateDBParentPaths(m *Model) {
+	if !m.hasParentPathField() {
+		return
+	}
+	dbExecuteNoTx(adapters[db.DriverName()].updateParentPathsQuery(m.tableName))
+}
+
 // dropColumnIndex drops a column index for colName in the given table
 func dropColumnIndex(tableName, colName string) {
 	query := fmt.Sprintf(`
@@ -0,0 +1,161 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	// busChannel is the database notification channel used by the bus
+	busChannel = "hexya_bus"
+	// busMaxIDs is the maximum number of ids sent in a single notification,
+	// so as to keep within the notification payload size limit of the database.
+	busMaxIDs = 500
+	// busBufferSize is the number of changes that can be waiting
+	// to be received by a subscription before they are dropped.
+	busBufferSize = 256
+)
+
+// Bus is the notification bus on which committed record changes are published
+var Bus *NotificationBus
+
+// A RecordChange describes records that have been created, updated
+// or deleted by a committed transaction.
+type RecordChange struct {
+	Model string `json:"model"`
+	// Event is the kind of change, with the same values as webhooks events.
+	Event WebhookEvent `json:"event"`
+	IDs   []int64      `json:"ids"`
+	// Fields are the JSON names of the updated fields for WebhookWrite events.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// A BusSubscription receives the record changes published on the
+// bus that its user is allowed to read.
+type BusSubscription struct {
+	uid int64
+	// C is the channel on which record changes are received
+	C <-chan RecordChange
+	c chan RecordChange
+}
+
+// A NotificationBus publishes the record changes of committed transactions
+// to its subscribers.
+//
+// Changes are sent through database notifications in the transaction that
+// makes them, so that they are only received if the transaction is committed
+// and by all the server instances listening to the same database.
+type NotificationBus struct {
+	sync.RWMutex
+	started       bool
+	subscriptions map[*BusSubscription]bool
+}
+
+// Start makes this server instance publish its record changes on the bus
+// and listen to the changes published by all instances.
+//
+// It must be called after connecting to the database.
+// It is a no-op if the bus is already started.
+func (nb *NotificationBus) Start() {
+	nb.Lock()
+	defer nb.Unlock()
+	if nb.started {
+		return
+	}
+	adapters[db.DriverName()].listen(dbConnData, busChannel, nb.dispatch)
+	nb.started = true
+	log.Info("Notification bus started")
+}
+
+// Subscribe returns a new BusSubscription for the user with the given uid.
+// Unsubscribe must be called when the subscription is not used anymore.
+func (nb *NotificationBus) Subscribe(uid int64) *BusSubscription {
+	nb.Lock()
+	defer nb.Unlock()
+	c := make(chan RecordChange, busBufferSize)
+	sub := &BusSubscription{
+		uid: uid,
+		C:   c,
+		c:   c,
+	}
+	nb.subscriptions[sub] = true
+	return sub
+}
+
+// Unsubscribe removes the given subscription from the bus and closes its channel.
+func (nb *NotificationBus) Unsubscribe(sub *BusSubscription) {
+	nb.Lock()
+	defer nb.Unlock()
+	if !nb.subscriptions[sub] {
+		return
+	}
+	delete(nb.subscriptions, sub)
+	close(sub.c)
+}
+
+// publishing returns true if record changes should be published on the bus
+func (nb *NotificationBus) publishing() bool {
+	nb.RLock()
+	defer nb.RUnlock()
+	return nb.started
+}
+
+// dispatch sends the RecordChange in the given notification payload to the
+// subscriptions whose user is allowed to read the records of its model.
+func (nb *NotificationBus) dispatch(payload string) {
+	var change RecordChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		log.Warn("Unable to unmarshal bus notification", "payload", payload, "error", err)
+		return
+	}
+	model, ok := Registry.Get(change.Model)
+	if !ok {
+		return
+	}
+	loadMethod := model.methods.MustGet("Load")
+	nb.RLock()
+	defer nb.RUnlock()
+	for sub := range nb.subscriptions {
+		if !loadMethod.isAllowed(sub.uid) {
+			continue
+		}
+		select {
+		case sub.c <- change:
+		default:
+			log.Warn("Bus subscription is full, dropping record change", "uid", sub.uid, "model", change.Model)
+		}
+	}
+}
+
+// NewNotificationBus returns a new NotificationBus which is not started
+func NewNotificationBus() *NotificationBus {
+	return &NotificationBus{
+		subscriptions: make(map[*BusSubscription]bool),
+	}
+}
+
+// publishChange sends a database notification for the given event on the records
+// with the given ids of rc's model. It is a no-op if the bus is not started or
+// if notifications are disabled in the Environment.
+func (rc RecordCollection) publishChange(event WebhookEvent, ids []int64, fields []string) {
+	if len(ids) == 0 || rc.model.isSystem() || rc.model.isM2MLink() || !Bus.publishing() || rc.env.noNotify {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	for i := 0; i < len(ids); i += busMaxIDs {
+		end := i + busMaxIDs
+		if end > len(ids) {
+			end = len(ids)
+		}
+		payload, _ := json.Marshal(RecordChange{
+			Model:  rc.model.name,
+			Event:  event,
+			IDs:    ids[i:end],
+			Fields: fields,
+		})
+		rc.env.cr.Execute(adapter.notifySQL(), busChannel, string(payload))
+	}
+}
@@ -25,6 +25,9 @@ import (
 type cache struct {
 	sync.RWMutex
 	data map[RecordRef]FieldMap
+	// touched holds the records added to the cache since
+	// each started tracking, from the oldest to the newest.
+	touched []map[RecordRef]bool
 }
 
 // addEntry to the cache. fieldName must be a simple field name (no path)
@@ -42,6 +45,9 @@ func (c *cache) addEntryByRef(ref RecordRef, jsonName string, value interface{})
 		c.data[ref] = make(FieldMap)
 	}
 	c.data[ref][jsonName] = value
+	for _, touched := range c.touched {
+		touched[ref] = true
+	}
 }
 
 // addRecord successively adds each entry of the given FieldMap to the cache.
@@ -73,6 +79,34 @@ func (c *cache) invalidateRecord(mi *Model, ID int64) {
 	delete(c.data, RecordRef{ModelName: mi.name, ID: ID})
 }
 
+// startTracking starts recording the records that are added to the cache
+// until the matching call to stopTracking. Trackings can be nested.
+func (c *cache) startTracking() {
+	c.Lock()
+	defer c.Unlock()
+	c.touched = append(c.touched, make(map[RecordRef]bool))
+}
+
+// stopTracking stops the last started tracking and returns the
+// records that have been added to the cache since it started.
+func (c *cache) stopTracking() map[RecordRef]bool {
+	c.Lock()
+	defer c.Unlock()
+	last := len(c.touched) - 1
+	res := c.touched[last]
+	c.touched = c.touched[:last]
+	return res
+}
+
+// invalidateRefs removes the given records from the cache
+func (c *cache) invalidateRefs(refs map[RecordRef]bool) {
+	c.Lock()
+	defer c.Unlock()
+	for ref := range refs {
+		delete(c.data, ref)
+	}
+}
+
 // get returns the cache value of the given fieldName
 // for the given modelName and ID. fieldName may be a path
 // relative to this Model (e.g. "User.Profile.Age").
@@ -37,6 +37,22 @@ type predicate struct {
 	isCond   bool
 }
 
+// splitAggregate returns the exprs of this predicate without the aggregate
+// function of HAVING predicates (e.g. 'Nums:sum'), and this function.
+func (p predicate) splitAggregate() ([]string, string) {
+	if len(p.exprs) == 0 {
+		return p.exprs, ""
+	}
+	last, aggFnct := splitGroupExpr(p.exprs[len(p.exprs)-1])
+	if aggFnct == "" {
+		return p.exprs, ""
+	}
+	res := make([]string, len(p.exprs))
+	copy(res, p.exprs)
+	res[len(res)-1] = last
+	return res, aggFnct
+}
+
 // A Condition represents a WHERE clause of an SQL query.
 type Condition struct {
 	predicates []predicate
@@ -280,6 +296,56 @@ func (c ConditionField) ChildOf(data interface{}) *Condition {
 	return c.AddOperator(operator.ChildOf, data)
 }
 
+// ParentOf appends the 'parent of' operator to the current Condition
+func (c ConditionField) ParentOf(data interface{}) *Condition {
+	return c.AddOperator(operator.ParentOf, data)
+}
+
+// Matches appends the full text search operator to the current Condition.
+//
+// The current field must have the FullTextSearch option and data is the
+// searched text. Its words are matched with the text search configuration
+// of the context language.
+func (c ConditionField) Matches(data interface{}) *Condition {
+	return c.AddOperator(operator.Matches, data)
+}
+
+// Any appends the 'any' operator to the current Condition.
+//
+// The current field must be a One2Many or Many2Many field and cond a
+// condition on its related model. The resulting condition matches
+// records with at least one related record matching cond.
+func (c ConditionField) Any(cond *Condition) *Condition {
+	return c.AddOperator(operator.Any, subQueryCondition(cond))
+}
+
+// None appends the 'not any' operator to the current Condition.
+//
+// The current field must be a One2Many or Many2Many field and cond a
+// condition on its related model. The resulting condition matches
+// records with no related record matching cond.
+func (c ConditionField) None(cond *Condition) *Condition {
+	return c.AddOperator(operator.None, subQueryCondition(cond))
+}
+
+// All appends the 'all' operator to the current Condition.
+//
+// The current field must be a One2Many or Many2Many field and cond a
+// condition on its related model. The resulting condition matches
+// records whose related records all match cond, including records
+// without related records.
+func (c ConditionField) All(cond *Condition) *Condition {
+	return c.AddOperator(operator.All, subQueryCondition(cond))
+}
+
+// subQueryCondition returns the given condition or an empty condition if it is nil
+func subQueryCondition(cond *Condition) *Condition {
+	if cond == nil {
+		return newCondition()
+	}
+	return cond
+}
+
 // IsNull checks if the current condition field is null
 func (c ConditionField) IsNull() *Condition {
 	return c.AddOperator(operator.Equals, nil)
@@ -309,7 +375,8 @@ func (c *Condition) IsEmpty() bool {
 func (c Condition) getAllExpressions(mi *Model) [][]string {
 	var res [][]string
 	for _, p := range c.predicates {
-		res = append(res, jsonizeExpr(mi, p.exprs))
+		exprs, _ := p.splitAggregate()
+		res = append(res, jsonizeExpr(mi, exprs))
 		if p.cond != nil {
 			res = append(res, p.cond.getAllExpressions(mi)...)
 		}
@@ -333,13 +400,13 @@ func (c *Condition) substituteExprs(mi *Model, substs map[string][]string) {
 }
 
 // substituteChildOfOperator recursively replaces in the condition the
-// predicates with ChildOf operator by the predicates to actually execute.
+// predicates with ChildOf or ParentOf operator by the predicates to actually execute.
 func (c *Condition) substituteChildOfOperator(rc RecordCollection) {
 	for i, p := range c.predicates {
 		if p.cond != nil {
 			p.cond.substituteChildOfOperator(rc)
 		}
-		if p.operator != operator.ChildOf {
+		if p.operator != operator.ChildOf && p.operator != operator.ParentOf {
 			continue
 		}
 		recModel := rc.model.getRelatedModelInfo(strings.Join(p.exprs, ExprSep))
@@ -348,19 +415,23 @@ func (c *Condition) substituteChildOfOperator(rc RecordCollection) {
 			c.predicates[i].operator = operator.Equals
 			continue
 		}
-		var parentIds []int64
-		rc.Env().Cr().Select(&parentIds, adapters[db.DriverName()].childrenIdsQuery(recModel.tableName), p.arg)
 		c.predicates[i].operator = operator.In
-		c.predicates[i].arg = parentIds
+		c.predicates[i].arg = recModel.hierarchyIds(rc.Env(), p.operator, p.arg)
 	}
 }
 
 // evaluateArgFunctions recursively evaluates all args in the queries that are
 // functions and substitute it with the result.
+//
+// Predicates and sub conditions are copied before being modified, so that
+// conditions shared between queries (such as record rules) keep their functions.
 func (c *Condition) evaluateArgFunctions(rc RecordCollection) {
+	c.predicates = append([]predicate(nil), c.predicates...)
 	for i, p := range c.predicates {
 		if p.cond != nil {
-			p.cond.evaluateArgFunctions(rc)
+			subCond := *p.cond
+			subCond.evaluateArgFunctions(rc)
+			c.predicates[i].cond = &subCond
 		}
 
 		fnctVal := reflect.ValueOf(p.arg)
@@ -18,6 +18,9 @@ import (
 )
 
 // LoadCSVDataFile loads the data of the given file into the database.
+//
+// Each line is loaded in its own savepoint, so that invalid lines
+// are logged and skipped without preventing the others to be loaded.
 func LoadCSVDataFile(fileName string) {
 	csvFile, err := os.Open(fileName)
 	defer csvFile.Close()
@@ -63,20 +66,25 @@ func LoadCSVDataFile(fileName string) {
 				break
 			}
 
-			values := getRecordValuesMap(headers, modelName, record, env, line, filepath.Dir(fileName))
+			err = env.Savepoint(func() {
+				values := getRecordValuesMap(headers, modelName, record, env, line, filepath.Dir(fileName))
 
-			externalID := values["id"]
-			delete(values, "id")
-			values["hexya_external_id"] = externalID
-			values["hexya_version"] = version
-			rec := rc.Call("Search", rc.Model().Field("HexyaExternalID").Equals(externalID)).(RecordCollection).Limit(1)
-			switch {
-			case rec.Len() == 0:
-				rc.Call("Create", values)
-			case rec.Len() == 1:
-				if version > rec.Get("HexyaVersion").(int) || update {
-					rec.Call("Write", values)
+				externalID := values["id"]
+				delete(values, "id")
+				values["hexya_external_id"] = externalID
+				values["hexya_version"] = version
+				rec := rc.Call("Search", rc.Model().Field("HexyaExternalID").Equals(externalID)).(RecordCollection).Limit(1)
+				switch {
+				case rec.Len() == 0:
+					rc.Call("Create", values)
+				case rec.Len() == 1:
+					if version > rec.Get("HexyaVersion").(int) || update {
+						rec.Call("Write", values)
+					}
 				}
+			})
+			if err != nil {
+				log.Warn("Skipping invalid line in data file", "fileName", fileName, "line", line, "error", err)
 			}
 			line++
 		}
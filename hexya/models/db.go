@@ -16,15 +16,18 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/operator"
 	"github.com/jmoiron/sqlx"
 )
 
 var (
-	db       *sqlx.DB
-	adapters map[string]dbAdapter
+	db         *sqlx.DB
+	dbConnData string
+	adapters   map[string]dbAdapter
 )
 
 // A ColumnData holds information from the db schema about one column
@@ -74,11 +77,56 @@ type dbAdapter interface {
 	// a record from table including itself. The query has a placeholder for the
 	// record's ID
 	childrenIdsQuery(table string) string
-	// substituteErrorMessage substitutes the given error's message by newMsg
-	substituteErrorMessage(err error, newMsg string) error
+	// parentIdsQuery returns a query that finds all ancestors of the given
+	// record from table including itself. The query has a placeholder for the
+	// record's ID
+	parentIdsQuery(table string) string
+	// moveParentPathQuery returns a query that replaces the prefix of the
+	// parent_path column of the records of table and returns their ids. The
+	// query has placeholders for the new prefix, the length of the old prefix
+	// plus one and the LIKE pattern of the records to update.
+	moveParentPathQuery(table string) string
+	// updateParentPathsQuery returns a query that sets the parent_path column
+	// of all the records of table from their parent_id column.
+	updateParentPathsQuery(table string) string
+	// newIdsQuery returns a query that allocates new ids from the id sequence
+	// of table. The query has a placeholder for the number of ids.
+	newIdsQuery(table string) string
+	// isConstraintError returns true if the given error is a
+	// constraint violation error, such as a unique or not null error.
+	isConstraintError(err error) bool
 	// isSerializationError returns true if the given error is a serialization error
 	// and that the failed transaction should be retried.
 	isSerializationError(err error) bool
+	// skipLockedSQL returns the SQL clause that locks the selected rows
+	// for update, skipping the rows already locked by other transactions.
+	skipLockedSQL() string
+	// notifySQL returns the SQL query that sends a notification on a channel
+	// when the transaction is committed. The query has placeholders for the
+	// channel and the payload.
+	notifySQL() string
+	// dateTruncSQL returns the SQL expression that truncates the given
+	// expr of type fType (Date or DateTime) to the given granularity.
+	// DateTime values are truncated in the given timezone.
+	dateTruncSQL(granularity, expr string, fType fieldtype.Type, timezone string) string
+	// aggregateSQL returns the SQL expression that applies the
+	// given aggregate function (e.g. AggregateSum) to expr.
+	aggregateSQL(fnct, expr string) string
+	// textSearchConfig returns the text search configuration
+	// to use for the given language code (e.g. "fr_FR").
+	textSearchConfig(lang string) string
+	// textSearchVectorSQL returns the SQL expression of the text
+	// search vector of expr in the given configuration.
+	textSearchVectorSQL(expr, config string) string
+	// textSearchMatchSQL returns the SQL expression that matches the text
+	// search vector expr with a search text given as placeholder.
+	textSearchMatchSQL(expr, config string) string
+	// textSearchRankSQL returns the SQL expression of the relevance of the
+	// text search vector expr for a search text given as placeholder.
+	textSearchRankSQL(expr, config string) string
+	// listen opens a new connection to the database with connData and
+	// calls handler with the payload of each notification sent on channel.
+	listen(connData, channel string, handler func(payload string))
 }
 
 // registerDBAdapter adds a adapter to the adapters registry
@@ -89,7 +137,8 @@ func registerDBAdapter(name string, adapter dbAdapter) {
 
 // Cursor is a wrapper around a database transaction
 type Cursor struct {
-	tx *sqlx.Tx
+	tx         *sqlx.Tx
+	savepoints int
 }
 
 // Execute a query without returning any rows. It panics in case of error.
@@ -110,6 +159,25 @@ func (c *Cursor) Select(dest interface{}, query string, args ...interface{}) {
 	dbSelect(c.tx, dest, query, args...)
 }
 
+// savepoint creates a new savepoint in the transaction and returns its name
+func (c *Cursor) savepoint() string {
+	c.savepoints++
+	name := fmt.Sprintf("hexya_savepoint_%d", c.savepoints)
+	c.Execute(fmt.Sprintf("SAVEPOINT %s", name))
+	return name
+}
+
+// rollbackToSavepoint rolls back the transaction to the savepoint with the given name
+func (c *Cursor) rollbackToSavepoint(name string) {
+	c.Execute(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", name))
+}
+
+// releaseSavepoint destroys the savepoint with the given name, keeping
+// the changes made since it was created.
+func (c *Cursor) releaseSavepoint(name string) {
+	c.Execute(fmt.Sprintf("RELEASE SAVEPOINT %s", name))
+}
+
 // newCursor returns a new db cursor on the given database
 func newCursor(db *sqlx.DB) *Cursor {
 	adapter := adapters[db.DriverName()]
@@ -125,6 +193,7 @@ func newCursor(db *sqlx.DB) *Cursor {
 // connection data.
 func DBConnect(driver, connData string) {
 	db = sqlx.MustConnect(driver, connData)
+	dbConnData = connData
 	log.Info("Connected to database", "driver", driver, "connData", connData)
 }
 
@@ -218,8 +287,10 @@ func sanitizeQuery(query string, args ...interface{}) (string, []interface{}) {
 }
 
 // Log the result of the given sql query started at start time with the
-// given args, and error. This function panics after logging if error is not nil.
+// given args, and error. Values of encrypted fields are hidden from the logs.
+// This function panics after logging if error is not nil.
 func logSQLResult(err error, start time.Time, query string, args ...interface{}) {
+	args = hideEncryptedArgs(args)
 	logCtx := log.New("query", query, "args", args, "duration", time.Now().Sub(start))
 	if err != nil {
 		// We don't log.Panic to keep db error information in recovery
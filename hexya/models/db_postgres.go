@@ -16,6 +16,8 @@ package models
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/operator"
@@ -70,6 +72,27 @@ var pgDefaultValues = map[fieldtype.Type]string{
 	fieldtype.Selection: "''",
 }
 
+// pgTextSearchConfigs maps language codes to
+// the built-in text search configurations
+var pgTextSearchConfigs = map[string]string{
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"it": "italian",
+	"nb": "norwegian",
+	"nl": "dutch",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+}
+
 // operatorSQL returns the sql string and placeholders for the given DomainOperator
 // Also modifies the given args to match the syntax of the operator.
 func (d *postgresAdapter) operatorSQL(do operator.Operator, arg interface{}) (string, interface{}) {
@@ -83,6 +106,9 @@ func (d *postgresAdapter) operatorSQL(do operator.Operator, arg interface{}) (st
 
 // typeSQL returns the sql type string for the given Field
 func (d *postgresAdapter) typeSQL(fi *Field) string {
+	if fi.encrypted {
+		return "text"
+	}
 	typ, _ := pgTypes[fi.fieldType]
 	return typ
 }
@@ -95,12 +121,15 @@ func (d *postgresAdapter) columnSQLDefinition(fi *Field) string {
 	if !ok {
 		log.Panic("Unknown column type", "type", fi.fieldType, "model", fi.model.name, "field", fi.name)
 	}
-	switch fi.fieldType {
-	case fieldtype.Char:
+	switch {
+	case fi.encrypted:
+		// Encrypted values are longer than clear values and are not binary
+		res = "text"
+	case fi.fieldType == fieldtype.Char:
 		if fi.size > 0 {
 			res = fmt.Sprintf("%s(%d)", res, fi.size)
 		}
-	case fieldtype.Float:
+	case fi.fieldType == fieldtype.Float:
 		emptyD := nbutils.Digits{}
 		if fi.digits != emptyD {
 			res = fmt.Sprintf("numeric(%d, %d)", fi.digits.Precision, fi.digits.Scale)
@@ -254,14 +283,76 @@ FROM    recursive_query_children_ids`, d.quoteTableName(table), d.quoteTableName
 	return res
 }
 
-// substituteErrorMessage substitutes the given error's message by newMsg
-func (d *postgresAdapter) substituteErrorMessage(err error, newMsg string) error {
-	pgError, ok := err.(*pq.Error)
-	if !ok {
-		return err
+// parentIdsQuery returns a query that finds all ancestors of the given
+// record from table including itself. The query has a placeholder for the
+// record's ID
+func (d *postgresAdapter) parentIdsQuery(table string) string {
+	res := fmt.Sprintf(`
+WITH RECURSIVE "recursive_query_parent_ids" AS
+(
+	SELECT  id, parent_id
+	FROM    %s "m1"
+	WHERE   id = ?
+UNION ALL
+	SELECT  "m2".id, "m2".parent_id
+	FROM    %s "m2"
+	JOIN    "recursive_query_parent_ids"
+	ON      "m2".id = "recursive_query_parent_ids".parent_id
+)
+SELECT  id
+FROM    recursive_query_parent_ids`, d.quoteTableName(table), d.quoteTableName(table))
+	return res
+}
+
+// moveParentPathQuery returns a query that replaces the prefix of the
+// parent_path column of the records of table and returns their ids. The
+// query has placeholders for the new prefix, the length of the old prefix
+// plus one and the LIKE pattern of the records to update.
+func (d *postgresAdapter) moveParentPathQuery(table string) string {
+	return fmt.Sprintf(`
+UPDATE  %s
+SET     parent_path = ? || substr(parent_path, ?)
+WHERE   parent_path LIKE ?
+RETURNING id`, d.quoteTableName(table))
+}
+
+// updateParentPathsQuery returns a query that sets the parent_path column
+// of all the records of table from their parent_id column.
+func (d *postgresAdapter) updateParentPathsQuery(table string) string {
+	res := fmt.Sprintf(`
+WITH RECURSIVE "recursive_query_parent_paths" AS
+(
+	SELECT  id, id::text || '/' AS parent_path
+	FROM    %s "m1"
+	WHERE   parent_id IS NULL
+UNION ALL
+	SELECT  "m2".id, "recursive_query_parent_paths".parent_path || "m2".id::text || '/'
+	FROM    %s "m2"
+	JOIN    "recursive_query_parent_paths"
+	ON      "m2".parent_id = "recursive_query_parent_paths".id
+)
+UPDATE  %s "m3"
+SET     parent_path = "recursive_query_parent_paths".parent_path
+FROM    "recursive_query_parent_paths"
+WHERE   "m3".id = "recursive_query_parent_paths".id
+AND     "m3".parent_path IS DISTINCT FROM "recursive_query_parent_paths".parent_path`,
+		d.quoteTableName(table), d.quoteTableName(table), d.quoteTableName(table))
+	return res
+}
+
+// newIdsQuery returns a query that allocates new ids from the id sequence
+// of table. The query has a placeholder for the number of ids.
+func (d *postgresAdapter) newIdsQuery(table string) string {
+	return fmt.Sprintf(`SELECT nextval(pg_get_serial_sequence('%s', 'id')) FROM generate_series(1, ?)`, d.quoteTableName(table))
+}
+
+// isConstraintError returns true if the given error is a
+// constraint violation error, such as a unique or not null error.
+func (d *postgresAdapter) isConstraintError(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Class() == "23" {
+		return true
 	}
-	pgError.Message = newMsg
-	return pgError
+	return false
 }
 
 // isSerializationError returns true if the given error is a serialization error
@@ -273,4 +364,92 @@ func (d *postgresAdapter) isSerializationError(err error) bool {
 	return false
 }
 
+// skipLockedSQL returns the SQL clause that locks the selected rows
+// for update, skipping the rows already locked by other transactions.
+func (d *postgresAdapter) skipLockedSQL() string {
+	return "FOR UPDATE SKIP LOCKED"
+}
+
+// notifySQL returns the SQL query that sends a notification on a channel
+// when the transaction is committed. The query has placeholders for the
+// channel and the payload.
+func (d *postgresAdapter) notifySQL() string {
+	return "SELECT pg_notify(?, ?)"
+}
+
+// dateTruncSQL returns the SQL expression that truncates the given
+// expr of type fType (Date or DateTime) to the given granularity.
+// DateTime values are truncated in the given timezone.
+func (d *postgresAdapter) dateTruncSQL(granularity, expr string, fType fieldtype.Type, timezone string) string {
+	if fType == fieldtype.Date {
+		return fmt.Sprintf("date_trunc('%s', %s)::date", granularity, expr)
+	}
+	timezone = strings.Replace(timezone, "'", "''", -1)
+	return fmt.Sprintf("date_trunc('%s', timezone('%s', timezone('UTC', %s)))", granularity, timezone, expr)
+}
+
+// aggregateSQL returns the SQL expression that applies the
+// given aggregate function (e.g. AggregateSum) to expr.
+//
+// Arrays are returned as comma separated strings of sorted values.
+func (d *postgresAdapter) aggregateSQL(fnct, expr string) string {
+	switch fnct {
+	case AggregateCountDistinct:
+		return fmt.Sprintf("count(DISTINCT %s)", expr)
+	case AggregateArray:
+		return fmt.Sprintf("array_to_string(array_agg(%s ORDER BY %s), ',')", expr, expr)
+	}
+	return fmt.Sprintf("%s(%s)", fnct, expr)
+}
+
+// textSearchConfig returns the text search configuration
+// to use for the given language code (e.g. "fr_FR").
+func (d *postgresAdapter) textSearchConfig(lang string) string {
+	code := strings.ToLower(strings.Split(lang, "_")[0])
+	if config, ok := pgTextSearchConfigs[code]; ok {
+		return config
+	}
+	return "simple"
+}
+
+// textSearchVectorSQL returns the SQL expression of the text
+// search vector of expr in the given configuration.
+func (d *postgresAdapter) textSearchVectorSQL(expr, config string) string {
+	return fmt.Sprintf("to_tsvector('%s', (%s)::text)", config, expr)
+}
+
+// textSearchMatchSQL returns the SQL expression that matches the text
+// search vector expr with a search text given as placeholder.
+func (d *postgresAdapter) textSearchMatchSQL(expr, config string) string {
+	return fmt.Sprintf("%s @@ plainto_tsquery('%s', ?)", expr, config)
+}
+
+// textSearchRankSQL returns the SQL expression of the relevance of the
+// text search vector expr for a search text given as placeholder.
+func (d *postgresAdapter) textSearchRankSQL(expr, config string) string {
+	return fmt.Sprintf("ts_rank(%s, plainto_tsquery('%s', ?))", expr, config)
+}
+
+// listen opens a new connection to the database with connData and
+// calls handler with the payload of each notification sent on channel.
+func (d *postgresAdapter) listen(connData, channel string, handler func(payload string)) {
+	listener := pq.NewListener(connData, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("Database listener error", "channel", channel, "error", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		log.Panic("Unable to listen to database notifications", "channel", channel, "error", err)
+	}
+	go func() {
+		for notification := range listener.Notify {
+			if notification == nil {
+				// The connection has been reestablished
+				continue
+			}
+			handler(notification.Extra)
+		}
+	}()
+}
+
 var _ dbAdapter = new(postgresAdapter)
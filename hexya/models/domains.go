@@ -0,0 +1,421 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+)
+
+// Domain logical operators in prefix notation
+const (
+	domainAnd = "&"
+	domainOr  = "|"
+	domainNot = "!"
+)
+
+// ParseDomain returns the Condition of the given serialized domain.
+//
+// domain must be a list in Odoo prefix notation, such as the one returned
+// by Condition.Serialize, e.g.
+//
+//	[]interface{}{"|", []interface{}{"Name", "=", "John"}, []interface{}{"Age", ">", 20}}
+//
+// Terms that are not joined by a logical operator are joined by AND.
+// It returns nil if the domain is empty and panics if it is malformed.
+//
+// Field paths are not checked. Use Model.ParseDomain to parse domains
+// that do not come from the code, such as client requests.
+func ParseDomain(domain []interface{}) *Condition {
+	res, err := parseDomain(domain)
+	if err != nil {
+		log.Panic("Invalid domain", "domain", domain, "error", err)
+	}
+	return res
+}
+
+// ParseDomain returns the Condition of the given serialized domain on this
+// model, or an error if the domain is malformed, if one of its operators is
+// not valid or if one of its field paths does not exist in this model.
+//
+// domain can be either a []interface{} as accepted by the ParseDomain function
+// or a string as accepted by ParseDomainString.
+//
+// It returns nil and no error if the domain is empty.
+func (m *Model) ParseDomain(domain interface{}) (*Condition, error) {
+	var dom []interface{}
+	switch d := domain.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		var err error
+		dom, err = ParseDomainString(d)
+		if err != nil {
+			return nil, err
+		}
+	case []interface{}:
+		dom = d
+	default:
+		return nil, fmt.Errorf("domain must be a list or a string, got %T", domain)
+	}
+	cond, err := parseDomain(dom)
+	if err != nil || cond == nil {
+		return nil, err
+	}
+	if err := m.checkConditionFields(cond); err != nil {
+		return nil, err
+	}
+	return cond, nil
+}
+
+// parseDomain returns the Condition of the given serialized domain as
+// described in ParseDomain or an error if it is malformed.
+func parseDomain(domain []interface{}) (*Condition, error) {
+	if len(domain) == 0 {
+		return nil, nil
+	}
+	res := newCondition()
+	pos := 0
+	for pos < len(domain) {
+		var (
+			term *Condition
+			err  error
+		)
+		term, pos, err = parseDomainTerm(domain, pos)
+		if err != nil {
+			return nil, err
+		}
+		res = appendDomainTerm(res, term, false)
+	}
+	return res, nil
+}
+
+// parseDomainTerm parses the domain term starting at position pos of the
+// given domain and returns its Condition and the position of the next term.
+func parseDomainTerm(domain []interface{}, pos int) (*Condition, int, error) {
+	if pos >= len(domain) {
+		return nil, pos, errors.New("unexpected end of domain")
+	}
+	switch token := domain[pos].(type) {
+	case string:
+		switch token {
+		case domainAnd, domainOr:
+			left, next, err := parseDomainTerm(domain, pos+1)
+			if err != nil {
+				return nil, next, err
+			}
+			right, next, err := parseDomainTerm(domain, next)
+			if err != nil {
+				return nil, next, err
+			}
+			if token == domainAnd {
+				res := appendDomainTerm(newCondition(), left, false)
+				return appendDomainTerm(res, right, false), next, nil
+			}
+			// We put the left term last so that Condition.Serialize
+			// gives back the same domain
+			res := appendDomainTerm(newCondition(), right, false)
+			return appendDomainTerm(res, left, true), next, nil
+		case domainNot:
+			sub, next, err := parseDomainTerm(domain, pos+1)
+			if err != nil {
+				return nil, next, err
+			}
+			return newCondition().AndNotCond(sub), next, nil
+		}
+	case operator.Operator:
+		// An operator at this position means a leaf that has been
+		// flattened in the domain, which is not valid
+	default:
+		val := reflect.ValueOf(token)
+		if val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
+			leaf, err := parseDomainLeaf(val)
+			return leaf, pos + 1, err
+		}
+	}
+	return nil, pos, fmt.Errorf("invalid token %v at position %d", domain[pos], pos)
+}
+
+// parseDomainLeaf returns the Condition of the given [field, operator, value] leaf.
+func parseDomainLeaf(leaf reflect.Value) (*Condition, error) {
+	if leaf.Len() != 3 {
+		return nil, fmt.Errorf("domain leaf %v must have 3 elements", leaf.Interface())
+	}
+	field, ok := leaf.Index(0).Interface().(string)
+	if !ok || field == "" {
+		return nil, fmt.Errorf("field of domain leaf %v must be a non empty string", leaf.Interface())
+	}
+	op := operator.Operator(fmt.Sprintf("%s", leaf.Index(1).Interface()))
+	if !op.IsValid() {
+		return nil, fmt.Errorf("invalid operator %s in domain leaf %v", op, leaf.Interface())
+	}
+	arg := leaf.Index(2).Interface()
+	if op.IsSubQuery() {
+		// The value is the domain of the related model
+		subDomain, ok := arg.([]interface{})
+		if !ok && arg != nil {
+			return nil, fmt.Errorf("value of domain leaf %v must be a domain", leaf.Interface())
+		}
+		subCond, err := parseDomain(subDomain)
+		if err != nil {
+			return nil, err
+		}
+		arg = subQueryCondition(subCond)
+	}
+	return newCondition().And().Field(field).AddOperator(op, arg), nil
+}
+
+// appendDomainTerm appends the given term to cond with AND or OR and returns the result.
+// Terms made of a single predicate are appended as simple predicates, others as
+// sub conditions.
+func appendDomainTerm(cond *Condition, term *Condition, isOr bool) *Condition {
+	if len(term.predicates) == 0 {
+		// e.g. 'in' operator with an empty list
+		return cond
+	}
+	if len(term.predicates) == 1 && !term.predicates[0].isCond {
+		p := term.predicates[0]
+		p.isOr = isOr
+		res := *cond
+		res.predicates = append(res.predicates, p)
+		return &res
+	}
+	if isOr {
+		return cond.OrCond(term)
+	}
+	return cond.AndCond(term)
+}
+
+// checkConditionFields returns an error if a field path of the
+// given condition does not exist in this model.
+//
+// Conditions of Any, None and All predicates are checked
+// against the related model of their 2many field.
+func (m *Model) checkConditionFields(cond *Condition) error {
+	for _, p := range cond.predicates {
+		if p.isCond {
+			if err := m.checkConditionFields(p.cond); err != nil {
+				return err
+			}
+			continue
+		}
+		model := m
+		for i, expr := range p.exprs {
+			fi, ok := model.fields.get(expr)
+			if !ok {
+				return fmt.Errorf("unknown field %s in model %s", expr, model.name)
+			}
+			if i < len(p.exprs)-1 && fi.relatedModel == nil {
+				return fmt.Errorf("field %s of model %s is not a relation", expr, model.name)
+			}
+			model = fi.relatedModel
+		}
+		if !p.operator.IsSubQuery() {
+			continue
+		}
+		fi := m.getRelatedFieldInfo(strings.Join(p.exprs, ExprSep))
+		if fi.fieldType != fieldtype.One2Many && fi.fieldType != fieldtype.Many2Many {
+			return fmt.Errorf("operator %s can only be used on One2Many and Many2Many fields, not on %s", p.operator, strings.Join(p.exprs, ExprSep))
+		}
+		if subCond, ok := p.arg.(*Condition); ok {
+			if err := fi.relatedModel.checkConditionFields(subCond); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParseDomainString parses the given serialized domain and returns it as a
+// list that can be given to ParseDomain.
+//
+// domain can be either a JSON array or a Python literal list as found in the
+// domains of actions and record rules in XML data files, e.g.
+//
+//	[('State', '=', 'done'), '|', ('Amount', '>', 100.0), ('Partner.Name', 'ilike', "O'Brien")]
+//
+// Tuples are returned as lists, integers as int64, other numbers as float64,
+// and True/true, False/false and None/null as true, false and nil.
+// Expressions that are not literals, such as uid or context values, are not
+// supported.
+func ParseDomainString(domain string) ([]interface{}, error) {
+	dp := domainParser{input: domain}
+	dp.skipSpaces()
+	if dp.pos == len(dp.input) {
+		return nil, nil
+	}
+	val, err := dp.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	dp.skipSpaces()
+	if dp.pos < len(dp.input) {
+		return nil, dp.errorf("unexpected trailing characters")
+	}
+	res, ok := val.([]interface{})
+	if !ok {
+		return nil, errors.New("domain must be a list")
+	}
+	return res, nil
+}
+
+// A domainParser parses literal values of serialized domains
+type domainParser struct {
+	input string
+	pos   int
+}
+
+// errorf returns an error with the given message and the current position
+func (dp *domainParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid domain at position %d: %s", dp.pos, fmt.Sprintf(format, args...))
+}
+
+// skipSpaces advances the parser to the next non space character
+func (dp *domainParser) skipSpaces() {
+	for dp.pos < len(dp.input) && strings.ContainsRune(" \t\r\n", rune(dp.input[dp.pos])) {
+		dp.pos++
+	}
+}
+
+// parseValue parses the literal value at the current position
+func (dp *domainParser) parseValue() (interface{}, error) {
+	dp.skipSpaces()
+	if dp.pos >= len(dp.input) {
+		return nil, dp.errorf("unexpected end of domain")
+	}
+	switch c := dp.input[dp.pos]; {
+	case c == '[':
+		return dp.parseList(']')
+	case c == '(':
+		return dp.parseList(')')
+	case c == '\'' || c == '"':
+		return dp.parseString()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return dp.parseNumber()
+	case unicode.IsLetter(rune(c)):
+		start := dp.pos
+		for dp.pos < len(dp.input) && (unicode.IsLetter(rune(dp.input[dp.pos])) || dp.input[dp.pos] == '_') {
+			dp.pos++
+		}
+		switch word := dp.input[start:dp.pos]; word {
+		case "True", "true":
+			return true, nil
+		case "False", "false":
+			return false, nil
+		case "None", "null":
+			return nil, nil
+		default:
+			dp.pos = start
+			return nil, dp.errorf("unsupported expression %s", word)
+		}
+	}
+	return nil, dp.errorf("unexpected character %q", dp.input[dp.pos])
+}
+
+// parseList parses a list or a tuple ending with the given closing character
+func (dp *domainParser) parseList(closing byte) ([]interface{}, error) {
+	dp.pos++
+	res := make([]interface{}, 0)
+	for {
+		dp.skipSpaces()
+		if dp.pos < len(dp.input) && dp.input[dp.pos] == closing {
+			dp.pos++
+			return res, nil
+		}
+		val, err := dp.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, val)
+		dp.skipSpaces()
+		if dp.pos >= len(dp.input) {
+			return nil, dp.errorf("unexpected end of domain")
+		}
+		switch dp.input[dp.pos] {
+		case ',':
+			dp.pos++
+		case closing:
+		default:
+			return nil, dp.errorf("expected ',' or '%c'", closing)
+		}
+	}
+}
+
+// parseString parses a quoted string with Python/JSON escape sequences
+func (dp *domainParser) parseString() (string, error) {
+	quote := dp.input[dp.pos]
+	dp.pos++
+	var res []byte
+	for dp.pos < len(dp.input) {
+		c := dp.input[dp.pos]
+		switch {
+		case c == quote:
+			dp.pos++
+			return string(res), nil
+		case c == '\\' && dp.pos+1 < len(dp.input):
+			dp.pos++
+			switch e := dp.input[dp.pos]; e {
+			case 'n':
+				res = append(res, '\n')
+			case 't':
+				res = append(res, '\t')
+			case 'r':
+				res = append(res, '\r')
+			case 'u':
+				if dp.pos+5 > len(dp.input) {
+					return "", dp.errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(dp.input[dp.pos+1:dp.pos+5], 16, 32)
+				if err != nil {
+					return "", dp.errorf("invalid unicode escape")
+				}
+				buf := make([]byte, utf8.UTFMax)
+				res = append(res, buf[:utf8.EncodeRune(buf, rune(code))]...)
+				dp.pos += 4
+			default:
+				res = append(res, e)
+			}
+			dp.pos++
+		default:
+			res = append(res, c)
+			dp.pos++
+		}
+	}
+	return "", dp.errorf("unterminated string")
+}
+
+// parseNumber parses an integer or a floating point number
+func (dp *domainParser) parseNumber() (interface{}, error) {
+	start := dp.pos
+	isFloat := false
+	for dp.pos < len(dp.input) {
+		c := dp.input[dp.pos]
+		if c == '.' || c == 'e' || c == 'E' {
+			isFloat = true
+		} else if !(c >= '0' && c <= '9' || c == '-' || c == '+') {
+			break
+		}
+		dp.pos++
+	}
+	literal := dp.input[start:dp.pos]
+	if !isFloat {
+		if val, err := strconv.ParseInt(literal, 10, 64); err == nil {
+			return val, nil
+		}
+	}
+	val, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		dp.pos = start
+		return nil, dp.errorf("invalid number %s", literal)
+	}
+	return val, nil
+}
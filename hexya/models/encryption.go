@@ -0,0 +1,301 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+	"github.com/spf13/viper"
+)
+
+// encryptedValueMask is written in logs instead of encrypted values
+const encryptedValueMask = "<encrypted>"
+
+// An encryptionKey is a key used to encrypt field values
+type encryptionKey struct {
+	id     string
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+var encryptionKeys struct {
+	sync.Once
+	keys []*encryptionKey
+	byID map[string]*encryptionKey
+}
+
+// loadEncryptionKeys reads the "EncryptionKeys" configuration parameter.
+//
+// It must be a list of base64 encoded 32 bytes keys. The first key
+// is used to encrypt values, the others are only used to decrypt values
+// that have been encrypted with older keys. This allows for key rotation.
+func loadEncryptionKeys() {
+	encryptionKeys.byID = make(map[string]*encryptionKey)
+	for _, encodedKey := range viper.GetStringSlice("EncryptionKeys") {
+		rawKey, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(rawKey) != 32 {
+			log.Panic("Encryption keys must be base64 encoded 32 bytes strings", "error", err)
+		}
+		key := newEncryptionKey(rawKey)
+		encryptionKeys.keys = append(encryptionKeys.keys, key)
+		encryptionKeys.byID[key.id] = key
+	}
+}
+
+// newEncryptionKey returns a new encryptionKey from the given raw key.
+// Encryption and MAC keys are derived from the raw key.
+func newEncryptionKey(rawKey []byte) *encryptionKey {
+	encKey := sha256.Sum256(append([]byte("hexya-encryption:"), rawKey...))
+	macKey := sha256.Sum256(append([]byte("hexya-mac:"), rawKey...))
+	block, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		log.Panic("Unable to create cipher", "error", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Panic("Unable to create cipher", "error", err)
+	}
+	keyID := sha256.Sum256(rawKey)
+	return &encryptionKey{
+		id:     hex.EncodeToString(keyID[:4]),
+		aead:   aead,
+		macKey: macKey[:],
+	}
+}
+
+// getEncryptionKeys returns the configured encryption keys, the current key first.
+// It panics if no key is configured.
+func getEncryptionKeys() []*encryptionKey {
+	encryptionKeys.Do(loadEncryptionKeys)
+	if len(encryptionKeys.keys) == 0 {
+		log.Panic("No encryption key configured. Set EncryptionKeys in configuration to use encrypted fields")
+	}
+	return encryptionKeys.keys
+}
+
+// encrypt returns the given value encrypted with this key.
+//
+// Encryption is deterministic: the nonce is derived from the value, so that
+// the same value always gives the same encrypted string with a given key.
+// This allows searching encrypted fields for equality.
+func (k *encryptionKey) encrypt(value string) string {
+	mac := hmac.New(sha256.New, k.macKey)
+	mac.Write([]byte(value))
+	nonce := mac.Sum(nil)[:k.aead.NonceSize()]
+	sealed := k.aead.Seal(nonce, nonce, []byte(value), nil)
+	return fmt.Sprintf("%s:%s", k.id, base64.StdEncoding.EncodeToString(sealed))
+}
+
+// decrypt returns the clear value of the given encrypted value.
+func (k *encryptionKey) decrypt(value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < k.aead.NonceSize() {
+		return "", fmt.Errorf("encrypted value is too short")
+	}
+	nonce := sealed[:k.aead.NonceSize()]
+	res, err := k.aead.Open(nil, nonce, sealed[k.aead.NonceSize():], nil)
+	return string(res), err
+}
+
+// An encryptedValue is an encrypted value that is sent to the database.
+// It is hidden when printed in logs.
+type encryptedValue string
+
+// Value returns the encrypted string to store in the database
+func (ev encryptedValue) Value() (driver.Value, error) {
+	return string(ev), nil
+}
+
+// String returns a mask so that encrypted values do not show in logs
+func (ev encryptedValue) String() string {
+	return encryptedValueMask
+}
+
+var _ driver.Valuer = encryptedValue("")
+
+// encryptString returns the given value encrypted with the current key
+func encryptString(value string) encryptedValue {
+	return encryptedValue(getEncryptionKeys()[0].encrypt(value))
+}
+
+// encryptStringWithAllKeys returns the given value encrypted
+// with each configured key.
+func encryptStringWithAllKeys(value string) []encryptedValue {
+	keys := getEncryptionKeys()
+	res := make([]encryptedValue, len(keys))
+	for i, key := range keys {
+		res[i] = encryptedValue(key.encrypt(value))
+	}
+	return res
+}
+
+// decryptString returns the clear value of the given value read from the database.
+// Values that have not been encrypted by Hexya (e.g. values stored before the field
+// was encrypted) are returned as is. It panics if the value cannot be decrypted.
+func decryptString(value string) string {
+	tokens := strings.SplitN(value, ":", 2)
+	if len(tokens) != 2 || len(tokens[0]) != 8 {
+		return value
+	}
+	getEncryptionKeys()
+	key, ok := encryptionKeys.byID[tokens[0]]
+	if !ok {
+		return value
+	}
+	res, err := key.decrypt(tokens[1])
+	if err != nil {
+		log.Panic("Unable to decrypt value", "keyID", tokens[0], "error", err)
+	}
+	return res
+}
+
+// checkEncryptedFieldType panics if the given field is encrypted
+// but its type cannot be encrypted.
+func checkEncryptedFieldType(fi *Field) {
+	if !fi.encrypted {
+		return
+	}
+	switch fi.fieldType {
+	case fieldtype.Char, fieldtype.Text, fieldtype.HTML, fieldtype.Binary:
+	default:
+		log.Panic("Only char, text, html and binary fields can be encrypted", "model", fi.model.name,
+			"field", fi.name, "type", fi.fieldType)
+	}
+}
+
+// encryptFieldValue returns the value to store in the database for the given
+// field and value. Values of non encrypted fields are returned as is.
+func encryptFieldValue(fi *Field, value interface{}) interface{} {
+	if !fi.encrypted || value == nil {
+		return value
+	}
+	return encryptString(fmt.Sprintf("%v", value))
+}
+
+// decryptFieldValue returns the clear value of the given value read from the database
+// for the given field. Values of non encrypted fields are returned as is.
+func decryptFieldValue(fi *Field, value interface{}) interface{} {
+	if !fi.encrypted {
+		return value
+	}
+	switch val := value.(type) {
+	case string:
+		return decryptString(val)
+	case []byte:
+		return decryptString(string(val))
+	}
+	return value
+}
+
+// encryptedPredicate returns the operator and argument to use in an SQL
+// query for the given predicate operator and argument on an encrypted field.
+//
+// Since encryption is deterministic, equality is checked against the value
+// encrypted with each configured key. It panics if the operator is not an
+// equality operator.
+func encryptedPredicate(fi *Field, op operator.Operator, arg interface{}) (operator.Operator, interface{}) {
+	var values []interface{}
+	switch op {
+	case operator.Equals, operator.NotEquals:
+		values = []interface{}{arg}
+	case operator.In, operator.NotIn:
+		argVal := reflect.ValueOf(arg)
+		if argVal.Kind() != reflect.Slice {
+			values = []interface{}{arg}
+			break
+		}
+		for i := 0; i < argVal.Len(); i++ {
+			values = append(values, argVal.Index(i).Interface())
+		}
+	default:
+		log.Panic("Only equality operators can be used on encrypted fields", "model", fi.model.name,
+			"field", fi.name, "operator", op)
+	}
+	var encValues []encryptedValue
+	for _, v := range values {
+		encValues = append(encValues, encryptStringWithAllKeys(fmt.Sprintf("%v", v))...)
+	}
+	if len(encValues) == 0 {
+		// Empty IN clauses are not valid SQL. No value can match an
+		// empty encrypted string since it has a key prefix.
+		encValues = []encryptedValue{""}
+	}
+	switch op {
+	case operator.NotEquals, operator.NotIn:
+		return operator.NotIn, encValues
+	default:
+		return operator.In, encValues
+	}
+}
+
+// hideEncryptedArgs returns a copy of the given query arguments where
+// encrypted values are replaced by a mask.
+func hideEncryptedArgs(args []interface{}) []interface{} {
+	res := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case encryptedValue:
+			res[i] = encryptedValueMask
+		case []encryptedValue:
+			res[i] = encryptedValueMask
+		case []interface{}:
+			res[i] = hideEncryptedArgs(a)
+		case SQLParams:
+			res[i] = hideEncryptedArgs(a)
+		default:
+			res[i] = arg
+		}
+	}
+	return res
+}
+
+// ReencryptFields rewrites all the values of the encrypted fields that have not
+// been encrypted with the current key. It is meant to be called after a new key has
+// been prepended to the EncryptionKeys configuration parameter.
+//
+// It returns the number of updated values.
+func ReencryptFields(env Environment) int {
+	var count int
+	adapter := adapters[db.DriverName()]
+	currentKeyID := getEncryptionKeys()[0].id
+	for _, model := range Registry.registryByName {
+		if model.isMixin() || model.isManual() {
+			continue
+		}
+		for _, fi := range model.fields.registryByName {
+			if !fi.encrypted || !fi.isStored() {
+				continue
+			}
+			table := adapter.quoteTableName(model.tableName)
+			var rows []struct {
+				ID    int64  `db:"id"`
+				Value string `db:"value"`
+			}
+			env.cr.Select(&rows, fmt.Sprintf(`SELECT id, %s AS value FROM %s WHERE %s IS NOT NULL AND %s NOT LIKE ?`,
+				fi.json, table, fi.json, fi.json), currentKeyID+":%")
+			for _, row := range rows {
+				env.cr.Execute(fmt.Sprintf(`UPDATE %s SET %s = ? WHERE id = ?`, table, fi.json),
+					encryptString(decryptString(row.Value)), row.ID)
+				env.cache.invalidateRecord(model, row.ID)
+				count++
+			}
+		}
+	}
+	return count
+}
@@ -14,10 +14,7 @@
 
 package models
 
-import (
-	"github.com/hexya-erp/hexya/hexya/models/types"
-	"github.com/hexya-erp/hexya/hexya/tools/logging"
-)
+import "github.com/hexya-erp/hexya/hexya/models/types"
 
 // DBSerializationMaxRetries defines the number of time a
 // transaction that failed due to serialization error should
@@ -36,7 +33,15 @@ type Environment struct {
 	cache     *cache
 	callStack []*methodLayer
 	super     *methodLayer
-	retries   uint8
+	hooks     *transactionHooks
+	// noNotify disables webhooks and bus notifications of record changes
+	noNotify bool
+}
+
+// transactionHooks holds the functions to execute at the end of a transaction
+type transactionHooks struct {
+	onCommit   []func()
+	onRollback []func()
 }
 
 // Cr returns a pointer to the Cursor of the Environment
@@ -54,22 +59,113 @@ func (env Environment) Context() *types.Context {
 	return env.context
 }
 
-// commit the transaction of this environment.
+// OnCommit registers the given fnct to be executed after the transaction
+// of this environment has been successfully committed.
+//
+// Use it for side effects that must not happen if the transaction is
+// rolled back, such as sending emails or notifying external systems.
+// A panic in fnct is logged and does not prevent other hooks from running.
+func (env Environment) OnCommit(fnct func()) {
+	env.hooks.onCommit = append(env.hooks.onCommit, fnct)
+}
+
+// OnRollback registers the given fnct to be executed after the transaction
+// of this environment has been rolled back.
+//
+// A panic in fnct is logged and does not prevent other hooks from running.
+func (env Environment) OnRollback(fnct func()) {
+	env.hooks.onRollback = append(env.hooks.onRollback, fnct)
+}
+
+// commit the transaction of this environment and execute its OnCommit hooks.
+// If the commit fails, the OnRollback hooks are executed instead and the error
+// is returned. If canRetry is true and the commit failed with a serialization
+// error, the hooks are discarded without being executed.
 //
 // WARNING: Do NOT call Commit on Environment instances that you
 // did not create yourself with NewEnvironment. The framework will
 // automatically commit the Environment.
-func (env Environment) commit() {
-	env.Cr().tx.Commit()
+func (env Environment) commit(canRetry bool) error {
+	hooks := *env.hooks
+	env.discardHooks()
+	if err := env.Cr().tx.Commit(); err != nil {
+		if !canRetry || !adapters[db.DriverName()].isSerializationError(err) {
+			runHooks(hooks.onRollback)
+		}
+		return err
+	}
+	runHooks(hooks.onCommit)
+	return nil
 }
 
-// rollback the transaction of this environment.
+// rollback the transaction of this environment and execute its OnRollback hooks.
 //
 // WARNING: Do NOT call Rollback on Environment instances that you
 // did not create yourself with NewEnvironment. Just panic instead
 // for the framework to roll back automatically for you.
 func (env Environment) rollback() {
+	hooks := *env.hooks
+	env.discardHooks()
 	env.Cr().tx.Rollback()
+	runHooks(hooks.onRollback)
+}
+
+// discardHooks removes all the OnCommit and OnRollback hooks
+// registered in this environment.
+func (env Environment) discardHooks() {
+	*env.hooks = transactionHooks{}
+}
+
+// Savepoint executes the given fnct inside a savepoint of the
+// transaction of this environment.
+//
+// If fnct panics, the changes it made in the database are rolled back,
+// the cache entries it touched are invalidated and the panic is returned
+// as an error, so that the rest of the transaction can go on. In this case,
+// OnCommit hooks registered by fnct are discarded and its OnRollback hooks
+// are executed.
+//
+// Serialization errors are not recovered so that the whole transaction
+// can be retried by ExecuteInNewEnvironment.
+func (env Environment) Savepoint(fnct func()) (rError error) {
+	name := env.cr.savepoint()
+	env.cache.startTracking()
+	hooks := *env.hooks
+	defer func() {
+		touched := env.cache.stopTracking()
+		r := recover()
+		if r == nil {
+			env.cr.releaseSavepoint(name)
+			return
+		}
+		env.cr.rollbackToSavepoint(name)
+		env.cache.invalidateRefs(touched)
+		onRollback := env.hooks.onRollback[len(hooks.onRollback):]
+		env.hooks.onCommit = env.hooks.onCommit[:len(hooks.onCommit)]
+		env.hooks.onRollback = env.hooks.onRollback[:len(hooks.onRollback)]
+		runHooks(onRollback)
+		if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+			panic(r)
+		}
+		rError = logPanicData(r)
+	}()
+	fnct()
+	return
+}
+
+// runHooks executes the given transaction hooks.
+// Panics in hooks are logged and recovered.
+func runHooks(hooks []func()) {
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logPanicData(r)
+				}
+			}()
+			hook()
+		}()
+	}
 }
 
 // newEnvironment returns a new Environment with the given parameters
@@ -88,6 +184,7 @@ func newEnvironment(uid int64, context ...types.Context) Environment {
 		uid:     uid,
 		context: &ctx,
 		cache:   newCache(),
+		hooks:   new(transactionHooks),
 	}
 	return env
 }
@@ -97,27 +194,58 @@ func newEnvironment(uid int64, context ...types.Context) Environment {
 //
 // This function commits the transaction if everything went right or
 // rolls it back otherwise, returning an arror. Database serialization
-// errors are automatically retried several times before returning an
-// error if they still occur.
-func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) (rError error) {
+// errors are automatically retried several times before returning a
+// ConcurrencyError if they still occur. If fnct panicked with an Error,
+// this Error is returned.
+//
+// OnCommit and OnRollback hooks registered by an attempt that is retried
+// are discarded without being executed, since fnct will register them
+// again when it is retried.
+func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) error {
+	for retries := uint8(0); ; retries++ {
+		retry, err := executeInNewEnvironment(uid, fnct, retries < DBSerializationMaxRetries)
+		if !retry {
+			return err
+		}
+	}
+}
+
+// executeInNewEnvironment executes the given fnct in a new Environment
+// within a new transaction as described in ExecuteInNewEnvironment.
+//
+// If canRetry is true and fnct or the commit failed with a serialization
+// error, the transaction is rolled back without executing its hooks and
+// retry is true.
+func executeInNewEnvironment(uid int64, fnct func(Environment), canRetry bool) (retry bool, rError error) {
 	env := newEnvironment(uid)
 	defer func() {
 		if r := recover(); r != nil {
-			env.rollback()
 			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
 				// Transaction error
-				env.retries++
-				if env.retries < DBSerializationMaxRetries {
-					if ExecuteInNewEnvironment(uid, fnct) == nil {
-						rError = nil
-						return
-					}
+				if canRetry {
+					env.discardHooks()
+					env.rollback()
+					retry = true
+					return
 				}
+				r = NewConcurrencyError("The operation could not be completed because of concurrent updates. Please try again.",
+					"error", err)
 			}
-			rError = logging.LogPanicData(r)
+			env.rollback()
+			rError = logPanicData(r)
 			return
 		}
-		env.commit()
+		if err := env.commit(canRetry); err != nil {
+			if adapters[db.DriverName()].isSerializationError(err) {
+				if canRetry {
+					retry = true
+					return
+				}
+				err = NewConcurrencyError("The operation could not be completed because of concurrent updates. Please try again.",
+					"error", err)
+			}
+			rError = logPanicData(err)
+		}
 	}()
 	fnct(env)
 	return
@@ -133,7 +261,7 @@ func SimulateInNewEnvironment(uid int64, fnct func(Environment)) (rError error)
 	defer func() {
 		env.rollback()
 		if r := recover(); r != nil {
-			rError = logging.LogPanicData(r)
+			rError = logPanicData(r)
 			return
 		}
 	}()
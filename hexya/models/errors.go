@@ -0,0 +1,163 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/tools/logging"
+)
+
+// An Error is an error raised by the models which carries a message
+// intended for the end user.
+//
+// Errors are raised by panicking with them. They are returned as is by
+// ExecuteInNewEnvironment and SimulateInNewEnvironment so that callers
+// can tell them apart.
+type Error interface {
+	error
+	// UserMessage returns the message to display to the end user.
+	// It should have been translated with RecordCollection.T.
+	UserMessage() string
+	// Debug returns debugging information about this error,
+	// including the stack trace if it has been recovered.
+	Debug() string
+	// withDebug returns a copy of this error with the given debug data
+	withDebug(debug string) Error
+}
+
+// errorData holds the data common to all models errors
+type errorData struct {
+	message string
+	ctx     []interface{}
+	debug   string
+}
+
+// Error returns the message of the error with its context
+// as it would be logged.
+func (e errorData) Error() string {
+	if len(e.ctx) == 0 {
+		return e.message
+	}
+	return fmt.Sprintf("%s, %v", e.message, e.ctx)
+}
+
+// UserMessage returns the message to display to the end user.
+func (e errorData) UserMessage() string {
+	return e.message
+}
+
+// Debug returns debugging information about this error
+func (e errorData) Debug() string {
+	if e.debug == "" {
+		return e.Error()
+	}
+	return e.debug
+}
+
+// An AccessError is raised when the user is not allowed to perform an operation.
+type AccessError struct {
+	errorData
+}
+
+// NewAccessError returns a new AccessError with the given message for the
+// end user. ctx are key/value pairs giving context to the logs, as for log.Panic.
+func NewAccessError(message string, ctx ...interface{}) AccessError {
+	return AccessError{errorData{message: message, ctx: ctx}}
+}
+
+func (e AccessError) withDebug(debug string) Error {
+	e.debug = debug
+	return e
+}
+
+// A ValidationError is raised when data does not satisfy a constraint,
+// including database constraints.
+type ValidationError struct {
+	errorData
+}
+
+// NewValidationError returns a new ValidationError with the given message for the
+// end user. ctx are key/value pairs giving context to the logs, as for log.Panic.
+func NewValidationError(message string, ctx ...interface{}) ValidationError {
+	return ValidationError{errorData{message: message, ctx: ctx}}
+}
+
+func (e ValidationError) withDebug(debug string) Error {
+	e.debug = debug
+	return e
+}
+
+// A MissingError is raised when trying to operate on records that do
+// not exist or that have been deleted.
+type MissingError struct {
+	errorData
+}
+
+// NewMissingError returns a new MissingError with the given message for the
+// end user. ctx are key/value pairs giving context to the logs, as for log.Panic.
+func NewMissingError(message string, ctx ...interface{}) MissingError {
+	return MissingError{errorData{message: message, ctx: ctx}}
+}
+
+func (e MissingError) withDebug(debug string) Error {
+	e.debug = debug
+	return e
+}
+
+// A ConcurrencyError is raised when a transaction cannot be committed
+// because of concurrent updates, even after being retried.
+type ConcurrencyError struct {
+	errorData
+}
+
+// NewConcurrencyError returns a new ConcurrencyError with the given message for the
+// end user. ctx are key/value pairs giving context to the logs, as for log.Panic.
+func NewConcurrencyError(message string, ctx ...interface{}) ConcurrencyError {
+	return ConcurrencyError{errorData{message: message, ctx: ctx}}
+}
+
+func (e ConcurrencyError) withDebug(debug string) Error {
+	e.debug = debug
+	return e
+}
+
+// A UserWarning is raised by business code to stop an operation
+// and warn the user, e.g.
+//
+//	panic(models.NewUserWarning(rs.T("Order %s is already confirmed", rs.Name())))
+type UserWarning struct {
+	errorData
+}
+
+// NewUserWarning returns a new UserWarning with the given message for the
+// end user. ctx are key/value pairs giving context to the logs, as for log.Panic.
+func NewUserWarning(message string, ctx ...interface{}) UserWarning {
+	return UserWarning{errorData{message: message, ctx: ctx}}
+}
+
+func (e UserWarning) withDebug(debug string) Error {
+	e.debug = debug
+	return e
+}
+
+// logPanicData logs the given recovered panic data with its stack trace
+// and returns it as an error.
+//
+// If panicData is an Error, it is returned with the stack trace as debug data.
+func logPanicData(panicData interface{}) error {
+	err := logging.LogPanicData(panicData)
+	if mErr, ok := panicData.(Error); ok {
+		return mErr.withDebug(err.Error())
+	}
+	return err
+}
+
+var (
+	_ Error = AccessError{}
+	_ Error = ValidationError{}
+	_ Error = MissingError{}
+	_ Error = ConcurrencyError{}
+	_ Error = UserWarning{}
+)
@@ -128,6 +128,24 @@ func (fc *FieldsCollection) getComputedFields(fields ...string) (fil []*Field) {
 	return
 }
 
+// storedComputedFields returns the slice of Field of the given stored computed
+// fields, or of all the stored computed fields of the model if none are given.
+// It panics if one of the given fields is not a stored computed field.
+func (fc *FieldsCollection) storedComputedFields(fields ...string) []*Field {
+	if len(fields) == 0 {
+		return fc.computedStoredFields
+	}
+	fil := make([]*Field, len(fields))
+	for i, f := range fields {
+		fInfo := fc.MustGet(f)
+		if !fInfo.isComputedField() || !fInfo.stored {
+			log.Panic("Field is not a stored computed field", "model", fc.model.name, "field", f)
+		}
+		fil[i] = fInfo
+	}
+	return fil
+}
+
 // newFieldsCollection returns a pointer to a new empty FieldsCollection with
 // all maps initialized.
 func newFieldsCollection() *FieldsCollection {
@@ -204,6 +222,9 @@ type Field struct {
 	inverse          string
 	filter           *Condition
 	translate        bool
+	encrypted        bool
+	fullTextSearch   bool
+	textSearchLang   string
 }
 
 // isComputedField returns true if this field is computed
@@ -32,6 +32,7 @@ type SimpleFieldParams struct {
 	NoCopy        bool
 	GoType        interface{}
 	Translate     bool
+	Encrypted     bool
 	OnChange      string
 	Constraint    string
 	Inverse       string
@@ -63,25 +64,28 @@ type FloatFieldParams struct {
 
 // A StringFieldParams holds all the possible options for a string field
 type StringFieldParams struct {
-	JSON          string
-	String        string
-	Help          string
-	Stored        bool
-	Required      bool
-	Unique        bool
-	Index         bool
-	Compute       string
-	Depends       []string
-	Related       string
-	GroupOperator string
-	NoCopy        bool
-	Size          int
-	GoType        interface{}
-	Translate     bool
-	OnChange      string
-	Constraint    string
-	Inverse       string
-	Default       func(Environment, FieldMap) interface{}
+	JSON               string
+	String             string
+	Help               string
+	Stored             bool
+	Required           bool
+	Unique             bool
+	Index              bool
+	Compute            string
+	Depends            []string
+	Related            string
+	GroupOperator      string
+	NoCopy             bool
+	Size               int
+	GoType             interface{}
+	Translate          bool
+	Encrypted          bool
+	FullTextSearch     bool
+	TextSearchLanguage string
+	OnChange           string
+	Constraint         string
+	Inverse            string
+	Default            func(Environment, FieldMap) interface{}
 }
 
 // A SelectionFieldParams holds all the possible options for a selection field
@@ -221,9 +225,11 @@ func (m *Model) addSimpleField(name string, params SimpleFieldParams, fieldType
 		fieldType:     fieldType,
 		defaultFunc:   params.Default,
 		translate:     params.Translate,
+		encrypted:     params.Encrypted,
 		onChange:      params.OnChange,
 		constraint:    params.Constraint,
 	}
+	checkEncryptedFieldType(fInfo)
 	m.fields.add(fInfo)
 	return fInfo
 }
@@ -242,30 +248,35 @@ func (m *Model) addStringField(name string, params StringFieldParams, fieldType
 		params.OnChange = params.Compute
 	}
 	fInfo := &Field{
-		model:         m,
-		acl:           security.NewAccessControlList(),
-		name:          name,
-		json:          json,
-		description:   str,
-		help:          params.Help,
-		stored:        params.Stored,
-		required:      params.Required,
-		unique:        params.Unique,
-		index:         params.Index,
-		compute:       params.Compute,
-		inverse:       params.Inverse,
-		depends:       params.Depends,
-		relatedPath:   params.Related,
-		groupOperator: strutils.GetDefaultString(params.GroupOperator, "sum"),
-		noCopy:        params.NoCopy,
-		structField:   structField,
-		size:          params.Size,
-		fieldType:     fieldType,
-		defaultFunc:   params.Default,
-		translate:     params.Translate,
-		onChange:      params.OnChange,
-		constraint:    params.Constraint,
-	}
+		model:          m,
+		acl:            security.NewAccessControlList(),
+		name:           name,
+		json:           json,
+		description:    str,
+		help:           params.Help,
+		stored:         params.Stored,
+		required:       params.Required,
+		unique:         params.Unique,
+		index:          params.Index,
+		compute:        params.Compute,
+		inverse:        params.Inverse,
+		depends:        params.Depends,
+		relatedPath:    params.Related,
+		groupOperator:  strutils.GetDefaultString(params.GroupOperator, "sum"),
+		noCopy:         params.NoCopy,
+		structField:    structField,
+		size:           params.Size,
+		fieldType:      fieldType,
+		defaultFunc:    params.Default,
+		translate:      params.Translate,
+		encrypted:      params.Encrypted,
+		fullTextSearch: params.FullTextSearch,
+		textSearchLang: params.TextSearchLanguage,
+		onChange:       params.OnChange,
+		constraint:     params.Constraint,
+	}
+	checkEncryptedFieldType(fInfo)
+	checkTextSearchField(fInfo)
 	m.fields.add(fInfo)
 	return fInfo
 }
@@ -659,6 +670,31 @@ func (f *Field) SetTranslate(value bool) *Field {
 	return f
 }
 
+// SetEncrypted overrides the value of the Encrypted parameter of this Field.
+// It panics if this Field's type cannot be encrypted.
+func (f *Field) SetEncrypted(value bool) *Field {
+	f.encrypted = value
+	checkEncryptedFieldType(f)
+	checkTextSearchField(f)
+	return f
+}
+
+// SetFullTextSearch overrides the value of the FullTextSearch parameter of this Field.
+// It panics if this Field cannot be searched with full text search.
+func (f *Field) SetFullTextSearch(value bool) *Field {
+	f.fullTextSearch = value
+	checkTextSearchField(f)
+	return f
+}
+
+// SetTextSearchLanguage overrides the value of the TextSearchLanguage parameter of this Field.
+// It is the language code (e.g. "fr_FR") of the text search configuration of the field and
+// defaults to the "TextSearchLanguage" configuration parameter.
+func (f *Field) SetTextSearchLanguage(value string) *Field {
+	f.textSearchLang = value
+	return f
+}
+
 // SetDefault overrides the value of the Default parameter of this Field
 func (f *Field) SetDefault(value func(Environment, FieldMap) interface{}) *Field {
 	f.defaultFunc = value
@@ -0,0 +1,149 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+)
+
+// ParentPathSep separates the ids of the ancestors
+// of a record in its ParentPath field.
+const ParentPathSep = "/"
+
+// AddParentPathField adds a "ParentPath" char field to this model, which must
+// have a "Parent" many2one field pointing to itself.
+//
+// The ParentPath of a record holds the ids of its ancestors and its own id,
+// each followed by ParentPathSep (e.g. "1/5/12/"). It cannot be set directly
+// and is maintained on create and on write of the Parent field, which panics
+// with a ValidationError if CheckRecursion detects a loop.
+//
+// ChildOf and ParentOf conditions on models with a ParentPath are computed
+// with indexed prefix matches instead of recursive queries.
+func (m *Model) AddParentPathField() *Field {
+	parent, ok := m.fields.get("Parent")
+	if !ok || parent.relatedModelName != m.name {
+		log.Panic("ParentPath field requires a Parent field pointing to the same model", "model", m.name)
+	}
+	return m.AddCharField("ParentPath", StringFieldParams{NoCopy: true})
+}
+
+// hasParentPathField returns true if this model has a
+// ParentPath field maintained from its Parent field.
+func (m *Model) hasParentPathField() bool {
+	_, ok := m.fields.get("ParentPath")
+	return ok && m.hasParentField()
+}
+
+// removeParentPath removes the ParentPath entry of the given FieldMap if
+// this model has a ParentPath field, since it is maintained by the ORM.
+func (m *Model) removeParentPath(fMap *FieldMap) {
+	if !m.hasParentPathField() {
+		return
+	}
+	fi := m.fields.MustGet("ParentPath")
+	delete(*fMap, fi.name)
+	delete(*fMap, fi.json)
+}
+
+// hierarchyIds returns the ids of the descendants of the record with
+// the given id if op is ChildOf, or of its ancestors if op is ParentOf.
+// The record itself is included in both cases.
+func (m *Model) hierarchyIds(env Environment, op operator.Operator, id interface{}) []int64 {
+	adapter := adapters[db.DriverName()]
+	var ids []int64
+	if !m.hasParentPathField() {
+		query := adapter.childrenIdsQuery(m.tableName)
+		if op == operator.ParentOf {
+			query = adapter.parentIdsQuery(m.tableName)
+		}
+		env.Cr().Select(&ids, query, id)
+		return ids
+	}
+	var paths []string
+	env.Cr().Select(&paths, fmt.Sprintf(`SELECT COALESCE(parent_path, '') FROM %s WHERE id = ?`, adapter.quoteTableName(m.tableName)), id)
+	if len(paths) == 0 || paths[0] == "" {
+		// Unknown record or path not computed yet
+		return ids
+	}
+	if op == operator.ChildOf {
+		env.Cr().Select(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE parent_path LIKE ?`, adapter.quoteTableName(m.tableName)), paths[0]+"%")
+		return ids
+	}
+	for _, idStr := range strings.Split(strings.TrimSuffix(paths[0], ParentPathSep), ParentPathSep) {
+		parentID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			log.Panic("Invalid parent path", "model", m.name, "id", id, "path", paths[0])
+		}
+		ids = append(ids, parentID)
+	}
+	return ids
+}
+
+// parentPathChildren returns the direct children of the records of this
+// RecordCollection if its model has a ParentPath field. It is meant to be
+// called before deleting these records, so that the ParentPath of their
+// children can be updated with updateParentPaths once they are orphaned.
+func (rc RecordCollection) parentPathChildren() RecordCollection {
+	res := rc.env.Pool(rc.ModelName())
+	if !rc.model.hasParentPathField() {
+		return res
+	}
+	ids := rc.Ids()
+	if len(ids) == 0 {
+		return res
+	}
+	var childIds []int64
+	rc.env.cr.Select(&childIds, fmt.Sprintf(`SELECT id FROM %s WHERE parent_id IN (?)`,
+		adapters[db.DriverName()].quoteTableName(rc.model.tableName)), ids)
+	return res.withIds(childIds)
+}
+
+// updateParentPaths computes the ParentPath of the records of this
+// RecordCollection from their parent's and updates the ParentPath of
+// their descendants accordingly.
+//
+// It panics with a ValidationError if the hierarchy has a loop.
+func (rc RecordCollection) updateParentPaths() {
+	if !rc.model.hasParentPathField() {
+		return
+	}
+	if !rc.Call("CheckRecursion").(bool) {
+		panic(NewValidationError(rc.T("Recursion detected in the hierarchy of %s", rc.ModelName()),
+			"model", rc.ModelName(), "ids", rc.Ids()))
+	}
+	adapter := adapters[db.DriverName()]
+	tableName := adapter.quoteTableName(rc.model.tableName)
+	for _, id := range rc.Ids() {
+		// Paths are read for each record since updating a record
+		// may have updated the next ones if they are its descendants.
+		var oldPaths, parentPaths []string
+		rc.env.cr.Select(&oldPaths, fmt.Sprintf(`SELECT COALESCE(parent_path, '') FROM %s WHERE id = ?`, tableName), id)
+		rc.env.cr.Select(&parentPaths, fmt.Sprintf(`
+			SELECT COALESCE(p.parent_path, '')
+			FROM %s t LEFT JOIN %s p ON t.parent_id = p.id
+			WHERE t.id = ?`, tableName, tableName), id)
+		if len(oldPaths) == 0 {
+			continue
+		}
+		newPath := fmt.Sprintf("%s%d%s", parentPaths[0], id, ParentPathSep)
+		if newPath == oldPaths[0] {
+			continue
+		}
+		var ids []int64
+		if oldPaths[0] == "" {
+			// New record, so it has no descendants yet
+			rc.env.cr.Select(&ids, fmt.Sprintf(`UPDATE %s SET parent_path = ? WHERE id = ? RETURNING id`, tableName), newPath, id)
+		} else {
+			rc.env.cr.Select(&ids, adapter.moveParentPathQuery(rc.model.tableName), newPath, len(oldPaths[0])+1, oldPaths[0]+"%")
+		}
+		for _, updatedID := range ids {
+			rc.env.cache.invalidateRecord(rc.model, updatedID)
+		}
+	}
+}
@@ -30,8 +30,17 @@ func init() {
 	registerDBAdapter("postgres", new(postgresAdapter))
 	// model registry
 	Registry = newModelCollection()
+	// webhooks registry
+	Webhooks = NewWebhookRegistry()
+	// job queue
+	Jobs = NewJobQueue()
+	// notification bus
+	Bus = NewNotificationBus()
 	// declare base and common mixins
 	declareCommonMixin()
 	declareBaseMixin()
 	declareModelMixin()
+	// declare system models
+	declareJobModel()
+	declareWebhookModels()
 }
@@ -0,0 +1,485 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+const (
+	// jobModelName is the name of the system model storing the jobs of the queue
+	jobModelName = "QueueJob"
+	// DefaultJobChannel is the channel of the jobs for which no channel is given
+	DefaultJobChannel = "root"
+)
+
+// A JobState is the state of a job in the queue
+type JobState string
+
+// Available job states
+const (
+	JobPending JobState = "pending"
+	JobDone    JobState = "done"
+	JobFailed  JobState = "failed"
+)
+
+// Jobs is the queue of the background jobs enqueued with RecordCollection.Delay
+var Jobs *JobQueue
+
+// A Job is a method call on a RecordCollection that is executed
+// in the background by the workers of the JobQueue.
+type Job struct {
+	ID          int64
+	Model       string
+	Method      string
+	IDs         []int64
+	UID         int64
+	Context     *types.Context
+	Args        json.RawMessage
+	Channel     string
+	Priority    int64
+	State       JobState
+	Attempts    int64
+	MaxAttempts int64
+	ETA         dates.DateTime
+	// Result is the JSON encoded result of the method call. RecordSets are
+	// encoded as their ids.
+	Result json.RawMessage
+	// Error is the error of the last failed attempt
+	Error string
+}
+
+// jobRecord holds the values of a QueueJob record as stored in the database
+type jobRecord struct {
+	ID          int64
+	RecordModel string
+	Method      string
+	RecordIDs   string
+	UserID      int64
+	Context     string
+	Args        string
+	Channel     string
+	Priority    int64
+	State       string
+	Attempts    int64
+	MaxAttempts int64
+	ETA         dates.DateTime
+	Result      string
+	LastError   string
+}
+
+// job returns the Job defined by this jobRecord
+func (jr jobRecord) job() Job {
+	res := Job{
+		ID:          jr.ID,
+		Model:       jr.RecordModel,
+		Method:      jr.Method,
+		UID:         jr.UserID,
+		Context:     types.NewContext(),
+		Args:        json.RawMessage(jr.Args),
+		Channel:     jr.Channel,
+		Priority:    jr.Priority,
+		State:       JobState(jr.State),
+		Attempts:    jr.Attempts,
+		MaxAttempts: jr.MaxAttempts,
+		ETA:         jr.ETA,
+		Error:       jr.LastError,
+	}
+	json.Unmarshal([]byte(jr.RecordIDs), &res.IDs)
+	json.Unmarshal([]byte(jr.Context), res.Context)
+	if jr.Result != "" {
+		res.Result = json.RawMessage(jr.Result)
+	}
+	return res
+}
+
+// A JobQueue runs the jobs stored in the database with worker goroutines.
+//
+// Jobs are dispatched in channels, each channel having its own number of workers,
+// and are locked in the database while they run, so that several servers can
+// process the same queue. A failed job is retried after an exponential delay
+// until it reaches its maximum number of attempts.
+type JobQueue struct {
+	sync.RWMutex
+	channels     map[string]int
+	maxAttempts  int64
+	retryDelay   time.Duration
+	pollInterval time.Duration
+	wakeUp       chan struct{}
+	stop         chan struct{}
+	workers      sync.WaitGroup
+}
+
+// SetChannel sets the number of workers processing concurrently the jobs of the
+// given channel. Jobs of channels that are not set are not processed.
+//
+// Channels must be set before the queue is started.
+func (jq *JobQueue) SetChannel(channel string, concurrency int) {
+	jq.Lock()
+	defer jq.Unlock()
+	if concurrency <= 0 {
+		delete(jq.channels, channel)
+		return
+	}
+	jq.channels[channel] = concurrency
+}
+
+// SetRetryPolicy sets the default maximum number of attempts of jobs and the
+// delay before the first retry. This delay doubles after each failed attempt.
+func (jq *JobQueue) SetRetryPolicy(maxAttempts int, retryDelay time.Duration) {
+	jq.Lock()
+	defer jq.Unlock()
+	jq.maxAttempts = int64(maxAttempts)
+	jq.retryDelay = retryDelay
+}
+
+// SetPollInterval sets the interval at which idle workers check the database
+// for jobs enqueued by other servers or whose ETA has been reached.
+func (jq *JobQueue) SetPollInterval(interval time.Duration) {
+	jq.Lock()
+	defer jq.Unlock()
+	jq.pollInterval = interval
+}
+
+// Start starts the workers of all the channels of the queue.
+// It is a no-op if the queue is already started.
+func (jq *JobQueue) Start() {
+	jq.Lock()
+	defer jq.Unlock()
+	if jq.stop != nil {
+		return
+	}
+	jq.stop = make(chan struct{})
+	for channel, concurrency := range jq.channels {
+		for i := 0; i < concurrency; i++ {
+			jq.workers.Add(1)
+			go jq.work(channel, jq.stop)
+		}
+	}
+	log.Info("Job queue started", "channels", jq.channels)
+}
+
+// Stop stops the workers of the queue, waiting for running jobs to finish.
+func (jq *JobQueue) Stop() {
+	jq.Lock()
+	if jq.stop == nil {
+		jq.Unlock()
+		return
+	}
+	close(jq.stop)
+	jq.stop = nil
+	jq.Unlock()
+	jq.workers.Wait()
+	log.Info("Job queue stopped")
+}
+
+// RunPending synchronously runs the jobs of the given channel that are
+// ready to run until there is none left, and returns the number of
+// executed jobs, including failed ones.
+func (jq *JobQueue) RunPending(channel string) int {
+	var res int
+	for jq.runNext(channel) {
+		res++
+	}
+	return res
+}
+
+// Job returns the job with the given id and true, or false
+// if there is no such job.
+func (jq *JobQueue) Job(id int64) (Job, bool) {
+	var (
+		res   Job
+		found bool
+	)
+	SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rc := env.Pool(jobModelName).Search(env.Pool(jobModelName).Model().Field("ID").Equals(id))
+		if rc.IsEmpty() {
+			return
+		}
+		var jr jobRecord
+		rc.First(&jr)
+		res, found = jr.job(), true
+	})
+	return res, found
+}
+
+// work runs the jobs of the given channel until stop is closed
+func (jq *JobQueue) work(channel string, stop chan struct{}) {
+	defer jq.workers.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		// We get wakeUp before looking for jobs so as not to miss a wake up
+		jq.RLock()
+		wakeUp, pollInterval := jq.wakeUp, jq.pollInterval
+		jq.RUnlock()
+		if jq.runNext(channel) {
+			continue
+		}
+		select {
+		case <-stop:
+			return
+		case <-wakeUp:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// wake signals the idle workers that jobs have been enqueued
+func (jq *JobQueue) wake() {
+	jq.Lock()
+	defer jq.Unlock()
+	close(jq.wakeUp)
+	jq.wakeUp = make(chan struct{})
+}
+
+// hasPending returns true if the given channel has pending jobs,
+// whether they are ready to run or not.
+func (jq *JobQueue) hasPending(channel string) bool {
+	var count int
+	SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		count = env.Pool(jobModelName).Search(env.Pool(jobModelName).Model().Field("State").Equals(string(JobPending)).
+			And().Field("Channel").Equals(channel)).SearchCount()
+	})
+	return count > 0
+}
+
+// runNext runs the next job of the given channel that is ready to run
+// and returns true, or returns false if there is no such job or if the
+// job could not be processed.
+//
+// The job is locked in the database for the duration of its execution
+// and its result or error is stored in the same transaction.
+func (jq *JobQueue) runNext(channel string) bool {
+	var found bool
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		jobModel := Registry.MustGet(jobModelName)
+		query := fmt.Sprintf(`SELECT id FROM %s WHERE state = ? AND channel = ? AND eta <= ? ORDER BY priority, eta, id LIMIT 1 %s`,
+			adapters[db.DriverName()].quoteTableName(jobModel.tableName), adapters[db.DriverName()].skipLockedSQL())
+		var ids []int64
+		env.cr.Select(&ids, query, string(JobPending), channel, dates.Now())
+		found = len(ids) > 0
+		if !found {
+			return
+		}
+		job := jobModel.Browse(env, ids)
+		var jr jobRecord
+		job.First(&jr)
+		var result string
+		err := env.Savepoint(func() {
+			result = jr.execute(env)
+		})
+		values := FieldMap{"Attempts": jr.Attempts + 1}
+		switch {
+		case err == nil:
+			values["State"] = string(JobDone)
+			values["Result"] = result
+			values["LastError"] = ""
+		case jr.Attempts+1 >= jr.MaxAttempts:
+			values["State"] = string(JobFailed)
+			values["LastError"] = err.Error()
+		default:
+			jq.RLock()
+			delay := jq.retryDelay << uint(jr.Attempts)
+			jq.RUnlock()
+			values["ETA"] = dates.DateTime{Time: time.Now().Add(delay)}
+			values["LastError"] = err.Error()
+		}
+		job.Call("Write", values)
+	})
+	if err != nil {
+		log.Warn("Error while running job", "channel", channel, "error", err)
+		return false
+	}
+	return found
+}
+
+// execute calls the method of the job described by this jobRecord in the given
+// Environment with the user and context of the job and returns its JSON result.
+func (jr jobRecord) execute(env Environment) string {
+	job := jr.job()
+	model, ok := Registry.Get(job.Model)
+	if !ok {
+		log.Panic("Unknown model of job", "job", job.ID, "model", job.Model)
+	}
+	rc := model.Browse(env, job.IDs).Sudo(job.UID).WithNewContext(job.Context)
+	var rawArgs []json.RawMessage
+	if err := json.Unmarshal(job.Args, &rawArgs); err != nil {
+		log.Panic("Unable to unmarshal job arguments", "job", job.ID, "error", err)
+	}
+	args, err := ConvertJSONArgs(rc.Env(), model, rc.MethodType(job.Method), rawArgs)
+	if err != nil {
+		log.Panic("Invalid job arguments", "job", job.ID, "method", job.Method, "error", err)
+	}
+	res := rc.Call(job.Method, args...)
+	if rs, ok := res.(RecordSet); ok {
+		res = rs.Ids()
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		log.Panic("Unable to marshal job result", "job", job.ID, "method", job.Method, "error", err)
+	}
+	return string(data)
+}
+
+// NewJobQueue returns a new JobQueue with the default channel processed
+// by a single worker and the WebhookJobChannel processed by four workers.
+func NewJobQueue() *JobQueue {
+	return &JobQueue{
+		channels:     map[string]int{DefaultJobChannel: 1, WebhookJobChannel: 4},
+		maxAttempts:  5,
+		retryDelay:   time.Minute,
+		pollInterval: 10 * time.Second,
+		wakeUp:       make(chan struct{}),
+	}
+}
+
+// A JobDelayer enqueues method calls on a RecordCollection as background jobs.
+// It is returned by RecordCollection.Delay.
+type JobDelayer struct {
+	rc          RecordCollection
+	channel     string
+	priority    int64
+	delay       time.Duration
+	maxAttempts int64
+}
+
+// Delay returns a JobDelayer to call methods on this RecordCollection in the
+// background, with the user and context of its Environment, e.g.
+//
+//	jobID := rs.Delay().Channel("invoicing").Call("CreateInvoices", partners)
+//
+// Arguments of the method must be JSON serializable or RecordSets.
+func (rc RecordCollection) Delay() JobDelayer {
+	return JobDelayer{
+		rc:      rc,
+		channel: DefaultJobChannel,
+	}
+}
+
+// Channel returns a copy of this JobDelayer that enqueues jobs in the given channel.
+func (jd JobDelayer) Channel(channel string) JobDelayer {
+	jd.channel = channel
+	return jd
+}
+
+// Priority returns a copy of this JobDelayer that enqueues jobs with the given
+// priority. Jobs with the lowest priority are executed first. Default is 0.
+func (jd JobDelayer) Priority(priority int) JobDelayer {
+	jd.priority = int64(priority)
+	return jd
+}
+
+// After returns a copy of this JobDelayer that enqueues jobs which
+// will not be executed before the given delay.
+func (jd JobDelayer) After(delay time.Duration) JobDelayer {
+	jd.delay = delay
+	return jd
+}
+
+// MaxAttempts returns a copy of this JobDelayer that enqueues jobs which are
+// attempted at most the given number of times. Default is the value set
+// by JobQueue.SetRetryPolicy.
+func (jd JobDelayer) MaxAttempts(attempts int) JobDelayer {
+	jd.maxAttempts = int64(attempts)
+	return jd
+}
+
+// Call enqueues a job that will call the given method with the given
+// arguments and returns the id of the job.
+//
+// The job is stored in the current transaction, so that it is only
+// executed if this transaction is committed.
+func (jd JobDelayer) Call(methName string, args ...interface{}) int64 {
+	rc := jd.rc
+	methType := rc.MethodType(methName)
+	numParams := methType.NumIn() - 1
+	if len(args) < numParams && !(methType.IsVariadic() && len(args) == numParams-1) ||
+		len(args) > numParams && !methType.IsVariadic() {
+		log.Panic("Wrong number of arguments for job", "model", rc.ModelName(), "method", methName,
+			"expected", numParams, "received", len(args))
+	}
+	jArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case RecordSet:
+			jArgs[i] = JSONRecordSet{Model: a.ModelName(), IDs: a.Ids()}
+		case FieldMapper:
+			jArgs[i] = a.FieldMap()
+		default:
+			jArgs[i] = arg
+		}
+	}
+	argsData, err := json.Marshal(jArgs)
+	if err != nil {
+		log.Panic("Unable to marshal job arguments", "model", rc.ModelName(), "method", methName, "error", err)
+	}
+	idsData, _ := json.Marshal(rc.Ids())
+	ctxData, err := json.Marshal(rc.env.context)
+	if err != nil {
+		log.Panic("Unable to marshal job context", "model", rc.ModelName(), "method", methName, "error", err)
+	}
+	maxAttempts := jd.maxAttempts
+	if maxAttempts <= 0 {
+		Jobs.RLock()
+		maxAttempts = Jobs.maxAttempts
+		Jobs.RUnlock()
+	}
+	job := rc.env.Pool(jobModelName).Sudo().Call("Create", FieldMap{
+		"RecordModel": rc.ModelName(),
+		"Method":      methName,
+		"RecordIDs":   string(idsData),
+		"UserID":      rc.env.uid,
+		"Context":     string(ctxData),
+		"Args":        string(argsData),
+		"Channel":     jd.channel,
+		"Priority":    jd.priority,
+		"State":       string(JobPending),
+		"MaxAttempts": maxAttempts,
+		"ETA":         dates.DateTime{Time: time.Now().Add(jd.delay)},
+	}).(RecordCollection)
+	rc.env.OnCommit(Jobs.wake)
+	return job.ids[0]
+}
+
+// declareJobModel declares the system model storing the jobs of the queue
+func declareJobModel() {
+	jobModel := createModel(jobModelName, SystemModel)
+	jobModel.InheritModel(Registry.MustGet("CommonMixin"))
+	jobModel.AddCharField("RecordModel", StringFieldParams{Required: true})
+	jobModel.AddTextField("RecordIDs", StringFieldParams{JSON: "record_ids"})
+	jobModel.AddCharField("Method", StringFieldParams{Required: true})
+	jobModel.AddIntegerField("UserID", SimpleFieldParams{JSON: "user_id"})
+	jobModel.AddTextField("Context", StringFieldParams{})
+	jobModel.AddTextField("Args", StringFieldParams{})
+	jobModel.AddCharField("Channel", StringFieldParams{Index: true})
+	jobModel.AddIntegerField("Priority", SimpleFieldParams{})
+	jobModel.AddSelectionField("State", SelectionFieldParams{Index: true, Selection: types.Selection{
+		string(JobPending): "Pending",
+		string(JobDone):    "Done",
+		string(JobFailed):  "Failed",
+	}})
+	jobModel.AddIntegerField("Attempts", SimpleFieldParams{})
+	jobModel.AddIntegerField("MaxAttempts", SimpleFieldParams{})
+	jobModel.AddDateTimeField("ETA", SimpleFieldParams{JSON: "eta", Index: true})
+	jobModel.AddTextField("Result", StringFieldParams{})
+	jobModel.AddTextField("LastError", StringFieldParams{})
+
+	jobModel.AddMethod(webhookJobMethod,
+		`deliverWebhook makes a delivery attempt of the given payload to the webhook with
+		the given ID, or retries the delivery with the given deliveryID if it is not 0.`,
+		func(rc RecordCollection, hookID, deliveryID int64, payload string) {
+			Webhooks.attempt(rc.Env(), hookID, deliveryID, payload)
+		})
+}
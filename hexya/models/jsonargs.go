@@ -0,0 +1,140 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A JSONRecordSet is the JSON representation of a RecordSet argument
+// of a method call, as {"model": ..., "ids": [...]}.
+type JSONRecordSet struct {
+	Model string  `json:"model"`
+	IDs   []int64 `json:"ids"`
+}
+
+var (
+	recordCollectionType = reflect.TypeOf(RecordCollection{})
+	recordSetType        = reflect.TypeOf((*RecordSet)(nil)).Elem()
+	fieldMapperType      = reflect.TypeOf((*FieldMapper)(nil)).Elem()
+	fieldNamerType       = reflect.TypeOf((*FieldNamer)(nil)).Elem()
+	conditionType        = reflect.TypeOf(&Condition{})
+)
+
+// ConvertJSONArgs converts the given JSON arguments to the parameter types of
+// the given method type, so that they can be passed to RecordCollection.Call.
+// The first parameter of the method type is the RecordSet on which the method
+// is called and is skipped.
+//
+// RecordSet parameters are given as JSONRecordSet objects. Typed RecordSets
+// can also be given as an id or a list of ids. Conditions are given as
+// serialized domains, either as lists or as strings, and are checked against
+// model, which is the model of the method (see Model.ParseDomain).
+//
+// Variadic arguments are returned packed in a slice as last argument.
+func ConvertJSONArgs(env Environment, model *Model, methType reflect.Type, args []json.RawMessage) ([]interface{}, error) {
+	numParams := methType.NumIn() - 1
+	if len(args) < numParams && !(methType.IsVariadic() && len(args) == numParams-1) ||
+		len(args) > numParams && !methType.IsVariadic() {
+		return nil, fmt.Errorf("wrong number of arguments: expected %d, got %d", numParams, len(args))
+	}
+	var res []interface{}
+	for i := 0; i < numParams; i++ {
+		if i == numParams-1 && methType.IsVariadic() {
+			sliceType := methType.In(i + 1)
+			slice := reflect.MakeSlice(sliceType, 0, len(args)-i)
+			for j := i; j < len(args); j++ {
+				val, err := convertJSONArg(env, model, sliceType.Elem(), args[j])
+				if err != nil {
+					return nil, fmt.Errorf("argument %d: %s", j, err)
+				}
+				slice = reflect.Append(slice, val)
+			}
+			res = append(res, slice.Interface())
+			break
+		}
+		val, err := convertJSONArg(env, model, methType.In(i+1), args[i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i, err)
+		}
+		res = append(res, val.Interface())
+	}
+	return res, nil
+}
+
+// convertJSONArg converts the given JSON argument to a value of type typ.
+func convertJSONArg(env Environment, model *Model, typ reflect.Type, arg json.RawMessage) (reflect.Value, error) {
+	switch {
+	case typ == recordCollectionType || typ == recordSetType:
+		var rsArg JSONRecordSet
+		if err := json.Unmarshal(arg, &rsArg); err != nil {
+			return reflect.Value{}, err
+		}
+		rsModel, ok := Registry.Get(rsArg.Model)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("unknown model %s", rsArg.Model)
+		}
+		return reflect.ValueOf(rsModel.Browse(env, rsArg.IDs)).Convert(typ), nil
+	case typ.Kind() == reflect.Struct && typ.Implements(recordSetType) &&
+		typ.NumField() > 0 && typ.Field(0).Type == recordCollectionType:
+		// Typed RecordSet such as pool.UserSet
+		rsArg := JSONRecordSet{Model: strings.TrimSuffix(typ.Name(), "Set")}
+		ids, err := unmarshalIDs(arg)
+		if err == nil {
+			rsArg.IDs = ids
+		} else if err := json.Unmarshal(arg, &rsArg); err != nil {
+			return reflect.Value{}, err
+		}
+		rsModel, ok := Registry.Get(rsArg.Model)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("unable to find model of type %s", typ)
+		}
+		res := reflect.New(typ).Elem()
+		res.Field(0).Set(reflect.ValueOf(rsModel.Browse(env, rsArg.IDs)))
+		return res, nil
+	case typ == fieldMapperType:
+		var fMap FieldMap
+		if err := json.Unmarshal(arg, &fMap); err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(fMap), nil
+	case typ == conditionType:
+		var domain interface{}
+		if err := json.Unmarshal(arg, &domain); err != nil {
+			return reflect.Value{}, err
+		}
+		cond, err := model.ParseDomain(domain)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(cond), nil
+	case typ == fieldNamerType:
+		var fName FieldName
+		if err := json.Unmarshal(arg, &fName); err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(fName), nil
+	}
+	val := reflect.New(typ)
+	if err := json.Unmarshal(arg, val.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return val.Elem(), nil
+}
+
+// unmarshalIDs unmarshals the given JSON id or list of ids.
+func unmarshalIDs(arg json.RawMessage) ([]int64, error) {
+	var ids []int64
+	if err := json.Unmarshal(arg, &ids); err == nil {
+		return ids, nil
+	}
+	var id int64
+	if err := json.Unmarshal(arg, &id); err != nil {
+		return nil, err
+	}
+	return []int64{id}, nil
+}
@@ -16,6 +16,7 @@ package models
 
 import (
 	"reflect"
+	"sort"
 	"sync"
 
 	"github.com/hexya-erp/hexya/hexya/models/security"
@@ -35,6 +36,22 @@ func (mc *MethodsCollection) get(methodName string) (mi *Method, ok bool) {
 	return
 }
 
+// Has returns true if a method with the given name exists in this collection.
+func (mc *MethodsCollection) Has(methodName string) bool {
+	_, ok := mc.get(methodName)
+	return ok
+}
+
+// AllNames returns the names of all the methods of this collection, sorted alphabetically.
+func (mc *MethodsCollection) AllNames() []string {
+	res := make([]string, 0, len(mc.registry))
+	for methName := range mc.registry {
+		res = append(res, methName)
+	}
+	sort.Strings(res)
+	return res
+}
+
 // MustGet returns the Method of the given method. It panics if the
 // method is not found.
 func (mc *MethodsCollection) MustGet(methodName string) *Method {
@@ -120,6 +137,12 @@ func (m *Method) getNextLayer(methodLayer *methodLayer) *methodLayer {
 	return m.nextLayer[methodLayer]
 }
 
+// isExtended returns true if this method has
+// other layers than the one it was declared with.
+func (m *Method) isExtended() bool {
+	return m.topLayer != nil && m.getNextLayer(m.topLayer) != nil
+}
+
 // invertedLayers returns the list of method layers starting
 // from the base methods and going up all inherited layers
 func (m *Method) invertedLayers() []*methodLayer {
@@ -146,6 +169,26 @@ func (m *Method) AllowGroup(group *security.Group, callers ...Methoder) *Method
 	return m
 }
 
+// Name returns the name of this method
+func (m *Method) Name() string {
+	return m.name
+}
+
+// Doc returns the documentation string of the first layer of this method
+func (m *Method) Doc() string {
+	layers := m.invertedLayers()
+	if len(layers) == 0 {
+		return m.doc
+	}
+	return layers[0].doc
+}
+
+// MethodType returns the type of the function of this method.
+// Its first argument is the RecordSet on which the method is called.
+func (m *Method) MethodType() reflect.Type {
+	return m.methodType
+}
+
 // Underlying returns the underlysing method data object
 func (m *Method) Underlying() *Method {
 	return m
@@ -166,6 +209,19 @@ func (m *Method) RevokeGroup(group *security.Group) *Method {
 	return m
 }
 
+// isAllowed returns true if the user with the given uid belongs to a
+// group that is allowed to execute this method from any caller.
+func (m *Method) isAllowed(uid int64) bool {
+	m.RLock()
+	defer m.RUnlock()
+	for group := range security.Registry.UserGroups(uid) {
+		if m.groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
 var _ Methoder = new(Method)
 
 // methodLayer is one layer of a method, that is one function defined in a module
@@ -0,0 +1,165 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+const (
+	// companyMixinName is the name of the mixin that makes a model company dependent
+	companyMixinName = "CompanyMixin"
+	// companyIDContextKey is the context key of the current company id
+	companyIDContextKey = "company_id"
+	// companyIDsContextKey is the context key of the ids of the companies
+	// the current user is allowed to access.
+	companyIDsContextKey = "company_ids"
+)
+
+// companyModelName is the name of the model that holds companies.
+// It is empty if multi-company is not enabled.
+var companyModelName string
+
+// DeclareCompanyMixin declares the "CompanyMixin" mixin model with a "Company"
+// many2one field to the given company model and enables multi-company support.
+//
+// Models that inherit CompanyMixin:
+//
+// - get their Company field defaulted to the current company of the context,
+//
+// - are filtered by a global record rule so that only records without company
+// or records of the allowed companies of the context are accessible,
+//
+// - check that their many2one fields pointing to company dependent models
+// are consistent with their own company.
+//
+// It must be called once, before bootstrap, and returns the mixin model.
+func DeclareCompanyMixin(companyModel Modeler) *Model {
+	if companyModelName != "" {
+		log.Panic("Company mixin has already been declared", "companyModel", companyModelName)
+	}
+	companyModelName = companyModel.Underlying().name
+	companyMixin := NewMixinModel(companyMixinName)
+	companyMixin.AddMany2OneField("Company", ForeignKeyFieldParams{RelationModel: companyModel, Index: true,
+		Constraint: "CheckCompanyConsistency",
+		Default: func(env Environment, values FieldMap) interface{} {
+			return env.CompanyID()
+		},
+	})
+	companyMixin.AddMethod("CheckCompanyConsistency",
+		`CheckCompanyConsistency checks that the records linked to this RecordSet
+		through many2one fields belong to the same company as this RecordSet or
+		to no company at all. It panics otherwise.`,
+		func(rc RecordCollection) {
+			rc.checkCompanyConsistency()
+		}).AllowGroup(security.GroupEveryone)
+	return companyMixin
+}
+
+// CompanyID returns the id of the current company of this Environment
+// as given by the "company_id" key of the context. It returns 0 if
+// no company is set.
+func (env Environment) CompanyID() int64 {
+	return env.context.GetInteger(companyIDContextKey)
+}
+
+// CompanyIDs returns the ids of the companies that can be accessed in this
+// Environment as given by the "company_ids" key of the context. If this key
+// is not set, it returns the current company only, if any.
+func (env Environment) CompanyIDs() []int64 {
+	if ids := env.context.GetIntegerSlice(companyIDsContextKey); len(ids) > 0 {
+		return ids
+	}
+	if id := env.CompanyID(); id != 0 {
+		return []int64{id}
+	}
+	return nil
+}
+
+// WithCompany returns a new RecordSet with the given companyID as current company
+// in its context. If allowedIDs are given, they are set as the allowed companies,
+// otherwise only the given company is allowed.
+func (rc RecordCollection) WithCompany(companyID int64, allowedIDs ...int64) RecordCollection {
+	if len(allowedIDs) == 0 {
+		allowedIDs = []int64{companyID}
+	}
+	newCtx := rc.env.context.WithKey(companyIDContextKey, companyID).WithKey(companyIDsContextKey, allowedIDs)
+	return rc.WithNewContext(newCtx)
+}
+
+// isCompanyDependent returns true if this model inherits CompanyMixin
+func (m *Model) isCompanyDependent() bool {
+	if companyModelName == "" {
+		return false
+	}
+	for _, mixin := range m.mixins {
+		if mixin.name == companyMixinName || mixin.isCompanyDependent() {
+			return true
+		}
+	}
+	return false
+}
+
+// setupCompanyRules adds the multi-company global record rule
+// to all company dependent models.
+func setupCompanyRules() {
+	for _, model := range Registry.registryByName {
+		if model.isMixin() || !model.isCompanyDependent() {
+			continue
+		}
+		model.AddRecordRule(&RecordRule{
+			Name:   fmt.Sprintf("%s_company_rule", model.name),
+			Global: true,
+			Condition: model.Field("Company").IsNull().
+				Or().Field("Company").In(func(rs RecordSet) []int64 {
+				return allowedCompanyIDs(rs.Collection().Env())
+			}),
+			Perms: security.All,
+		})
+	}
+}
+
+// allowedCompanyIDs returns the ids of the companies that can be accessed in the
+// given Environment. If the context does not restrict companies, all companies
+// are allowed.
+func allowedCompanyIDs(env Environment) []int64 {
+	if ids := env.CompanyIDs(); len(ids) > 0 {
+		return ids
+	}
+	ids := env.Pool(companyModelName).Sudo().FetchAll().Ids()
+	if len(ids) == 0 {
+		// An empty IN clause is not valid SQL, and no record
+		// can be linked to a company anyway.
+		return []int64{0}
+	}
+	return ids
+}
+
+// checkCompanyConsistency panics if a record of this RecordCollection is linked
+// through a many2one field to a company dependent record of another company.
+func (rc RecordCollection) checkCompanyConsistency() {
+	// We read records of all companies to check the actual values
+	unrestricted := rc.Sudo().WithContext(companyIDContextKey, int64(0)).WithContext(companyIDsContextKey, []int64{})
+	for _, rec := range unrestricted.Records() {
+		company := rec.Get("Company").(RecordCollection)
+		for fName, fi := range rc.model.fields.registryByName {
+			if fName == "Company" || fi.fieldType != fieldtype.Many2One || !fi.relatedModel.isCompanyDependent() {
+				continue
+			}
+			related := rec.Get(fName).(RecordCollection)
+			if related.IsEmpty() {
+				continue
+			}
+			relCompany := related.Get("Company").(RecordCollection)
+			if relCompany.IsEmpty() || relCompany.Equals(company) {
+				continue
+			}
+			panic(NewValidationError(rc.T("Incompatible companies on records"), "model", rc.ModelName(), "id", rec.ids[0],
+				"field", fName, "company", company.ids, "relatedCompany", relCompany.ids))
+		}
+	}
+}
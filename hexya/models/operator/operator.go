@@ -23,6 +23,11 @@ const (
 	In             Operator = "in"
 	NotIn          Operator = "not in"
 	ChildOf        Operator = "child_of"
+	ParentOf       Operator = "parent_of"
+	Any            Operator = "any"
+	None           Operator = "not any"
+	All            Operator = "all"
+	Matches        Operator = "@@"
 )
 
 var allowedOperators = map[Operator]bool{
@@ -41,6 +46,11 @@ var allowedOperators = map[Operator]bool{
 	In:             true,
 	NotIn:          true,
 	ChildOf:        true,
+	ParentOf:       true,
+	Any:            true,
+	None:           true,
+	All:            true,
+	Matches:        true,
 }
 
 var multiOperator = map[Operator]bool{
@@ -53,6 +63,18 @@ func (o Operator) IsMulti() bool {
 	return multiOperator[o]
 }
 
+var subQueryOperator = map[Operator]bool{
+	Any:  true,
+	None: true,
+	All:  true,
+}
+
+// IsSubQuery returns true if the operator expects a Condition
+// on the related model of a 2many field as argument
+func (o Operator) IsSubQuery() bool {
+	return subQueryOperator[o]
+}
+
 // IsValid returns true if o is a known operator.
 func (o Operator) IsValid() bool {
 	_, res := allowedOperators[o]
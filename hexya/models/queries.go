@@ -16,10 +16,13 @@ package models
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
 	"github.com/hexya-erp/hexya/hexya/models/operator"
+	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/tools/nbutils"
 )
 
@@ -37,6 +40,55 @@ func (p SQLParams) Extend(p2 SQLParams) SQLParams {
 	return SQLParams(res)
 }
 
+// GroupSep separates a Date or DateTime field from its granularity
+// in GROUP BY expressions (e.g. 'CreateDate:month').
+const GroupSep = ":"
+
+// Date granularities of GROUP BY expressions
+const (
+	GroupByDay     = "day"
+	GroupByWeek    = "week"
+	GroupByMonth   = "month"
+	GroupByQuarter = "quarter"
+	GroupByYear    = "year"
+)
+
+var groupGranularities = map[string]bool{
+	GroupByDay:     true,
+	GroupByWeek:    true,
+	GroupByMonth:   true,
+	GroupByQuarter: true,
+	GroupByYear:    true,
+}
+
+// Aggregate functions that can be appended to a field after GroupSep
+// in Aggregates and Having expressions (e.g. 'Amount:avg').
+const (
+	AggregateSum           = "sum"
+	AggregateAvg           = "avg"
+	AggregateMin           = "min"
+	AggregateMax           = "max"
+	AggregateCount         = "count"
+	AggregateCountDistinct = "count_distinct"
+	AggregateArray         = "array_agg"
+	AggregateBoolAnd       = "bool_and"
+	AggregateBoolOr        = "bool_or"
+)
+
+// aggregateFieldTypes maps each aggregate function to the field types
+// it can be applied on. A nil map means any stored field.
+var aggregateFieldTypes = map[string]map[fieldtype.Type]bool{
+	AggregateSum:           {fieldtype.Integer: true, fieldtype.Float: true},
+	AggregateAvg:           {fieldtype.Integer: true, fieldtype.Float: true},
+	AggregateMin:           {fieldtype.Integer: true, fieldtype.Float: true, fieldtype.Date: true, fieldtype.DateTime: true, fieldtype.Char: true},
+	AggregateMax:           {fieldtype.Integer: true, fieldtype.Float: true, fieldtype.Date: true, fieldtype.DateTime: true, fieldtype.Char: true},
+	AggregateCount:         nil,
+	AggregateCountDistinct: nil,
+	AggregateArray:         {fieldtype.Integer: true, fieldtype.Many2One: true, fieldtype.One2One: true},
+	AggregateBoolAnd:       {fieldtype.Boolean: true},
+	AggregateBoolOr:        {fieldtype.Boolean: true},
+}
+
 // A Query defines the common part an SQL Query, i.e. all that come
 // after the FROM keyword.
 type Query struct {
@@ -47,12 +99,15 @@ type Query struct {
 	offset    int
 	groups    []string
 	orders    []string
+	having    *Condition
 }
 
 // clone returns a pointer to a deep copy of this Query
 func (q Query) clone() *Query {
 	newCond := *q.cond
 	q.cond = &newCond
+	newHaving := *q.having
+	q.having = &newHaving
 	return &q
 }
 
@@ -60,7 +115,7 @@ func (q Query) clone() *Query {
 // WHERE clause of this Query
 func (q *Query) sqlWhereClause() (string, SQLParams) {
 	q.evaluateConditionArgFunctions()
-	sql, args := q.conditionSQLClause(q.cond)
+	sql, args := q.conditionSQLClause(q.cond, false)
 	if sql != "" {
 		sql = "WHERE " + sql
 	}
@@ -69,7 +124,7 @@ func (q *Query) sqlWhereClause() (string, SQLParams) {
 
 // sqlClauses returns the sql string and parameters corresponding to the
 // WHERE clause of this Condition.
-func (q *Query) conditionSQLClause(c *Condition) (string, SQLParams) {
+func (q *Query) conditionSQLClause(c *Condition, having bool) (string, SQLParams) {
 	if c.IsEmpty() {
 		return "", SQLParams{}
 	}
@@ -80,7 +135,7 @@ func (q *Query) conditionSQLClause(c *Condition) (string, SQLParams) {
 
 	first := true
 	for _, val := range c.predicates {
-		vSQL, vArgs := q.predicateSQLClause(val, first)
+		vSQL, vArgs := q.predicateSQLClause(val, having, first)
 		first = false
 		sql += vSQL
 		args = args.Extend(vArgs)
@@ -88,10 +143,12 @@ func (q *Query) conditionSQLClause(c *Condition) (string, SQLParams) {
 	return sql, args
 }
 
-// sqlClause returns the sql WHERE clause for this predicate.
+// sqlClause returns the sql WHERE clause for this predicate, or its HAVING
+// clause if having is true. Aggregate expressions (e.g. 'Nums:sum') are only
+// allowed in HAVING clauses.
 // If 'first' is given and true, then the sql clause is not prefixed with
 // 'AND' and panics if isOr is true.
-func (q *Query) predicateSQLClause(p predicate, first ...bool) (string, SQLParams) {
+func (q *Query) predicateSQLClause(p predicate, having bool, first ...bool) (string, SQLParams) {
 	var (
 		sql     string
 		args    SQLParams
@@ -111,21 +168,44 @@ func (q *Query) predicateSQLClause(p predicate, first ...bool) (string, SQLParam
 	}
 
 	if p.isCond {
-		subSQL, subArgs := q.conditionSQLClause(p.cond)
+		subSQL, subArgs := q.conditionSQLClause(p.cond, having)
 		sql += fmt.Sprintf(`(%s) `, subSQL)
 		args = args.Extend(subArgs)
 		return sql, args
 	}
 
-	exprs := jsonizeExpr(q.recordSet.model, p.exprs)
+	pathExprs, aggFnct := p.splitAggregate()
+	if aggFnct != "" {
+		if !having {
+			log.Panic("Aggregate expressions can only be used in HAVING conditions", "model", q.recordSet.model, "expr", p.exprs)
+		}
+		checkAggregateExpr(q.recordSet.model, strings.Join(p.exprs, ExprSep))
+	}
+	exprs := jsonizeExpr(q.recordSet.model, pathExprs)
 	fi := q.recordSet.model.getRelatedFieldInfo(strings.Join(exprs, ExprSep))
-	if fi.fieldType.IsFKRelationType() {
+	if p.operator.IsSubQuery() {
+		subSQL, subArgs := q.subQueryPredicateSQL(exprs, fi, p)
+		sql += subSQL
+		args = args.Extend(subArgs)
+		return sql, args
+	}
+	if p.operator == operator.Matches {
+		vector := q.textSearchVectorExpression(exprs, fi)
+		sql += fmt.Sprintf(`%s `, adapter.textSearchMatchSQL(vector, fi.textSearchConfig()))
+		args = append(args, p.arg)
+		return sql, args
+	}
+	if fi.fieldType.IsFKRelationType() && aggFnct == "" {
 		// If we have a relation type with a 0 as foreign key, we substitute for nil
 		if valInt, err := nbutils.CastToInteger(p.arg); err == nil && valInt == 0 {
 			p.arg = nil
 		}
 	}
 	field := q.joinedFieldExpression(exprs)
+	if aggFnct != "" {
+		// Aggregated value in a HAVING clause
+		field = q.sqlFieldExpression(strings.Join(p.exprs, ExprSep))
+	}
 	if p.arg == nil {
 		switch p.operator {
 		case operator.Equals:
@@ -138,12 +218,60 @@ func (q *Query) predicateSQLClause(p predicate, first ...bool) (string, SQLParam
 		return sql, args
 	}
 
+	if fi.encrypted && aggFnct == "" {
+		p.operator, p.arg = encryptedPredicate(fi, p.operator, p.arg)
+	}
 	opSql, arg := adapter.operatorSQL(p.operator, p.arg)
 	sql += fmt.Sprintf(`%s %s `, field, opSql)
 	args = append(args, arg)
 	return sql, args
 }
 
+// subQueryPredicateSQL returns the sql clause for the given predicate with an
+// Any, None or All operator on the 2many field fi pointed at by exprs.
+//
+// The predicate's Condition is applied to the related model with its record
+// rules in a sub query. Records with no related records never match Any, and
+// always match None and All.
+func (q *Query) subQueryPredicateSQL(exprs []string, fi *Field, p predicate) (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	var linkTable, ourField, theirField string
+	switch fi.fieldType {
+	case fieldtype.One2Many:
+		linkTable = fi.relatedModel.tableName
+		ourField, theirField = jsonizePath(fi.relatedModel, fi.reverseFK), "id"
+	case fieldtype.Many2Many:
+		linkTable = fi.m2mRelModel.tableName
+		ourField, theirField = fi.m2mOurField.json, fi.m2mTheirField.json
+	default:
+		log.Panic("Any, None and All operators can only be used on One2Many and Many2Many fields",
+			"model", q.recordSet.model, "field", strings.Join(p.exprs, ExprSep), "operator", p.operator)
+	}
+	cond, ok := p.arg.(*Condition)
+	if !ok || cond == nil {
+		log.Panic("Any, None and All operators expect a *Condition argument", "operator", p.operator, "arg", p.arg)
+	}
+	subRS := newRecordCollection(*q.recordSet.env, fi.relatedModel.name).Search(cond)
+	subRS = subRS.addRecordRuleConditions(subRS.env.uid, security.Read)
+	addNameSearchesToCondition(subRS.model, subRS.query.cond)
+	inflate2ManyConditions(subRS.model, subRS.query.cond)
+	_, subRS = subRS.substituteRelatedFields(nil)
+	subSQL, args := subRS.query.selectQuery([]string{"id"})
+
+	joins := q.generateTableJoins(exprs)
+	ownerAlias := joins[len(joins)-1].alias
+	exists, in := "EXISTS", "IN"
+	switch p.operator {
+	case operator.None:
+		exists = "NOT EXISTS"
+	case operator.All:
+		exists, in = "NOT EXISTS", "NOT IN"
+	}
+	sql := fmt.Sprintf(`%s (SELECT 1 FROM %s "sub_link" WHERE "sub_link".%s = %s.id AND "sub_link".%s %s (%s)) `,
+		exists, adapter.quoteTableName(linkTable), ourField, ownerAlias, theirField, in, subSQL)
+	return sql, args
+}
+
 // sqlLimitClause returns the sql string for the LIMIT and OFFSET clauses
 // of this Query
 func (q *Query) sqlLimitOffsetClause() string {
@@ -159,44 +287,166 @@ func (q *Query) sqlLimitOffsetClause() string {
 
 // sqlOrderByClause returns the sql string for the ORDER BY clause
 // of this Query
+//
+// Text search ranks are referred to by their alias in the select clause.
 func (q *Query) sqlOrderByClause() string {
-	if len(q.orders) == 0 {
+	orders := q.orderExprs()
+	if len(orders) == 0 {
 		return "ORDER BY id"
 	}
 
-	var fExprs [][]string
-	directions := make([]string, len(q.orders))
-	for i, order := range q.orders {
-		fieldOrder := strings.Split(strings.TrimSpace(order), " ")
-		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(fieldOrder[0], ExprSep))
-		fExprs = append(fExprs, oExprs)
-		if len(fieldOrder) > 1 {
-			directions[i] = fieldOrder[1]
+	adapter := adapters[db.DriverName()]
+	resSlice := make([]string, len(orders))
+	for i, order := range orders {
+		_, suffix := splitGroupExpr(order.field)
+		switch {
+		case suffix == "", groupGranularities[suffix]:
+			resSlice[i] = q.sqlFieldExpression(order.field)
+		default:
+			// Text search ranks and aggregates are selected with their alias
+			resSlice[i] = adapter.quoteTableName(groupAlias(q.recordSet.model, order.field))
+		}
+		resSlice[i] += fmt.Sprintf(" %s", order.direction)
+		if order.nulls != "" {
+			resSlice[i] += fmt.Sprintf(" nulls %s", order.nulls)
 		}
-	}
-	resSlice := make([]string, len(q.orders))
-	for i, field := range fExprs {
-		resSlice[i] = q.joinedFieldExpression(field)
-		resSlice[i] += fmt.Sprintf(" %s", directions[i])
 	}
 	return fmt.Sprintf("ORDER BY %s", strings.Join(resSlice, ", "))
 }
 
+// orderExprs returns the parsed ORDER BY expressions of this Query.
+//
+// Grouped queries are ordered by their groups by default and other
+// queries by the default order of the model, if any.
+func (q *Query) orderExprs() []orderExpr {
+	orders := q.orders
+	switch {
+	case len(orders) > 0:
+	case len(q.groups) > 0:
+		orders = q.groups
+	default:
+		orders = q.recordSet.model.defaultOrder
+	}
+	res := make([]orderExpr, len(orders))
+	for i, order := range orders {
+		res[i] = q.checkOrderExpr(parseOrderExpr(q.recordSet.model, order))
+	}
+	return res
+}
+
+// checkOrderExpr panics if the given order expression cannot be used in this
+// Query. In grouped queries, fields that are not grouped are substituted by
+// their group operator aggregate (e.g. 'Nums' becomes 'Nums:sum').
+//
+// Date granularities can only be used in grouped queries, since truncated
+// dates are not selected by other queries.
+func (q *Query) checkOrderExpr(order orderExpr) orderExpr {
+	path, suffix := splitGroupExpr(order.field)
+	isAggregate := suffix != "" && !groupGranularities[suffix] && suffix != TextSearchRank
+	if len(q.groups) == 0 {
+		switch {
+		case isAggregate:
+			log.Panic("Ordering by an aggregate requires a grouped query", "model", q.recordSet.model, "order", order.field)
+		case groupGranularities[suffix]:
+			log.Panic("Ordering by a date granularity requires a grouped query", "model", q.recordSet.model, "order", order.field)
+		}
+		return order
+	}
+	switch {
+	case isAggregate:
+		return order
+	case suffix == TextSearchRank:
+		log.Panic("Grouped queries cannot be ordered by text search rank", "model", q.recordSet.model, "order", order.field)
+	}
+	alias := groupAlias(q.recordSet.model, order.field)
+	for _, group := range q.groups {
+		if groupAlias(q.recordSet.model, group) == alias {
+			return order
+		}
+	}
+	fi := q.recordSet.model.getRelatedFieldInfo(path)
+	if suffix != "" || (fi.fieldType != fieldtype.Float && fi.fieldType != fieldtype.Integer) || fi.groupOperator == "" {
+		log.Panic("Grouped queries can only be ordered by groups and aggregates", "model", q.recordSet.model, "order", order.field)
+	}
+	order.field = path + GroupSep + fi.groupOperator
+	return order
+}
+
+// orderAggregates returns the aggregate expressions (e.g. 'Nums:sum')
+// by which this Query is ordered. It is empty for non grouped queries.
+func (q *Query) orderAggregates() []string {
+	if len(q.groups) == 0 {
+		return nil
+	}
+	var res []string
+	for _, order := range q.orderExprs() {
+		if _, suffix := splitGroupExpr(order.field); suffix != "" && !groupGranularities[suffix] {
+			res = append(res, order.field)
+		}
+	}
+	return res
+}
+
 // sqlGroupByClause returns the sql string for the GROUP BY clause
 // of this Query
 func (q *Query) sqlGroupByClause() string {
-	var fExprs [][]string
-	for _, group := range q.groups {
-		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(group, ExprSep))
-		fExprs = append(fExprs, oExprs)
-	}
 	resSlice := make([]string, len(q.groups))
-	for i, field := range fExprs {
-		resSlice[i] = q.joinedFieldExpression(field)
+	for i, group := range q.groups {
+		resSlice[i] = q.sqlFieldExpression(group)
 	}
 	return fmt.Sprintf("GROUP BY %s", strings.Join(resSlice, ", "))
 }
 
+// sqlHavingClause returns the sql string and parameters corresponding to
+// the HAVING clause of this Query
+func (q *Query) sqlHavingClause() (string, SQLParams) {
+	sql, args := q.conditionSQLClause(q.having, true)
+	if sql != "" {
+		sql = "HAVING " + sql
+	}
+	return sql, args
+}
+
+// sqlFieldExpression returns the sql expression of the given field path expr,
+// which can be suffixed by a date granularity as in GROUP BY expressions
+// (e.g. 'CreateDate:month') or by an aggregate function (e.g. 'Nums:avg').
+//
+// It panics if the suffix is neither a date granularity nor an aggregate
+// function, since it is written as is into the SQL query.
+func (q *Query) sqlFieldExpression(expr string) string {
+	path, suffix := splitGroupExpr(expr)
+	exprs := jsonizeExpr(q.recordSet.model, strings.Split(path, ExprSep))
+	res := q.joinedFieldExpression(exprs)
+	adapter := adapters[db.DriverName()]
+	switch {
+	case suffix == "":
+		return res
+	case groupGranularities[suffix]:
+		fType := q.recordSet.model.getRelatedFieldInfo(path).fieldType
+		return adapter.dateTruncSQL(suffix, res, fType, q.timezone().String())
+	default:
+		if _, ok := aggregateFieldTypes[suffix]; !ok {
+			log.Panic("Unknown date granularity or aggregate function", "model", q.recordSet.model, "expr", expr)
+		}
+		return adapter.aggregateSQL(suffix, res)
+	}
+}
+
+// timezone returns the location in which dates are truncated in
+// GROUP BY expressions, as given by the 'tz' key of the context.
+// It defaults to UTC.
+func (q *Query) timezone() *time.Location {
+	tz := q.recordSet.env.context.GetString("tz")
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Panic("Invalid timezone in context", "tz", tz, "error", err)
+	}
+	return loc
+}
+
 // deleteQuery returns the SQL query string and parameters to unlink
 // the rows pointed at by this Query object.
 func (q *Query) deleteQuery() (string, SQLParams) {
@@ -214,30 +464,109 @@ func (q *Query) insertQuery(data FieldMap) (string, SQLParams) {
 		log.Panic("No data given for insert")
 	}
 	var (
-		cols []string
-		vals SQLParams
-		i    int
-		sql  string
+		cols         []string
+		placeholders []string
+		vals         SQLParams
+		sql          string
 	)
 	for k, v := range data {
 		fi := q.recordSet.model.fields.MustGet(k)
-		if fi.fieldType.IsFKRelationType() && !fi.required {
-			if _, ok := v.(*interface{}); ok {
-				// We have a null fk field
-				continue
-			}
+		if isNullForeignKey(fi, v) {
+			continue
 		}
 		cols = append(cols, fi.json)
-		vals = append(vals, v)
-		i++
+		placeholders = append(placeholders, "?")
+		vals = append(vals, encryptFieldValue(fi, v))
+		if fi.fullTextSearch {
+			cols = append(cols, fi.textSearchColumn())
+			placeholders = append(placeholders, adapter.textSearchVectorSQL("?", fi.textSearchConfig()))
+			vals = append(vals, v)
+		}
 	}
 	tableName := adapter.quoteTableName(q.recordSet.model.tableName)
 	fields := strings.Join(cols, ", ")
-	values := "?" + strings.Repeat(", ?", i-1)
+	values := strings.Join(placeholders, ", ")
 	sql = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", tableName, fields, values)
 	return sql, vals
 }
 
+// insertMultiQuery returns the SQL query string and parameters to insert one row
+// for each of the given FieldMaps in a single query. Columns that are not set
+// in a FieldMap are given their default value in the corresponding row.
+func (q *Query) insertMultiQuery(data []FieldMap) (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	if len(data) == 0 {
+		log.Panic("No data given for insert")
+	}
+	rowsValues := make([]map[string]interface{}, len(data))
+	colFields := make(map[string]*Field)
+	for i, fMap := range data {
+		rowsValues[i] = make(map[string]interface{})
+		for k, v := range fMap {
+			fi := q.recordSet.model.fields.MustGet(k)
+			if isNullForeignKey(fi, v) {
+				continue
+			}
+			colFields[fi.json] = fi
+			rowsValues[i][fi.json] = v
+		}
+	}
+	if len(colFields) == 0 {
+		// At least one column is needed to insert several rows
+		colFields["id"] = q.recordSet.model.fields.MustGet("id")
+	}
+	var fieldCols []string
+	for col := range colFields {
+		fieldCols = append(fieldCols, col)
+	}
+	sort.Strings(fieldCols)
+	var (
+		cols []string
+		rows []string
+		vals SQLParams
+	)
+	for _, col := range fieldCols {
+		cols = append(cols, col)
+		if colFields[col].fullTextSearch {
+			cols = append(cols, colFields[col].textSearchColumn())
+		}
+	}
+	for _, rowValues := range rowsValues {
+		var placeholders []string
+		for _, col := range fieldCols {
+			fi := colFields[col]
+			v, ok := rowValues[col]
+			if !ok {
+				placeholders = append(placeholders, "DEFAULT")
+				if fi.fullTextSearch {
+					placeholders = append(placeholders, "DEFAULT")
+				}
+				continue
+			}
+			placeholders = append(placeholders, "?")
+			vals = append(vals, encryptFieldValue(fi, v))
+			if fi.fullTextSearch {
+				placeholders = append(placeholders, adapter.textSearchVectorSQL("?", fi.textSearchConfig()))
+				vals = append(vals, v)
+			}
+		}
+		rows = append(rows, fmt.Sprintf("(%s)", strings.Join(placeholders, ", ")))
+	}
+	tableName := adapter.quoteTableName(q.recordSet.model.tableName)
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", tableName, strings.Join(cols, ", "), strings.Join(rows, ", "))
+	return sql, vals
+}
+
+// isNullForeignKey returns true if v is the null value
+// of the optional foreign key field fi.
+func isNullForeignKey(fi *Field, v interface{}) bool {
+	if !fi.fieldType.IsFKRelationType() || fi.required {
+		return false
+	}
+	_, ok := v.(*interface{})
+	return ok
+}
+
 // countQuery returns the SQL query string and parameters to count
 // the rows pointed at by this Query object.
 func (q *Query) countQuery() (string, SQLParams) {
@@ -266,10 +595,13 @@ func (q *Query) selectQuery(fields []string) (string, SQLParams) {
 	// Tables
 	tablesSQL := q.tablesSQL(allExprs)
 	// Where clause and args
-	whereSQL, args := q.sqlWhereClause()
+	whereSQL, whereArgs := q.sqlWhereClause()
+	// Text search ranks are selected, so their args come first
+	ranksSQL, args := q.textSearchRanksSQL()
+	args = args.Extend(whereArgs)
 	orderSQL := q.sqlOrderByClause()
 	limitSQL := q.sqlLimitOffsetClause()
-	selQuery := fmt.Sprintf(`SELECT DISTINCT %s FROM %s %s %s %s`, fieldsSQL, tablesSQL, whereSQL, orderSQL, limitSQL)
+	selQuery := fmt.Sprintf(`SELECT DISTINCT %s%s FROM %s %s %s %s`, fieldsSQL, ranksSQL, tablesSQL, whereSQL, orderSQL, limitSQL)
 	return selQuery, args
 }
 
@@ -281,8 +613,10 @@ func (q *Query) selectQuery(fields []string) (string, SQLParams) {
 //
 // fields keys are a dot-separated expression pointing at the field, either
 // as names or columns (e.g. 'User.Name' or 'user_id.name').
-// fields values are
-func (q *Query) selectGroupQuery(fields map[string]string) (string, SQLParams) {
+// fields values are the default aggregate functions of the fields.
+//
+// aggregates are additional aggregate expressions such as 'Profile.Age:avg'.
+func (q *Query) selectGroupQuery(fields map[string]string, aggregates []string) (string, SQLParams) {
 	if len(q.groups) == 0 {
 		log.Panic("Calling selectGroupQuery on a query without Group By clause")
 	}
@@ -293,18 +627,37 @@ func (q *Query) selectGroupQuery(fields map[string]string) (string, SQLParams) {
 		i++
 	}
 	fieldExprs, allExprs := q.selectData(fieldsList)
+	selected := make(map[string]bool)
+	for _, agg := range aggregates {
+		selected[agg] = true
+	}
+	aggregates = append([]string(nil), aggregates...)
+	for _, agg := range q.orderAggregates() {
+		if !selected[agg] {
+			// Aggregates must be selected to be used in the ORDER BY clause
+			aggregates = append(aggregates, agg)
+			selected[agg] = true
+		}
+	}
+	for _, agg := range aggregates {
+		path, _ := splitGroupExpr(agg)
+		allExprs = append(allExprs, jsonizeExpr(q.recordSet.model, strings.Split(path, ExprSep)))
+	}
+	allExprs = append(allExprs, q.having.getAllExpressions(q.recordSet.model)...)
 	// Build up the query
 	// Fields
-	fieldsSQL := q.fieldsGroupSQL(fieldExprs, fields)
+	fieldsSQL := q.fieldsGroupSQL(fieldExprs, fields, aggregates)
 	// Tables
 	tablesSQL := q.tablesSQL(allExprs)
 	// Where clause and args
 	whereSQL, args := q.sqlWhereClause()
 	// Group by clause
 	groupSQL := q.sqlGroupByClause()
+	havingSQL, havingArgs := q.sqlHavingClause()
+	args = args.Extend(havingArgs)
 	orderSQL := q.sqlOrderByClause()
 	limitSQL := q.sqlLimitOffsetClause()
-	selQuery := fmt.Sprintf(`SELECT DISTINCT %s FROM %s %s %s %s %s`, fieldsSQL, tablesSQL, whereSQL, groupSQL, orderSQL, limitSQL)
+	selQuery := fmt.Sprintf(`SELECT DISTINCT %s FROM %s %s %s %s %s %s`, fieldsSQL, tablesSQL, whereSQL, groupSQL, havingSQL, orderSQL, limitSQL)
 	return selQuery, args
 }
 
@@ -319,17 +672,29 @@ func (q *Query) selectData(fields []string) ([][]string, [][]string) {
 		fieldExprs[i] = jsonizeExpr(q.recordSet.model, strings.Split(f, ExprSep))
 	}
 	// Add 'order by' exprs
-	for _, order := range q.orders {
-		orderField := strings.Split(strings.TrimSpace(order), " ")[0]
-		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(orderField, ExprSep))
+	var dateExprs [][]string
+	for _, order := range q.orderExprs() {
+		path, granularity := splitGroupExpr(order.field)
+		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(path, ExprSep))
+		if granularity != "" {
+			// Truncated dates and text search ranks are not fields but we need their joins
+			dateExprs = append(dateExprs, oExprs)
+			continue
+		}
 		fieldExprs = append(fieldExprs, oExprs)
 	}
+	for _, group := range q.groups {
+		if path, granularity := splitGroupExpr(group); granularity != "" {
+			dateExprs = append(dateExprs, jsonizeExpr(q.recordSet.model, strings.Split(path, ExprSep)))
+		}
+	}
 	// Then given by condition
-	allExprs := append(fieldExprs, q.cond.getAllExpressions(q.recordSet.model)...)
+	allExprs := append(fieldExprs, dateExprs...)
+	allExprs = append(allExprs, q.cond.getAllExpressions(q.recordSet.model)...)
 	return fieldExprs, allExprs
 }
 
-// substituteChildOfPredicates replaces in the query the predicates with ChildOf
+// substituteChildOfPredicates replaces in the query the predicates with ChildOf or ParentOf
 // operator by the predicates to actually execute.
 func (q *Query) substituteChildOfPredicates() {
 	q.cond.substituteChildOfOperator(q.recordSet)
@@ -342,17 +707,19 @@ func (q *Query) updateQuery(data FieldMap) (string, SQLParams) {
 	if len(data) == 0 {
 		log.Panic("No data given for update")
 	}
-	cols := make([]string, len(data))
-	vals := make(SQLParams, len(data))
 	var (
-		i   int
-		sql string
+		cols []string
+		vals SQLParams
+		sql  string
 	)
 	for k, v := range data {
 		fi := q.recordSet.model.fields.MustGet(k)
-		cols[i] = fmt.Sprintf("%s = ?", fi.json)
-		vals[i] = v
-		i++
+		cols = append(cols, fmt.Sprintf("%s = ?", fi.json))
+		vals = append(vals, encryptFieldValue(fi, v))
+		if fi.fullTextSearch {
+			cols = append(cols, fmt.Sprintf("%s = %s", fi.textSearchColumn(), adapter.textSearchVectorSQL("?", fi.textSearchConfig())))
+			vals = append(vals, v)
+		}
 	}
 	tableName := adapter.quoteTableName(q.recordSet.model.tableName)
 	updates := strings.Join(cols, ", ")
@@ -377,15 +744,29 @@ func (q *Query) fieldsSQL(fieldExprs [][]string) string {
 // in a select query with a GROUP BY clause.
 // Parameter must be with the following format (column names):
 // [['user_id', 'name'] ['id'] ['profile_id', 'age']]
-func (q *Query) fieldsGroupSQL(fieldExprs [][]string, fields map[string]string) string {
-	fStr := make([]string, len(fieldExprs)+1)
+//
+// Grouped dates and the given aggregates expressions are selected
+// with their groupAlias.
+func (q *Query) fieldsGroupSQL(fieldExprs [][]string, fields map[string]string, aggregates []string) string {
+	adapter := adapters[db.DriverName()]
+	fStr := make([]string, len(fieldExprs))
 	for i, exprs := range fieldExprs {
 		aggFnct := fields[strings.Join(exprs, ExprSep)]
 		joins := q.generateTableJoins(exprs)
 		num := len(joins)
 		fStr[i] = fmt.Sprintf("%s(%s.%s) AS %s", aggFnct, joins[num-1].alias, exprs[num-1], strings.Join(exprs, sqlSep))
 	}
-	fStr[len(fieldExprs)] = "count(1) AS __count"
+	for _, group := range q.groups {
+		if _, granularity := splitGroupExpr(group); granularity != "" {
+			alias := adapter.quoteTableName(groupAlias(q.recordSet.model, group))
+			fStr = append(fStr, fmt.Sprintf("%s AS %s", q.sqlFieldExpression(group), alias))
+		}
+	}
+	for _, agg := range aggregates {
+		alias := adapter.quoteTableName(groupAlias(q.recordSet.model, agg))
+		fStr = append(fStr, fmt.Sprintf("%s AS %s", q.sqlFieldExpression(agg), alias))
+	}
+	fStr = append(fStr, "count(1) AS __count")
 	return strings.Join(fStr, ", ")
 }
 
@@ -522,6 +903,7 @@ func newQuery(rs ...RecordCollection) *Query {
 	}
 	return &Query{
 		cond:      newCondition(),
+		having:    newCondition(),
 		recordSet: rset,
 	}
 }
@@ -16,6 +16,10 @@ package models
 
 import "github.com/hexya-erp/hexya/hexya/models/security"
 
+// RecomputeBatchSize is the number of records processed in each batch when
+// new stored computed fields are computed at database synchronization.
+const RecomputeBatchSize = 1000
+
 // computeFieldValues updates the given params with the given computed (non stored) fields
 // or all the computed fields of the model if not given.
 // Returned fieldMap keys are field's JSON name
@@ -42,12 +46,20 @@ func (rc RecordCollection) computeFieldValues(params *FieldMap, fields ...string
 func (rc RecordCollection) updateStoredFields(fMap FieldMap) {
 	fieldNames := fMap.Keys()
 	var toUpdate []computeData
+	// Each dependency is computed only once, even if it depends on several fields
+	added := make(map[computeData]bool)
 	for _, fieldName := range fieldNames {
 		refFieldInfo, ok := rc.model.fields.get(fieldName)
 		if !ok {
 			continue
 		}
-		toUpdate = append(toUpdate, refFieldInfo.dependencies...)
+		for _, dep := range refFieldInfo.dependencies {
+			if added[dep] {
+				continue
+			}
+			added[dep] = true
+			toUpdate = append(toUpdate, dep)
+		}
 	}
 	// Compute all that must be computed and store the values
 	rSet := rc.Fetch()
@@ -58,12 +70,54 @@ func (rc RecordCollection) updateStoredFields(fMap FieldMap) {
 		} else {
 			recs = rSet
 		}
-		recs = recs.Fetch()
-		for _, rec := range recs.Records() {
-			retVal := rec.CallMulti(cData.compute)
-			vals := retVal[0].(FieldMapper).FieldMap()
-			toUnset := retVal[1].([]FieldNamer)
-			rec.WithContext("hexya_force_compute_write", true).Call("Write", vals, toUnset)
+		recs.Fetch().storeComputedValues(cData.compute)
+	}
+}
+
+// storeComputedValues calls the given compute method on each record of
+// this RecordCollection and writes the returned values in the database.
+func (rc RecordCollection) storeComputedValues(compute string) {
+	for _, rec := range rc.Records() {
+		retVal := rec.CallMulti(compute)
+		vals := retVal[0].(FieldMapper).FieldMap()
+		toUnset := retVal[1].([]FieldNamer)
+		rec.WithContext("hexya_force_compute_write", true).Call("Write", vals, toUnset)
+	}
+}
+
+// Recompute computes again the given stored computed fields of the records
+// of this RecordCollection and writes the new values in the database, as if
+// one of their dependencies had been modified. If no fields are given, all
+// the stored computed fields of the model are recomputed.
+//
+// Records are processed in batches of batchSize records (see Iterate), so
+// that Recompute can be called on large tables, for instance after a change
+// in a compute method or after loading data with raw SQL.
+//
+// Recomputed values are written without sending webhooks nor bus notifications.
+//
+// It panics if one of the given fields is not a stored computed field.
+func (rc RecordCollection) Recompute(batchSize int, fields ...FieldNamer) {
+	fInfos := rc.model.fields.storedComputedFields(convertToStringSlice(fields)...)
+	// Fields computed by the same method are computed only once
+	var computes []string
+	added := make(map[string]bool)
+	for _, fInfo := range fInfos {
+		if added[fInfo.compute] {
+			continue
 		}
+		added[fInfo.compute] = true
+		computes = append(computes, fInfo.compute)
+	}
+	if len(computes) == 0 {
+		return
 	}
+	// We fetch the ids first so that writing computed values
+	// does not change the records of the batches to come.
+	rc.withoutNotifications().Fetch().Iterate(batchSize, func(batch RecordCollection) bool {
+		for _, compute := range computes {
+			batch.storeComputedValues(compute)
+		}
+		return true
+	})
 }
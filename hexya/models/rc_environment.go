@@ -20,8 +20,13 @@ import (
 )
 
 // WithEnv returns a copy of the current RecordCollection with the given Environment.
+//
+// The query of the returned RecordCollection is bound to it, so that it is
+// built with the new context (e.g. timezone or language).
 func (rc RecordCollection) WithEnv(env Environment) RecordCollection {
 	rc.env = &env
+	rc.query = rc.query.clone()
+	rc.query.recordSet = rc
 	return rc
 }
 
@@ -42,6 +47,14 @@ func (rc RecordCollection) WithNewContext(context *types.Context) RecordCollecti
 	return rc.WithEnv(newEnv)
 }
 
+// withoutNotifications returns a copy of the current RecordCollection whose
+// changes trigger neither webhooks nor bus notifications.
+func (rc RecordCollection) withoutNotifications() RecordCollection {
+	newEnv := *rc.env
+	newEnv.noNotify = true
+	return rc.WithEnv(newEnv)
+}
+
 // Sudo returns a new RecordCollection with the given userId
 // or the superuser id if not specified
 func (rc RecordCollection) Sudo(userId ...int64) RecordCollection {
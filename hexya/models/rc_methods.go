@@ -108,8 +108,8 @@ func (rc RecordCollection) callMulti(methLayer *methodLayer, args ...interface{}
 	return res
 }
 
-// checkExecutionPermission panics if the current user is not allowed to
-// execute the given method
+// checkExecutionPermission panics with an AccessError if the current
+// user is not allowed to execute the given method
 func (rc RecordCollection) checkExecutionPermission(method *Method) {
 	var caller *Method
 	if len(rc.env.callStack) > 1 {
@@ -131,5 +131,6 @@ func (rc RecordCollection) checkExecutionPermission(method *Method) {
 			return
 		}
 	}
-	log.Panic("You are not allowed to execute this method", "model", rc.ModelName(), "method", method.name, "uid", rc.env.uid)
+	panic(NewAccessError(rc.T("You are not allowed to execute method %s on %s", method.name, rc.ModelName()),
+		"model", rc.ModelName(), "method", method.name, "uid", rc.env.uid))
 }
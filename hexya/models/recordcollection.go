@@ -85,6 +85,7 @@ func (rc RecordCollection) create(data FieldMapper) RecordCollection {
 	fMap = rc.createEmbeddedRecords(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePKIfZero()
+	rc.model.removeParentPath(&fMap)
 	fMap = rc.processInverseMethods(fMap)
 	storedFieldMap := filterMapOnStoredFields(rc.model, fMap)
 	// insert in DB
@@ -93,23 +94,175 @@ func (rc RecordCollection) create(data FieldMapper) RecordCollection {
 	rc.env.cr.Get(&createdId, sql, args...)
 
 	rSet := rc.withIds([]int64{createdId})
+	// set the materialized path of the hierarchy
+	rSet.updateParentPaths()
 	// update reverse relation fields
 	rSet.updateRelationFields(fMap)
 	// compute stored fields
 	rSet.updateStoredFields(fMap)
 	rSet.checkConstraints()
+	rSet.enqueueWebhooks(Webhooks.triggered(rSet.env, rSet.model.name, WebhookCreate, nil), WebhookCreate, rSet.ids, nil)
+	rSet.publishChange(WebhookCreate, rSet.ids, nil)
 	return rSet
 }
 
+// insertMultiMaxParams is the maximum number of parameters
+// of the multi-row INSERT queries of createMulti.
+const insertMultiMaxParams = 65535
+
+// createMulti inserts new records in the database with the given data, which
+// can be FieldMaps or struct pointers of the same model as rs. Records are
+// inserted with multi-row INSERT queries and returned in the order of data.
+// Their ids are allocated before the INSERT queries, since the order of the
+// rows returned by these queries is not guaranteed.
+//
+// Extensions of the Create method are not called.
+//
+// Defaults, embedded records and inverse methods are processed for each record
+// as in create, while stored computed fields, constraints and notifications are
+// processed once for all the created records.
+// This function is private and low level. It should not be called directly.
+// Instead use rs.Call("CreateMulti")
+func (rc RecordCollection) createMulti(data []FieldMapper) RecordCollection {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(rc.substituteSQLErrorMessage(r))
+		}
+	}()
+	rc.checkExecutionPermission(rc.model.methods.MustGet("Create"))
+	fMaps := make([]FieldMap, len(data))
+	for i, d := range data {
+		fMap := d.FieldMap()
+		fMap = filterMapOnAuthorizedFields(rc.model, fMap, rc.env.uid, security.Write)
+		rc.applyDefaults(&fMap)
+		rc.addAccessFieldsCreateData(&fMap)
+		rc.model.convertValuesToFieldType(&fMap)
+		fMaps[i] = fMap
+	}
+	rc.createEmbeddedRecordsMulti(fMaps)
+	storedFieldMaps := make([]FieldMap, len(fMaps))
+	allFields := make(FieldMap)
+	var rowParams int
+	for i, fMap := range fMaps {
+		// clean our fMap from ID and non stored fields
+		fMap.RemovePKIfZero()
+		rc.model.removeParentPath(&fMap)
+		fMaps[i] = rc.processInverseMethods(fMap)
+		storedFieldMaps[i] = filterMapOnStoredFields(rc.model, fMaps[i])
+		for k := range fMaps[i] {
+			allFields[k] = true
+		}
+		// Full text search fields have a second parameter for their vector
+		// and the preallocated id is added to each row below.
+		if 2*len(storedFieldMaps[i])+1 > rowParams {
+			rowParams = 2*len(storedFieldMaps[i]) + 1
+		}
+	}
+	// insert in DB by batches that fit in the query parameters limit
+	batchSize := len(storedFieldMaps)
+	if insertMultiMaxParams/rowParams < batchSize {
+		batchSize = insertMultiMaxParams / rowParams
+	}
+	ids := rc.newIds(len(storedFieldMaps))
+	for i, id := range ids {
+		if givenID, ok := storedFieldMaps[i].Get("id", rc.model); ok {
+			ids[i] = givenID.(int64)
+			continue
+		}
+		storedFieldMaps[i]["id"] = id
+	}
+	for start := 0; start < len(storedFieldMaps); start += batchSize {
+		end := start + batchSize
+		if end > len(storedFieldMaps) {
+			end = len(storedFieldMaps)
+		}
+		sql, args := rc.query.insertMultiQuery(storedFieldMaps[start:end])
+		rc.env.cr.Execute(sql, args...)
+	}
+
+	rSet := rc.withIds(ids)
+	if len(ids) == 0 {
+		return rSet
+	}
+	// set the materialized path of the hierarchy
+	rSet.updateParentPaths()
+	// update reverse relation fields, which are specific to each record
+	for i, id := range ids {
+		rc.withIds([]int64{id}).updateRelationFields(fMaps[i])
+	}
+	// compute stored fields
+	rSet.updateStoredFields(allFields)
+	rSet.checkConstraints()
+	rSet.enqueueWebhooks(Webhooks.triggered(rSet.env, rSet.model.name, WebhookCreate, nil), WebhookCreate, rSet.ids, nil)
+	rSet.publishChange(WebhookCreate, rSet.ids, nil)
+	return rSet
+}
+
+// newIds returns n new ids allocated from the id sequence of this
+// RecordCollection's table.
+func (rc RecordCollection) newIds(n int) []int64 {
+	if n == 0 {
+		return []int64{}
+	}
+	var ids []int64
+	rc.env.cr.Select(&ids, adapters[db.DriverName()].newIdsQuery(rc.model.tableName), n)
+	return ids
+}
+
 // createEmbeddedRecords creates the records that are embedded in this
 // one if they don't already exist. It returns the given fMap with the
 // ids inserted for the embedded records.
 func (rc RecordCollection) createEmbeddedRecords(fMap FieldMap) FieldMap {
-	type modelAndValues struct {
-		model  string
-		values FieldMap
+	for fieldName, vals := range rc.embeddedRecordsData(fMap) {
+		// We do not call "create" directly to have the caller set in the callstack for permissions
+		res := rc.env.Pool(vals.model).Call("Create", vals.values)
+		if resRS, ok := res.(RecordSet); ok {
+			fMap[fieldName] = resRS.Ids()[0]
+		}
+	}
+	return fMap
+}
+
+// createEmbeddedRecordsMulti creates the records that are embedded in the
+// records of the given fMaps if they don't already exist, with one CreateMulti
+// call per embedded field. The ids of the embedded records are inserted in fMaps.
+func (rc RecordCollection) createEmbeddedRecordsMulti(fMaps []FieldMap) {
+	type embeddedRecords struct {
+		model   string
+		indexes []int
+		values  []FieldMapper
+	}
+	embedded := make(map[string]*embeddedRecords)
+	for i, fMap := range fMaps {
+		for fieldName, vals := range rc.embeddedRecordsData(fMap) {
+			if _, ok := embedded[fieldName]; !ok {
+				embedded[fieldName] = &embeddedRecords{model: vals.model}
+			}
+			embedded[fieldName].indexes = append(embedded[fieldName].indexes, i)
+			embedded[fieldName].values = append(embedded[fieldName].values, vals.values)
+		}
 	}
-	embeddedData := make(map[string]modelAndValues)
+	for fieldName, recs := range embedded {
+		// We do not call "createMulti" directly to have the caller set in the callstack for permissions
+		res := rc.env.Pool(recs.model).Call("CreateMulti", recs.values)
+		if resRS, ok := res.(RecordSet); ok {
+			for j, id := range resRS.Ids() {
+				fMaps[recs.indexes[j]][fieldName] = id
+			}
+		}
+	}
+}
+
+// embeddedData holds the values of an embedded record to create
+type embeddedData struct {
+	model  string
+	values FieldMap
+}
+
+// embeddedRecordsData returns the values of the records that are embedded in
+// the record with the given fMap and that do not exist yet, by embedded field name.
+func (rc RecordCollection) embeddedRecordsData(fMap FieldMap) map[string]embeddedData {
+	embeddedRecs := make(map[string]embeddedData)
 	// 1. We create entries in our map for each embedded field if they don't already have an id
 	for fName, fi := range rc.model.fields.registryByName {
 		if !fi.embed {
@@ -118,7 +271,7 @@ func (rc RecordCollection) createEmbeddedRecords(fMap FieldMap) FieldMap {
 		if id, ok := fMap[fi.json].(int64); ok && id != int64(0) {
 			continue
 		}
-		embeddedData[fName] = modelAndValues{
+		embeddedRecs[fName] = embeddedData{
 			model:  fi.relatedModelName,
 			values: make(FieldMap),
 		}
@@ -133,21 +286,13 @@ func (rc RecordCollection) createEmbeddedRecords(fMap FieldMap) FieldMap {
 		if len(exprs) != 2 {
 			continue
 		}
-		fm, ok := embeddedData[exprs[0]]
+		fm, ok := embeddedRecs[exprs[0]]
 		if !ok {
 			continue
 		}
 		fm.values[exprs[1]] = value
 	}
-	// 3. We create the embedded records
-	for fieldName, vals := range embeddedData {
-		// We do not call "create" directly to have the caller set in the callstack for permissions
-		res := rc.env.Pool(vals.model).Call("Create", vals.values)
-		if resRS, ok := res.(RecordSet); ok {
-			fMap[fieldName] = resRS.Ids()[0]
-		}
-	}
-	return fMap
+	return embeddedRecs
 }
 
 // applyDefaults adds the default value to the given fMap values which
@@ -199,17 +344,23 @@ func (rc RecordCollection) addAccessFieldsCreateData(fMap *FieldMap) {
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Call("Write")
 func (rc RecordCollection) update(data FieldMapper, fieldsToUnset ...FieldNamer) bool {
-	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Write)
+	// We fetch before applying rules so that doUpdate knows which records should be updated
+	rSet := rc.Fetch().addRecordRuleConditions(rc.env.uid, security.Write)
 	fMap := data.FieldMap(fieldsToUnset...)
 	rSet.addAccessFieldsUpdateData(&fMap)
 	rSet.model.convertValuesToFieldType(&fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePK()
+	rSet.model.removeParentPath(&fMap)
 	fMap = rSet.processInverseMethods(fMap)
 	storedFieldMap := filterMapOnStoredFields(rSet.model, fMap)
 	rSet.doUpdate(storedFieldMap)
 	// Let's fetch once for all
 	rSet = rSet.Fetch()
+	// move the materialized paths of the hierarchy
+	if _, ok := storedFieldMap.Get("Parent", rSet.model); ok {
+		rSet.updateParentPaths()
+	}
 	// write reverse relation fields
 	rSet.updateRelationFields(fMap)
 	// write related fields
@@ -244,15 +395,45 @@ func (rc RecordCollection) doUpdate(fMap FieldMap) {
 	fMap = filterMapOnAuthorizedFields(rc.model, fMap, rc.env.uid, security.Write)
 	// update DB
 	if len(fMap) > 0 {
+		fields := rc.updatedFieldsJSON(fMap)
+		hooks := Webhooks.triggered(rc.env, rc.model.name, WebhookWrite, fields)
+		var ids []int64
+		if len(hooks) > 0 || Bus.publishing() {
+			// We fetch ids before the update since it may change the query result
+			ids = rc.Ids()
+		}
 		sql, args := rc.query.updateQuery(fMap)
 		res := rc.env.cr.Execute(sql, args...)
-		if num, _ := res.RowsAffected(); num == 0 {
-			log.Panic("Trying to update an empty RecordSet", "model", rc.ModelName(), "values", fMap)
-		}
+		num, _ := res.RowsAffected()
+		rc.checkUpdatedRecords(num, fMap)
+		rc.enqueueWebhooks(hooks, WebhookWrite, ids, fields)
+		rc.publishChange(WebhookWrite, ids, fields)
 	}
 	rc.checkConstraints()
 }
 
+// checkUpdatedRecords panics if not all the records of this RecordCollection
+// have been updated, num being the number of updated rows. It raises a
+// MissingError if some records do not exist anymore and an AccessError if they
+// have been filtered out by record rules. Empty RecordCollections are not checked.
+func (rc RecordCollection) checkUpdatedRecords(num int64, fMap FieldMap) {
+	ids := make(map[int64]bool)
+	for _, id := range rc.ids {
+		ids[id] = true
+	}
+	if num >= int64(len(ids)) {
+		return
+	}
+	var existingIds []int64
+	rc.env.cr.Select(&existingIds, fmt.Sprintf(`SELECT id FROM %s WHERE id IN (?)`, rc.model.tableName), rc.ids)
+	if len(existingIds) < len(ids) {
+		panic(NewMissingError(rc.T("Records of %s do not exist or have been deleted", rc.ModelName()),
+			"model", rc.ModelName(), "ids", rc.ids, "existing", existingIds, "values", fMap))
+	}
+	panic(NewAccessError(rc.T("You are not allowed to modify these records of %s", rc.ModelName()),
+		"model", rc.ModelName(), "ids", rc.ids, "values", fMap))
+}
+
 // processInverseMethods executes inverse methods of fields in the given
 // FieldMap if it exists. It returns a copy of the FieldMap without the
 // fields that had their inverse method executed.
@@ -359,20 +540,23 @@ func (rc RecordCollection) updateRelatedFields(fMap FieldMap) {
 	}
 }
 
-// substituteSQLErrorMessage changes the message from the given recover data
-// if it comes from the database with the message defined in this model
+// substituteSQLErrorMessage returns a ValidationError instead of the given
+// recover data if it is a constraint violation error from the database.
+// The message of the ValidationError is the one defined in this model for
+// the constraint if any.
 func (rc RecordCollection) substituteSQLErrorMessage(r interface{}) interface{} {
 	err, ok := r.(error)
-	if !ok {
+	if !ok || !adapters[db.DriverName()].isConstraintError(err) {
 		return r
 	}
 	for constraintName, constraint := range rc.model.sqlConstraints {
 		if strings.Contains(err.Error(), constraintName) {
-			res := adapters[db.DriverName()].substituteErrorMessage(err, constraint.errorString)
-			return res
+			return NewValidationError(rc.T(constraint.errorString), "model", rc.ModelName(), "error", err)
 		}
 	}
-	return r
+	// The driver error is only kept in the debug context since it may disclose the database structure
+	return NewValidationError(rc.T("The operation cannot be completed because it violates a database constraint."),
+		"model", rc.ModelName(), "error", err)
 }
 
 // unlink deletes the database record of this RecordSet and returns the number of deleted rows.
@@ -381,9 +565,19 @@ func (rc RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 func (rc RecordCollection) unlink() int64 {
 	rc.checkExecutionPermission(rc.model.methods.MustGet("Unlink"))
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Unlink)
+	// Children of deleted records are orphaned and must have their ParentPath updated
+	children := rSet.parentPathChildren()
+	hooks := Webhooks.triggered(rSet.env, rSet.model.name, WebhookUnlink, nil)
+	var ids []int64
+	if len(hooks) > 0 || Bus.publishing() {
+		ids = rSet.Ids()
+	}
 	sql, args := rSet.query.deleteQuery()
 	res := rSet.env.cr.Execute(sql, args...)
 	num, _ := res.RowsAffected()
+	children.updateParentPaths()
+	rSet.enqueueWebhooks(hooks, WebhookUnlink, ids, nil)
+	rSet.publishChange(WebhookUnlink, ids, nil)
 	return num
 }
 
@@ -410,23 +604,61 @@ func (rc RecordCollection) Offset(offset int) RecordCollection {
 }
 
 // OrderBy returns a new RecordSet ordered by the given ORDER BY expressions
+//
+// Each expression is of the form 'Field [asc|desc] [nulls first|last]', where
+// Field is a field path such as "Profile.Age". Grouped queries can also be
+// ordered by aggregates (e.g. "Nums:avg desc") and date granularities
+// (e.g. "CreateDate:month"), and fields that are not grouped are ordered
+// by their group operator aggregate.
+//
+// RecordSets without ORDER BY expressions are ordered by the
+// default order of their model (see Model.SetDefaultOrder).
 func (rc RecordCollection) OrderBy(exprs ...string) RecordCollection {
+	for _, expr := range exprs {
+		parseOrderExpr(rc.model, expr)
+	}
 	rc.query = rc.query.clone()
 	rc.query.orders = append(rc.query.orders, exprs...)
 	return rc
 }
 
 // GroupBy returns a new RecordSet grouped with the given GROUP BY expressions
+//
+// Date and DateTime fields can be grouped by period by suffixing them with
+// GroupSep and one of the GroupBy granularities, e.g. "CreateDate:month".
+// DateTime fields are then truncated in the timezone given by the 'tz' key of
+// the context, and the aggregate rows values are given with the field JSON name
+// and the granularity as key, e.g. "create_date:month".
 func (rc RecordCollection) GroupBy(fields ...FieldNamer) RecordCollection {
 	rc.query = rc.query.clone()
 	exprs := make([]string, len(fields))
 	for i, f := range fields {
 		exprs[i] = string(f.FieldName())
+		path, granularity := splitGroupExpr(exprs[i])
+		if granularity == "" {
+			continue
+		}
+		fi := rc.model.getRelatedFieldInfo(path)
+		if !groupGranularities[granularity] || (fi.fieldType != fieldtype.Date && fi.fieldType != fieldtype.DateTime) {
+			log.Panic("Invalid date granularity in GROUP BY expression", "model", rc.model, "expr", exprs[i])
+		}
 	}
 	rc.query.groups = append(rc.query.groups, exprs...)
 	return rc
 }
 
+// Having returns a new RecordSet grouped query whose aggregate rows are
+// filtered with the given condition.
+//
+// The fields of the condition are either grouped fields or aggregate
+// expressions as in Aggregates, e.g. Field("Nums:sum").Greater(10).
+func (rc RecordCollection) Having(cond *Condition) RecordCollection {
+	checkHavingCondition(rc.model, cond)
+	rc.query = rc.query.clone()
+	rc.query.having = rc.query.having.AndCond(cond)
+	return rc
+}
+
 // Fetch query the database with the current filter and returns a RecordSet
 // with the queries ids. Fetch is lazy and only return ids. Use Load() instead
 // if you want to fetch all fields.
@@ -645,12 +877,30 @@ func (rc RecordCollection) All(structSlicePtr interface{}) {
 }
 
 // Aggregates returns the result of this RecordCollection query, which must by a grouped query.
+//
+// Integer and Float fields are aggregated with their GroupOperator. Other
+// aggregates can be requested by suffixing a field with GroupSep and one
+// of the Aggregate functions, e.g. "Nums:avg" or "ID:array_agg". Their values
+// are given in the aggregate rows with the field JSON name and the function
+// as key, e.g. "nums:avg".
 func (rc RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregateRow {
 	if len(rc.query.groups) == 0 {
 		log.Panic("Trying to get aggregates of a non-grouped query", "model", rc.model)
 	}
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Read)
-	fields := filterOnAuthorizedFields(rSet.model, rSet.env.uid, convertToStringSlice(fieldNames), security.Read)
+	var fieldsList, aggregates []string
+	for _, fName := range convertToStringSlice(fieldNames) {
+		path, fnct := splitGroupExpr(fName)
+		if fnct == "" {
+			fieldsList = append(fieldsList, fName)
+			continue
+		}
+		checkAggregateExpr(rSet.model, fName)
+		if checkFieldPermission(rSet.model.getRelatedFieldInfo(path), rSet.env.uid, security.Read) {
+			aggregates = append(aggregates, fName)
+		}
+	}
+	fields := filterOnAuthorizedFields(rSet.model, rSet.env.uid, fieldsList, security.Read)
 	subFields, rSet := rSet.substituteRelatedFields(fields)
 	dbFields := filterOnDBFields(rSet.model, subFields, true)
 
@@ -658,7 +908,14 @@ func (rc RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregate
 		rSet = rSet.OrderBy(rSet.query.groups...)
 	}
 	fieldsOperatorMap := rSet.fieldsGroupOperators(dbFields)
-	sql, args := rSet.query.selectGroupQuery(fieldsOperatorMap)
+	sql, args := rSet.query.selectGroupQuery(fieldsOperatorMap, aggregates)
+	// Aggregates selected only for the ORDER BY clause are not returned
+	orderAggs := rSet.query.orderAggregates()
+	requested := make(map[string]bool)
+	for _, agg := range aggregates {
+		requested[agg] = true
+	}
+	loc := rSet.query.timezone()
 	var res []GroupAggregateRow
 	rows := dbQuery(rSet.env.cr.tx, sql, args...)
 	defer rows.Close()
@@ -671,10 +928,17 @@ func (rc RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregate
 		}
 		cnt := vals["__count"].(int64)
 		delete(vals, "__count")
+		convertGroupDates(rSet.model, rc.query.groups, vals, loc)
+		convertAggregates(rSet.model, aggregates, vals)
+		for _, agg := range orderAggs {
+			if !requested[agg] {
+				delete(vals, groupAlias(rSet.model, agg))
+			}
+		}
 		line := GroupAggregateRow{
 			Values:    vals,
 			Count:     int(cnt),
-			Condition: getGroupCondition(rc.query.groups, vals, rc.query.cond),
+			Condition: getGroupCondition(rSet.model, rc.query.groups, vals, rc.query.cond),
 		}
 		res = append(res, line)
 	}
@@ -687,7 +951,9 @@ func (rc RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregate
 func (rc RecordCollection) fieldsGroupOperators(fields []string) map[string]string {
 	groups := make(map[string]bool)
 	for _, g := range rc.query.groups {
-		groups[rc.model.JSONizeFieldName(g)] = true
+		if path, granularity := splitGroupExpr(g); granularity == "" {
+			groups[rc.model.JSONizeFieldName(path)] = true
+		}
 	}
 	res := make(map[string]string)
 	for _, dbf := range fields {
@@ -716,6 +982,82 @@ func (rc RecordCollection) Records() []RecordCollection {
 	return res
 }
 
+// Iterate calls fnct with successive batches of at most batchSize records
+// of this RecordCollection, until all records have been iterated over or
+// fnct returns false.
+//
+// Unlike Records, Iterate does not load the whole RecordCollection at once.
+// Each batch is searched and loaded with the given fields (all stored fields
+// if none are given) in its own query, and its records are evicted from the
+// cache after fnct returns.
+//
+// RecordCollections that are not explicitly ordered and whose model has no
+// default order are iterated by ascending ID with keyset batches. Otherwise,
+// the ordered ids of the RecordCollection are fetched in a single query
+// before loading the batches.
+func (rc RecordCollection) Iterate(batchSize int, fnct func(RecordCollection) bool, fields ...FieldNamer) {
+	if batchSize <= 0 {
+		log.Panic("Batch size must be positive", "model", rc.model, "batchSize", batchSize)
+	}
+	fNames := convertToStringSlice(fields)
+	next := rc.iterateBatchFunc(batchSize, fNames)
+	for {
+		batch := next()
+		if batch.IsEmpty() {
+			return
+		}
+		cont := fnct(batch)
+		for _, id := range batch.ids {
+			rc.env.cache.invalidateRecord(rc.model, id)
+		}
+		if !cont {
+			return
+		}
+	}
+}
+
+// iterateBatchFunc returns a function that returns the next loaded batch of
+// at most batchSize records of this RecordCollection each time it is called.
+// The returned RecordCollection is empty when all records have been returned.
+func (rc RecordCollection) iterateBatchFunc(batchSize int, fields []string) func() RecordCollection {
+	empty := newRecordCollection(rc.Env(), rc.ModelName()).withIds([]int64{})
+	switch {
+	case rc.fetched:
+	case rc.query.isEmpty():
+		// We do not load empty queries to keep empty record sets empty
+		return func() RecordCollection {
+			return empty
+		}
+	case len(rc.query.orders) == 0 && len(rc.model.defaultOrder) == 0 && rc.query.limit == 0 && rc.query.offset == 0:
+		var lastID int64
+		return func() RecordCollection {
+			batch := rc.Search(rc.Model().Field("ID").Greater(lastID)).OrderBy("ID").Limit(batchSize).Load(fields...)
+			if len(batch.ids) > 0 {
+				lastID = batch.ids[len(batch.ids)-1]
+			}
+			return batch
+		}
+	default:
+		// Fetch the ordered ids once, so that batches are not shifted
+		// by changes made to the records while iterating.
+		rc = rc.Fetch()
+	}
+	// We have the ids, so we only need to load them
+	var start int
+	return func() RecordCollection {
+		if start >= len(rc.ids) {
+			return empty
+		}
+		end := start + batchSize
+		if end > len(rc.ids) {
+			end = len(rc.ids)
+		}
+		batch := rc.Limit(0).Offset(0).withIds(rc.ids[start:end]).Load(fields...)
+		start = end
+		return batch
+	}
+}
+
 // EnsureOne panics if rc is not a singleton
 func (rc RecordCollection) EnsureOne() {
 	if rc.Len() != 1 {
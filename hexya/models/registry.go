@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -62,6 +63,20 @@ func (mc *modelCollection) MustGet(nameOrJSON string) *Model {
 	return mi
 }
 
+// All returns all the models of the registry, sorted by name
+func (mc *modelCollection) All() []*Model {
+	names := make([]string, 0, len(mc.registryByName))
+	for name := range mc.registryByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]*Model, len(names))
+	for i, name := range names {
+		res[i] = mc.registryByName[name]
+	}
+	return res
+}
+
 // GetSequence the given Sequence by name or by db name
 func (mc *modelCollection) GetSequence(nameOrJSON string) (s *Sequence, ok bool) {
 	s, ok = mc.sequences[nameOrJSON]
@@ -114,6 +129,7 @@ type Model struct {
 	mixins         []*Model
 	sqlConstraints map[string]sqlConstraint
 	sqlErrors      map[string]string
+	defaultOrder   []string
 }
 
 // An sqlConstraint holds the data needed to create a table constraint in the database
@@ -191,9 +207,17 @@ func (m *Model) scanToFieldMap(r sqlx.ColScanner, dest *FieldMap) error {
 	}
 
 	// Step 2: We populate our FieldMap with these values
+	// and decrypt values of encrypted fields
 	for i, dbValue := range dbValues {
 		colName := strings.Replace(columns[i], sqlSep, ExprSep, -1)
+		if strings.Contains(colName, GroupSep) {
+			// Selected expressions such as text search ranks are not fields
+			continue
+		}
 		dbVal := reflect.ValueOf(dbValue).Elem().Interface()
+		if fi := m.getRelatedFieldInfo(colName); fi.encrypted {
+			dbVal = decryptFieldValue(fi, dbVal)
+		}
 		(*dest)[colName] = dbVal
 	}
 
@@ -342,6 +366,17 @@ func (m *Model) hasParentField() bool {
 	return parentExists
 }
 
+// Name returns the name of this model
+func (m *Model) Name() string {
+	return m.name
+}
+
+// IsMixin returns true if this is a mixin model.
+// Mixin models have no table and cannot be queried.
+func (m *Model) IsMixin() bool {
+	return m.isMixin()
+}
+
 // Fields returns the fields collection of this model
 func (m *Model) Fields() *FieldsCollection {
 	return m.fields
@@ -386,6 +421,11 @@ func (m *Model) Create(env Environment, data interface{}) RecordCollection {
 	return env.Pool(m.name).Call("Create", data).(RecordSet).Collection()
 }
 
+// CreateMulti creates new records in this model with the given data.
+func (m *Model) CreateMulti(env Environment, data []FieldMapper) RecordCollection {
+	return env.Pool(m.name).Call("CreateMulti", data).(RecordSet).Collection()
+}
+
 // Search searches the database and returns records matching the given condition.
 func (m *Model) Search(env Environment, cond *Condition) RecordCollection {
 	return env.Pool(m.name).Call("Search", cond).(RecordSet).Collection()
@@ -417,6 +457,27 @@ func (m *Model) RemoveSQLConstraint(name string) {
 	delete(m.sqlConstraints, fmt.Sprintf("%s_mancon", name))
 }
 
+// SetDefaultOrder sets the ORDER BY expressions of the RecordSets of this
+// model that are not explicitly ordered (see RecordCollection.OrderBy).
+// RecordSets are ordered by ID if the model has no default order.
+//
+// Models have no options struct, so the default order is set with this
+// method instead of an Order option. It panics if the syntax of an expression
+// is invalid. Since the fields of the model may not be all declared yet,
+// field paths are only checked when bootstrapping models.
+func (m *Model) SetDefaultOrder(exprs ...string) *Model {
+	for _, expr := range exprs {
+		splitOrderExpr(m, expr)
+	}
+	m.defaultOrder = exprs
+	return m
+}
+
+// DefaultOrder returns the default ORDER BY expressions of this model.
+func (m *Model) DefaultOrder() []string {
+	return m.defaultOrder
+}
+
 // Underlying returns the underlying Model data object, i.e. itself
 func (m *Model) Underlying() *Model {
 	return m
@@ -0,0 +1,234 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeys is the API keys registry of the application
+var APIKeys *APIKeyRegistry
+
+var (
+	// ErrInvalidAPIKey is returned when an API key is malformed, unknown or wrong
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrExpiredAPIKey is returned when an API key has expired
+	ErrExpiredAPIKey = errors.New("expired API key")
+)
+
+// An APIKey allows a machine client to authenticate as a user
+// without a password.
+//
+// Only the hash of the key secret is kept, so that the full key
+// can only be read once, when it is created.
+type APIKey struct {
+	// ID is the public part of the key, used to retrieve it
+	ID string
+	// UID is the id of the user this key authenticates
+	UID int64
+	// Name is a free description of the key
+	Name string
+	// Hash is the hex encoded SHA-256 hash of the key secret
+	Hash string
+	// Scopes restrict what the key can be used for.
+	// A key without scope cannot access scope restricted routes.
+	Scopes []string
+	// ExpiresAt is the expiry time of the key. The zero value means
+	// that the key never expires.
+	ExpiresAt time.Time
+}
+
+// HasScope returns true if this key has the given scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired returns true if this key has expired at the given time
+func (k APIKey) IsExpired(at time.Time) bool {
+	return !k.ExpiresAt.IsZero() && at.After(k.ExpiresAt)
+}
+
+// An APIKeyStore persists API keys.
+//
+// Modules can provide their own store, typically backed by a model,
+// with APIKeyRegistry.SetStore.
+type APIKeyStore interface {
+	// Get returns the key with the given ID. The second returned
+	// value is false if there is no such key.
+	Get(id string) (APIKey, bool)
+	// Save creates or updates the given key
+	Save(key APIKey)
+	// Delete removes the key with the given ID
+	Delete(id string)
+	// UserKeys returns all the keys of the given user
+	UserKeys(uid int64) []APIKey
+}
+
+// An APIKeyRegistry creates and checks API keys
+type APIKeyRegistry struct {
+	sync.RWMutex
+	store APIKeyStore
+}
+
+// SetStore sets the store in which keys of this registry are persisted
+func (ar *APIKeyRegistry) SetStore(store APIKeyStore) {
+	ar.Lock()
+	defer ar.Unlock()
+	ar.store = store
+}
+
+// getStore returns the current store of this registry
+func (ar *APIKeyRegistry) getStore() APIKeyStore {
+	ar.RLock()
+	defer ar.RUnlock()
+	return ar.store
+}
+
+// NewKey creates a new API key for the given user with the given scopes.
+// If validity is not zero, the key will expire after this duration.
+//
+// It returns the token to give to the client in the form "<id>.<secret>"
+// and the APIKey as stored. The token cannot be retrieved afterwards.
+func (ar *APIKeyRegistry) NewKey(uid int64, name string, validity time.Duration, scopes ...string) (string, APIKey) {
+	id := randomHexString(8)
+	secret := randomHexString(32)
+	key := APIKey{
+		ID:     id,
+		UID:    uid,
+		Name:   name,
+		Hash:   hashSecret(secret),
+		Scopes: scopes,
+	}
+	if validity != 0 {
+		key.ExpiresAt = time.Now().Add(validity)
+	}
+	ar.getStore().Save(key)
+	return fmt.Sprintf("%s.%s", id, secret), key
+}
+
+// RevokeKey deletes the key with the given ID
+func (ar *APIKeyRegistry) RevokeKey(id string) {
+	ar.getStore().Delete(id)
+}
+
+// UserKeys returns the keys of the given user
+func (ar *APIKeyRegistry) UserKeys(uid int64) []APIKey {
+	return ar.getStore().UserKeys(uid)
+}
+
+// Authenticate checks the given token and returns the matching APIKey.
+//
+// It returns ErrInvalidAPIKey if the token is malformed, unknown or does not
+// match the stored hash, and ErrExpiredAPIKey if the key has expired.
+func (ar *APIKeyRegistry) Authenticate(token string) (APIKey, error) {
+	tokenParts := strings.SplitN(token, ".", 2)
+	if len(tokenParts) != 2 {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	key, ok := ar.getStore().Get(tokenParts[0])
+	if !ok {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(tokenParts[1])), []byte(key.Hash)) != 1 {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	if key.IsExpired(time.Now()) {
+		return APIKey{}, ErrExpiredAPIKey
+	}
+	return key, nil
+}
+
+// NewAPIKeyRegistry returns a new APIKeyRegistry with an in-memory store
+func NewAPIKeyRegistry() *APIKeyRegistry {
+	return &APIKeyRegistry{
+		store: newMemoryAPIKeyStore(),
+	}
+}
+
+// hashSecret returns the hex encoded hash of the given secret.
+//
+// Secrets are random strings generated by the application, so that
+// a single SHA-256 round is enough.
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// randomBytes returns n random bytes.
+// It panics if the system random generator fails.
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic("Unable to generate random bytes", "error", err)
+	}
+	return b
+}
+
+// randomHexString returns a hex encoded string of n random bytes.
+func randomHexString(n int) string {
+	return hex.EncodeToString(randomBytes(n))
+}
+
+// A memoryAPIKeyStore is an APIKeyStore that keeps keys in memory
+type memoryAPIKeyStore struct {
+	sync.RWMutex
+	keys map[string]APIKey
+}
+
+// Get returns the key with the given ID.
+func (ms *memoryAPIKeyStore) Get(id string) (APIKey, bool) {
+	ms.RLock()
+	defer ms.RUnlock()
+	key, ok := ms.keys[id]
+	return key, ok
+}
+
+// Save creates or updates the given key
+func (ms *memoryAPIKeyStore) Save(key APIKey) {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.keys[key.ID] = key
+}
+
+// Delete removes the key with the given ID
+func (ms *memoryAPIKeyStore) Delete(id string) {
+	ms.Lock()
+	defer ms.Unlock()
+	delete(ms.keys, id)
+}
+
+// UserKeys returns all the keys of the given user
+func (ms *memoryAPIKeyStore) UserKeys(uid int64) []APIKey {
+	ms.RLock()
+	defer ms.RUnlock()
+	var res []APIKey
+	for _, key := range ms.keys {
+		if key.UID == uid {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// newMemoryAPIKeyStore returns a new empty memoryAPIKeyStore
+func newMemoryAPIKeyStore() *memoryAPIKeyStore {
+	return &memoryAPIKeyStore{
+		keys: make(map[string]APIKey),
+	}
+}
+
+var _ APIKeyStore = new(memoryAPIKeyStore)
@@ -0,0 +1,253 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"gopkg.in/ldap.v2"
+)
+
+// An LDAPConfig holds the parameters to connect to an LDAP directory
+type LDAPConfig struct {
+	// Host and Port of the LDAP server
+	Host string
+	Port int
+	// UseTLS makes the connection through LDAPS
+	UseTLS bool
+	// InsecureSkipVerify disables server certificate checks when UseTLS is set
+	InsecureSkipVerify bool
+	// BindDN and BindPassword are the credentials used to search the
+	// directory. If BindDN is empty, search is made anonymously.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the root of the directory subtree where users are searched
+	BaseDN string
+	// Filter is the LDAP filter to find a user. It must contain exactly
+	// one '%s' which is replaced by the escaped login, e.g. "(uid=%s)".
+	Filter string
+	// Attributes is the list of attributes to fetch for the user entry.
+	Attributes []string
+	// AutoCreate creates the Hexya user when it does not exist yet.
+	AutoCreate bool
+	// TemplateUID is the id of the user that is copied for new users.
+	TemplateUID int64
+	// DefaultGroups are given to newly created users.
+	DefaultGroups []*Group
+}
+
+// An LDAPEntry is a user entry found in an LDAP directory
+type LDAPEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// GetAttribute returns the first value of the given attribute
+// or the empty string if the attribute is not set.
+func (e LDAPEntry) GetAttribute(name string) string {
+	values := e.Attributes[name]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// An LDAPConn is a connection to an LDAP directory
+type LDAPConn interface {
+	// Bind authenticates with the given DN and password.
+	// It must return an InvalidCredentialsError if the credentials are wrong.
+	Bind(dn, password string) error
+	// Search returns the entries under baseDN matching filter
+	Search(baseDN, filter string, attributes []string) ([]LDAPEntry, error)
+	// Close the connection
+	Close()
+}
+
+// An LDAPDialer opens a new LDAPConn to the server defined in the given LDAPConfig
+type LDAPDialer func(LDAPConfig) (LDAPConn, error)
+
+// An LDAPUserMapper maps LDAP entries to Hexya users.
+//
+// Since users are defined in modules, the module defining
+// the User model is expected to provide an LDAPUserMapper.
+type LDAPUserMapper interface {
+	// FindUser returns the id of the Hexya user matching the given login and
+	// LDAP entry. The second returned value is false if no such user exists.
+	FindUser(login string, entry LDAPEntry) (int64, bool)
+	// CreateUser creates a new Hexya user for the given login and LDAP
+	// entry by copying the user with templateUID. It returns the new user id.
+	CreateUser(login string, entry LDAPEntry, templateUID int64) int64
+}
+
+// An LDAPBackend is an AuthBackend that authenticates users
+// against an LDAP directory.
+type LDAPBackend struct {
+	config LDAPConfig
+	mapper LDAPUserMapper
+	dialer LDAPDialer
+}
+
+// NewLDAPBackend returns a new LDAPBackend for the given configuration.
+// Directory users are mapped to Hexya users with the given mapper.
+func NewLDAPBackend(config LDAPConfig, mapper LDAPUserMapper) *LDAPBackend {
+	if config.Filter == "" {
+		config.Filter = "(uid=%s)"
+	}
+	return &LDAPBackend{
+		config: config,
+		mapper: mapper,
+		dialer: dialLDAP,
+	}
+}
+
+// SetDialer sets the function used by this backend to connect to the
+// LDAP server. It is mainly used to connect to a stand-in server in tests.
+func (lb *LDAPBackend) SetDialer(dialer LDAPDialer) {
+	lb.dialer = dialer
+}
+
+// Authenticate the user defined by login and secret against the LDAP directory.
+//
+// It returns a UserNotFoundError if the login does not match exactly one entry
+// in the directory or if the directory cannot be reached, and an InvalidCredentialsError
+// if the directory refuses to bind with the given secret.
+func (lb *LDAPBackend) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	conn, err := lb.dialer(lb.config)
+	if err != nil {
+		log.Warn("Unable to connect to LDAP server", "host", lb.config.Host, "error", err)
+		return 0, UserNotFoundError(login)
+	}
+	defer conn.Close()
+	if lb.config.BindDN != "" {
+		if err = conn.Bind(lb.config.BindDN, lb.config.BindPassword); err != nil {
+			log.Warn("Unable to bind to LDAP server", "host", lb.config.Host, "bindDN", lb.config.BindDN, "error", err)
+			return 0, UserNotFoundError(login)
+		}
+	}
+	filter := fmt.Sprintf(lb.config.Filter, escapeLDAPFilter(login))
+	entries, err := conn.Search(lb.config.BaseDN, filter, lb.config.Attributes)
+	if err != nil {
+		log.Warn("Error while searching LDAP directory", "filter", filter, "error", err)
+		return 0, UserNotFoundError(login)
+	}
+	if len(entries) != 1 {
+		if len(entries) > 1 {
+			log.Warn("Several LDAP entries match login", "login", login, "filter", filter)
+		}
+		return 0, UserNotFoundError(login)
+	}
+	entry := entries[0]
+	// An empty password would make an unauthenticated bind that succeeds
+	if secret == "" {
+		return 0, InvalidCredentialsError(login)
+	}
+	if err = conn.Bind(entry.DN, secret); err != nil {
+		if _, ok := err.(InvalidCredentialsError); !ok {
+			log.Warn("Error while binding LDAP user", "dn", entry.DN, "error", err)
+		}
+		return 0, InvalidCredentialsError(login)
+	}
+	uid, ok := lb.mapper.FindUser(login, entry)
+	if ok {
+		return uid, nil
+	}
+	if !lb.config.AutoCreate {
+		return 0, UserNotFoundError(login)
+	}
+	uid = lb.mapper.CreateUser(login, entry, lb.config.TemplateUID)
+	for _, group := range lb.config.DefaultGroups {
+		Registry.AddMembership(uid, group)
+	}
+	log.Info("Created user from LDAP directory", "login", login, "uid", uid)
+	return uid, nil
+}
+
+var _ AuthBackend = new(LDAPBackend)
+
+// escapeLDAPFilter escapes the special characters of the given
+// string so that it can be inserted in an LDAP filter.
+func escapeLDAPFilter(value string) string {
+	var res bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '*', c == '(', c == ')', c == '\\', c == 0, c > 0x7f:
+			res.WriteString(fmt.Sprintf("\\%02x", c))
+		default:
+			res.WriteByte(c)
+		}
+	}
+	return res.String()
+}
+
+// ldapConn is the LDAPConn implementation for real LDAP servers
+type ldapConn struct {
+	conn *ldap.Conn
+}
+
+// Bind authenticates with the given DN and password
+func (lc *ldapConn) Bind(dn, password string) error {
+	err := lc.conn.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return InvalidCredentialsError(dn)
+	}
+	return err
+}
+
+// Search returns the entries under baseDN matching filter
+func (lc *ldapConn) Search(baseDN, filter string, attributes []string) ([]LDAPEntry, error) {
+	req := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, filter, attributes, nil)
+	sr, err := lc.conn.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]LDAPEntry, len(sr.Entries))
+	for i, e := range sr.Entries {
+		res[i] = LDAPEntry{
+			DN:         e.DN,
+			Attributes: make(map[string][]string),
+		}
+		for _, attr := range e.Attributes {
+			res[i].Attributes[attr.Name] = attr.Values
+		}
+	}
+	return res, nil
+}
+
+// Close the connection
+func (lc *ldapConn) Close() {
+	lc.conn.Close()
+}
+
+// dialLDAP opens a connection to the LDAP server defined in config
+func dialLDAP(config LDAPConfig) (LDAPConn, error) {
+	port := config.Port
+	if port == 0 {
+		port = 389
+		if config.UseTLS {
+			port = 636
+		}
+	}
+	addr := fmt.Sprintf("%s:%d", config.Host, port)
+	var (
+		conn *ldap.Conn
+		err  error
+	)
+	if config.UseTLS {
+		conn, err = ldap.DialTLS("tcp", addr, &tls.Config{
+			ServerName:         config.Host,
+			InsecureSkipVerify: config.InsecureSkipVerify,
+		})
+	} else {
+		conn, err = ldap.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ldapConn{conn: conn}, nil
+}
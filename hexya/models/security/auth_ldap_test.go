@@ -0,0 +1,168 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A testLDAPServer is an in-process LDAP stand-in
+type testLDAPServer struct {
+	passwords map[string]string
+	entries   []LDAPEntry
+	down      bool
+}
+
+// A testLDAPConn is a connection to a testLDAPServer
+type testLDAPConn struct {
+	server *testLDAPServer
+}
+
+func (tc *testLDAPConn) Bind(dn, password string) error {
+	if pwd, ok := tc.server.passwords[dn]; ok && pwd == password {
+		return nil
+	}
+	return InvalidCredentialsError(dn)
+}
+
+func (tc *testLDAPConn) Search(baseDN, filter string, attributes []string) ([]LDAPEntry, error) {
+	var res []LDAPEntry
+	for _, entry := range tc.server.entries {
+		if !strings.HasSuffix(entry.DN, baseDN) {
+			continue
+		}
+		if fmt.Sprintf("(uid=%s)", escapeLDAPFilter(entry.GetAttribute("uid"))) == filter {
+			res = append(res, entry)
+		}
+	}
+	return res, nil
+}
+
+func (tc *testLDAPConn) Close() {}
+
+func (ts *testLDAPServer) dial(LDAPConfig) (LDAPConn, error) {
+	if ts.down {
+		return nil, errors.New("connection refused")
+	}
+	return &testLDAPConn{server: ts}, nil
+}
+
+// A testLDAPUserMapper maps LDAP logins to uids
+type testLDAPUserMapper struct {
+	users     map[string]int64
+	templates map[int64]int64
+	nextID    int64
+}
+
+func (tm *testLDAPUserMapper) FindUser(login string, entry LDAPEntry) (int64, bool) {
+	uid, ok := tm.users[login]
+	return uid, ok
+}
+
+func (tm *testLDAPUserMapper) CreateUser(login string, entry LDAPEntry, templateUID int64) int64 {
+	tm.nextID++
+	tm.users[login] = tm.nextID
+	tm.templates[tm.nextID] = templateUID
+	return tm.nextID
+}
+
+func TestLDAPBackend(t *testing.T) {
+	Convey("Testing LDAP authentication backend", t, func() {
+		server := &testLDAPServer{
+			passwords: map[string]string{
+				"cn=admin,dc=example,dc=com":            "adminpwd",
+				"uid=john,ou=people,dc=example,dc=com":  "johnpwd",
+				"uid=jane,ou=people,dc=example,dc=com":  "janepwd",
+				"uid=j*ck,ou=people,dc=example,dc=com":  "jackpwd",
+				"uid=other,ou=people,dc=example,dc=org": "otherpwd",
+			},
+			entries: []LDAPEntry{
+				{DN: "uid=john,ou=people,dc=example,dc=com", Attributes: map[string][]string{"uid": {"john"}}},
+				{DN: "uid=jane,ou=people,dc=example,dc=com", Attributes: map[string][]string{"uid": {"jane"}}},
+				{DN: "uid=j*ck,ou=people,dc=example,dc=com", Attributes: map[string][]string{"uid": {"j*ck"}}},
+				{DN: "uid=other,ou=people,dc=example,dc=org", Attributes: map[string][]string{"uid": {"other"}}},
+			},
+		}
+		mapper := &testLDAPUserMapper{
+			users:     map[string]int64{"john": 42},
+			templates: make(map[int64]int64),
+			nextID:    100,
+		}
+		ldapGroup := Registry.NewGroup("ldap_users_test", "LDAP Users")
+		config := LDAPConfig{
+			Host:          "ldap.example.com",
+			BindDN:        "cn=admin,dc=example,dc=com",
+			BindPassword:  "adminpwd",
+			BaseDN:        "dc=example,dc=com",
+			TemplateUID:   7,
+			DefaultGroups: []*Group{ldapGroup},
+		}
+		backend := NewLDAPBackend(config, mapper)
+		backend.SetDialer(server.dial)
+		Convey("Known user with correct password should be authenticated", func() {
+			uid, err := backend.Authenticate("john", "johnpwd", nil)
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, 42)
+		})
+		Convey("Known user with wrong or empty password should be rejected", func() {
+			_, err := backend.Authenticate("john", "wrong", nil)
+			So(err, ShouldHaveSameTypeAs, InvalidCredentialsError(""))
+			_, err = backend.Authenticate("john", "", nil)
+			So(err, ShouldHaveSameTypeAs, InvalidCredentialsError(""))
+		})
+		Convey("Users not in directory or outside BaseDN should not be found", func() {
+			_, err := backend.Authenticate("nobody", "pwd", nil)
+			So(err, ShouldHaveSameTypeAs, UserNotFoundError(""))
+			_, err = backend.Authenticate("other", "otherpwd", nil)
+			So(err, ShouldHaveSameTypeAs, UserNotFoundError(""))
+		})
+		Convey("Special characters in login should be escaped", func() {
+			_, err := backend.Authenticate("j*", "johnpwd", nil)
+			So(err, ShouldHaveSameTypeAs, UserNotFoundError(""))
+			So(escapeLDAPFilter("j*(a)\\"), ShouldEqual, "j\\2a\\28a\\29\\5c")
+		})
+		Convey("Directory user without Hexya user should not be found without AutoCreate", func() {
+			_, err := backend.Authenticate("jane", "janepwd", nil)
+			So(err, ShouldHaveSameTypeAs, UserNotFoundError(""))
+			So(mapper.users, ShouldNotContainKey, "jane")
+		})
+		Convey("Directory user without Hexya user should be created with AutoCreate", func() {
+			config.AutoCreate = true
+			backend = NewLDAPBackend(config, mapper)
+			backend.SetDialer(server.dial)
+			uid, err := backend.Authenticate("jane", "janepwd", nil)
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, 101)
+			So(mapper.templates[101], ShouldEqual, 7)
+			So(Registry.HasMembership(101, ldapGroup), ShouldBeTrue)
+			uid, err = backend.Authenticate("jane", "janepwd", nil)
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, 101)
+		})
+		Convey("Unreachable server should not prevent other backends", func() {
+			server.down = true
+			_, err := backend.Authenticate("john", "johnpwd", nil)
+			So(err, ShouldHaveSameTypeAs, UserNotFoundError(""))
+		})
+		Convey("LDAP backend should chain in an AuthBackendRegistry", func() {
+			registry := new(AuthBackendRegistry)
+			registry.RegisterBackend(backend)
+			uid, err := registry.Authenticate("john", "johnpwd", nil)
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, 42)
+			_, err = registry.Authenticate("john", "wrong", nil)
+			So(err, ShouldHaveSameTypeAs, InvalidCredentialsError(""))
+			_, err = registry.Authenticate("nobody", "pwd", nil)
+			So(err, ShouldHaveSameTypeAs, UserNotFoundError(""))
+		})
+		Reset(func() {
+			Registry.UnregisterGroup(ldapGroup)
+		})
+	})
+}
@@ -23,6 +23,8 @@ func init() {
 
 	Registry = NewGroupCollection()
 	AuthenticationRegistry = new(AuthBackendRegistry)
+	APIKeys = NewAPIKeyRegistry()
+	TwoFactor = NewTwoFactorRegistry()
 	GroupAdmin = Registry.NewGroup(GroupAdminID, "Admin Group")
 	Registry.AddMembership(SuperUserID, GroupAdmin)
 	GroupEveryone = Registry.NewGroup(GroupEveryoneID, "Everyone")
@@ -15,7 +15,9 @@
 package security
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -177,3 +179,106 @@ func TestGroupRegistry(t *testing.T) {
 		})
 	})
 }
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Testing API keys", t, func() {
+		registry := NewAPIKeyRegistry()
+		token, key := registry.NewKey(2, "Test key", 0, "rpc", "read")
+		So(key.UID, ShouldEqual, 2)
+		So(key.Hash, ShouldNotContainSubstring, strings.Split(token, ".")[1])
+		Convey("Valid token should authenticate", func() {
+			k, err := registry.Authenticate(token)
+			So(err, ShouldBeNil)
+			So(k.UID, ShouldEqual, 2)
+			So(k.HasScope("rpc"), ShouldBeTrue)
+			So(k.HasScope("write"), ShouldBeFalse)
+			So(registry.UserKeys(2), ShouldHaveLength, 1)
+		})
+		Convey("Wrong or malformed tokens should be rejected", func() {
+			_, err := registry.Authenticate(key.ID + ".wrong")
+			So(err, ShouldEqual, ErrInvalidAPIKey)
+			_, err = registry.Authenticate("nodot")
+			So(err, ShouldEqual, ErrInvalidAPIKey)
+			_, err = registry.Authenticate("unknown." + strings.Split(token, ".")[1])
+			So(err, ShouldEqual, ErrInvalidAPIKey)
+		})
+		Convey("Expired tokens should be rejected", func() {
+			expToken, _ := registry.NewKey(2, "Expired key", -time.Hour)
+			_, err := registry.Authenticate(expToken)
+			So(err, ShouldEqual, ErrExpiredAPIKey)
+		})
+		Convey("Revoked tokens should be rejected", func() {
+			registry.RevokeKey(key.ID)
+			_, err := registry.Authenticate(token)
+			So(err, ShouldEqual, ErrInvalidAPIKey)
+			So(registry.UserKeys(2), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestTwoFactor(t *testing.T) {
+	Convey("Testing two-factor authentication", t, func() {
+		Convey("TOTP codes should follow RFC 6238", func() {
+			secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+			code, err := TOTPCode(secret, time.Unix(59, 0))
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, "287082")
+			code, err = TOTPCode(secret, time.Unix(1111111109, 0))
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, "081804")
+			So(TOTPURI("Hexya", "john@example.com", secret), ShouldStartWith, "otpauth://totp/Hexya:john@example.com?")
+		})
+		Convey("Enrolment, verification and recovery codes", func() {
+			registry := NewTwoFactorRegistry()
+			secret, uri := registry.Enrol(2, "john")
+			So(uri, ShouldContainSubstring, "secret="+secret)
+			So(registry.IsEnabled(2), ShouldBeFalse)
+			So(registry.Verify(2, "000000"), ShouldEqual, ErrTwoFactorNotEnrolled)
+			_, err := registry.Confirm(2, "not a code")
+			So(err, ShouldEqual, ErrInvalidTOTPCode)
+			code, _ := TOTPCode(secret, time.Now().Add(-TOTPPeriod))
+			recoveryCodes, err := registry.Confirm(2, code)
+			So(err, ShouldBeNil)
+			So(recoveryCodes, ShouldHaveLength, 10)
+			So(registry.IsEnabled(2), ShouldBeTrue)
+			code, _ = TOTPCode(secret, time.Now())
+			So(registry.Verify(2, code), ShouldBeNil)
+			So(registry.Verify(2, code), ShouldEqual, ErrInvalidTOTPCode)
+			So(registry.Verify(2, recoveryCodes[3]), ShouldBeNil)
+			So(registry.Verify(2, recoveryCodes[3]), ShouldEqual, ErrInvalidTOTPCode)
+			registry.Disable(2)
+			So(registry.IsEnabled(2), ShouldBeFalse)
+		})
+		Convey("Enrolling again should not disable 2FA before confirmation", func() {
+			registry := NewTwoFactorRegistry()
+			secret, _ := registry.Enrol(2, "john")
+			code, _ := TOTPCode(secret, time.Now().Add(-TOTPPeriod))
+			_, err := registry.Confirm(2, code)
+			So(err, ShouldBeNil)
+			newSecret, _ := registry.Enrol(2, "john")
+			So(registry.IsEnabled(2), ShouldBeTrue)
+			code, _ = TOTPCode(newSecret, time.Now())
+			So(registry.Verify(2, code), ShouldEqual, ErrInvalidTOTPCode)
+			code, _ = TOTPCode(secret, time.Now())
+			So(registry.Verify(2, code), ShouldBeNil)
+			code, _ = TOTPCode(newSecret, time.Now())
+			_, err = registry.Confirm(2, code)
+			So(err, ShouldBeNil)
+			So(registry.IsEnabled(2), ShouldBeTrue)
+			code, _ = TOTPCode(newSecret, time.Now().Add(TOTPPeriod))
+			So(registry.Verify(2, code), ShouldBeNil)
+		})
+		Convey("Enforcement per group", func() {
+			registry := NewTwoFactorRegistry()
+			group := Registry.NewGroup("group_2fa_test", "2FA Group")
+			Registry.AddMembership(7, group)
+			So(registry.IsRequired(7), ShouldBeFalse)
+			registry.RequireForGroup(group)
+			So(registry.IsRequired(7), ShouldBeTrue)
+			So(registry.IsRequired(8), ShouldBeFalse)
+			registry.UnrequireForGroup(group)
+			So(registry.IsRequired(7), ShouldBeFalse)
+			Registry.UnregisterGroup(group)
+		})
+	})
+}
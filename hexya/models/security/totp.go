@@ -0,0 +1,339 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TwoFactor is the two-factor authentication registry of the application
+var TwoFactor *TwoFactorRegistry
+
+const (
+	// TOTPPeriod is the validity duration of a TOTP code
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is the number of digits of a TOTP code
+	TOTPDigits = 6
+	// totpSkew is the number of periods before and after the current
+	// one during which a code is still accepted to allow clock drift.
+	totpSkew = 1
+	// recoveryCodesNumber is the number of recovery codes generated for a user
+	recoveryCodesNumber = 10
+)
+
+var (
+	// ErrTwoFactorNotEnrolled is returned when a user has not enrolled for two-factor authentication
+	ErrTwoFactorNotEnrolled = errors.New("user is not enrolled for two-factor authentication")
+	// ErrInvalidTOTPCode is returned when a TOTP or recovery code is wrong or has already been used
+	ErrInvalidTOTPCode = errors.New("invalid two-factor authentication code")
+)
+
+// GenerateTOTPSecret returns a new random base32 encoded TOTP secret
+func GenerateTOTPSecret() string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes(20))
+}
+
+// TOTPCode returns the TOTP code of the given base32 encoded secret at the given time,
+// as defined in RFC 6238 with HMAC-SHA1.
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotpCode(key, totpCounter(at)), nil
+}
+
+// TOTPURI returns the otpauth URI for the given secret so that it can be
+// enrolled into an authenticator application, typically through a QR code.
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(fmt.Sprintf("%s:%s", issuer, account))
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	params.Set("period", fmt.Sprintf("%d", int(TOTPPeriod.Seconds())))
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// checkTOTPCode checks the given code against the given secret at the given time.
+// Codes of counters lower or equal to lastCounter are refused to prevent replays.
+//
+// It returns the counter of the matched code and true if the code is valid.
+func checkTOTPCode(secret, code string, at time.Time, lastCounter uint64) (uint64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := totpCounter(at)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		counter := uint64(int64(current) + int64(i))
+		if counter <= lastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotpCode(key, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// decodeTOTPSecret decodes the given base32 secret, with or without padding
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.Replace(secret, " ", "", -1), "="))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+// totpCounter returns the TOTP counter at the given time
+func totpCounter(at time.Time) uint64 {
+	return uint64(at.Unix() / int64(TOTPPeriod.Seconds()))
+}
+
+// hotpCode returns the HOTP code of the given key and counter (RFC 4226)
+func hotpCode(key []byte, counter uint64) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(buf)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// TwoFactorData holds the two-factor authentication data of a user
+type TwoFactorData struct {
+	UID int64
+	// Secret is the base32 encoded TOTP secret
+	Secret string
+	// Enabled is true once the user has confirmed the enrolment with a valid code
+	Enabled bool
+	// PendingSecret is the base32 encoded TOTP secret created by an enrolment
+	// that has not been confirmed yet. It replaces Secret on confirmation.
+	PendingSecret string
+	// RecoveryCodes are the hashes of the unused recovery codes
+	RecoveryCodes []string
+	// LastCounter is the TOTP counter of the last accepted code
+	LastCounter uint64
+}
+
+// A TwoFactorStore persists two-factor authentication data of users.
+//
+// Modules can provide their own store with TwoFactorRegistry.SetStore.
+type TwoFactorStore interface {
+	// Get returns the data of the given user. The second returned
+	// value is false if the user has no data.
+	Get(uid int64) (TwoFactorData, bool)
+	// Save creates or updates the given data
+	Save(data TwoFactorData)
+	// Delete removes the data of the given user
+	Delete(uid int64)
+}
+
+// A TwoFactorRegistry manages TOTP enrolment and verification of users
+// and the groups for which two-factor authentication is enforced.
+type TwoFactorRegistry struct {
+	sync.RWMutex
+	// Issuer is the name of the application shown in authenticator applications
+	Issuer string
+	store  TwoFactorStore
+	groups map[*Group]bool
+}
+
+// SetStore sets the store in which data of this registry are persisted
+func (tr *TwoFactorRegistry) SetStore(store TwoFactorStore) {
+	tr.Lock()
+	defer tr.Unlock()
+	tr.store = store
+}
+
+// getStore returns the current store of this registry
+func (tr *TwoFactorRegistry) getStore() TwoFactorStore {
+	tr.RLock()
+	defer tr.RUnlock()
+	return tr.store
+}
+
+// RequireForGroup enforces two-factor authentication for members of the given group
+func (tr *TwoFactorRegistry) RequireForGroup(group *Group) {
+	tr.Lock()
+	defer tr.Unlock()
+	tr.groups[group] = true
+}
+
+// UnrequireForGroup removes two-factor authentication enforcement for the given group
+func (tr *TwoFactorRegistry) UnrequireForGroup(group *Group) {
+	tr.Lock()
+	defer tr.Unlock()
+	delete(tr.groups, group)
+}
+
+// IsRequired returns true if two-factor authentication is
+// enforced for the given user through one of his groups.
+func (tr *TwoFactorRegistry) IsRequired(uid int64) bool {
+	tr.RLock()
+	defer tr.RUnlock()
+	for group := range tr.groups {
+		if Registry.HasMembership(uid, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEnabled returns true if the given user has confirmed
+// his enrolment to two-factor authentication.
+func (tr *TwoFactorRegistry) IsEnabled(uid int64) bool {
+	data, ok := tr.getStore().Get(uid)
+	return ok && data.Enabled
+}
+
+// Enrol creates a new TOTP secret for the given user and returns it with its
+// otpauth URI. account is the user name shown in authenticator applications.
+//
+// The new secret is kept pending until the enrolment is confirmed with Confirm.
+// If the user has already enabled 2FA, the current secret remains active until
+// then, so that calling Enrol does not disable 2FA.
+func (tr *TwoFactorRegistry) Enrol(uid int64, account string) (string, string) {
+	secret := GenerateTOTPSecret()
+	data, ok := tr.getStore().Get(uid)
+	if !ok {
+		data = TwoFactorData{UID: uid}
+	}
+	data.PendingSecret = secret
+	tr.getStore().Save(data)
+	return secret, TOTPURI(tr.Issuer, account, secret)
+}
+
+// Confirm enables two-factor authentication for the given user if code is
+// valid for the secret created by Enrol, which then replaces the previous
+// secret of the user. It returns the new recovery codes of the user.
+func (tr *TwoFactorRegistry) Confirm(uid int64, code string) ([]string, error) {
+	data, ok := tr.getStore().Get(uid)
+	if !ok || data.PendingSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	counter, valid := checkTOTPCode(data.PendingSecret, code, time.Now(), 0)
+	if !valid {
+		return nil, ErrInvalidTOTPCode
+	}
+	data.Secret = data.PendingSecret
+	data.PendingSecret = ""
+	data.Enabled = true
+	data.LastCounter = counter
+	codes := newRecoveryCodes(&data)
+	tr.getStore().Save(data)
+	return codes, nil
+}
+
+// Disable removes two-factor authentication data of the given user
+func (tr *TwoFactorRegistry) Disable(uid int64) {
+	tr.getStore().Delete(uid)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the given user with new ones.
+func (tr *TwoFactorRegistry) RegenerateRecoveryCodes(uid int64) ([]string, error) {
+	data, ok := tr.getStore().Get(uid)
+	if !ok || !data.Enabled {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	codes := newRecoveryCodes(&data)
+	tr.getStore().Save(data)
+	return codes, nil
+}
+
+// Verify checks the given TOTP code or recovery code for the given user.
+// A recovery code can only be used once.
+func (tr *TwoFactorRegistry) Verify(uid int64, code string) error {
+	data, ok := tr.getStore().Get(uid)
+	if !ok || !data.Enabled {
+		return ErrTwoFactorNotEnrolled
+	}
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if counter, valid := checkTOTPCode(data.Secret, code, time.Now(), data.LastCounter); valid {
+		data.LastCounter = counter
+		tr.getStore().Save(data)
+		return nil
+	}
+	codeHash := hashSecret(strings.ToLower(code))
+	for i, rc := range data.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(rc), []byte(codeHash)) == 1 {
+			data.RecoveryCodes = append(data.RecoveryCodes[:i:i], data.RecoveryCodes[i+1:]...)
+			tr.getStore().Save(data)
+			return nil
+		}
+	}
+	return ErrInvalidTOTPCode
+}
+
+// newRecoveryCodes sets new recovery code hashes in data and returns the codes
+func newRecoveryCodes(data *TwoFactorData) []string {
+	codes := make([]string, recoveryCodesNumber)
+	data.RecoveryCodes = make([]string, recoveryCodesNumber)
+	for i := range codes {
+		codes[i] = randomHexString(5)
+		data.RecoveryCodes[i] = hashSecret(codes[i])
+	}
+	return codes
+}
+
+// NewTwoFactorRegistry returns a new TwoFactorRegistry with an in-memory store
+func NewTwoFactorRegistry() *TwoFactorRegistry {
+	return &TwoFactorRegistry{
+		Issuer: "Hexya",
+		store:  newMemoryTwoFactorStore(),
+		groups: make(map[*Group]bool),
+	}
+}
+
+// A memoryTwoFactorStore is a TwoFactorStore that keeps data in memory
+type memoryTwoFactorStore struct {
+	sync.RWMutex
+	data map[int64]TwoFactorData
+}
+
+// Get returns the data of the given user.
+func (ms *memoryTwoFactorStore) Get(uid int64) (TwoFactorData, bool) {
+	ms.RLock()
+	defer ms.RUnlock()
+	data, ok := ms.data[uid]
+	return data, ok
+}
+
+// Save creates or updates the given data
+func (ms *memoryTwoFactorStore) Save(data TwoFactorData) {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.data[data.UID] = data
+}
+
+// Delete removes the data of the given user
+func (ms *memoryTwoFactorStore) Delete(uid int64) {
+	ms.Lock()
+	defer ms.Unlock()
+	delete(ms.data, uid)
+}
+
+// newMemoryTwoFactorStore returns a new empty memoryTwoFactorStore
+func newMemoryTwoFactorStore() *memoryTwoFactorStore {
+	return &memoryTwoFactorStore{
+		data: make(map[int64]TwoFactorData),
+	}
+}
+
+var _ TwoFactorStore = new(memoryTwoFactorStore)
@@ -64,6 +64,7 @@ func initializeTests() {
 	dbArgs.DB = fmt.Sprintf("%s_models_tests", prefix)
 	dbArgs.Debug = os.Getenv("HEXYA_DEBUG")
 
+	viper.Set("EncryptionKeys", []string{"aGV4eWEtdGVzdC1rZXktMDEyMzQ1Njc4OWFiY2RlZmc="})
 	viper.Set("LogLevel", "crit")
 	if dbArgs.Debug != "" {
 		viper.Set("LogLevel", "debug")
@@ -32,6 +32,8 @@ func TestModelDeclaration(t *testing.T) {
 		addressMI := NewMixinModel("AddressMixIn")
 		activeMI := NewMixinModel("ActiveMixIn")
 		viewModel := NewManualModel("UserView")
+		company := NewModel("Company")
+		resource := NewModel("Resource")
 
 		user.AddCharField("Name", StringFieldParams{String: "Name", Help: "The user's username", Unique: true,
 			NoCopy: true, OnChange: "computeDecoratedName"})
@@ -66,10 +68,11 @@ func TestModelDeclaration(t *testing.T) {
 		profile.AddOne2OneField("BestPost", ForeignKeyFieldParams{RelationModel: Registry.MustGet("Post")})
 		profile.AddCharField("City", StringFieldParams{})
 		profile.AddCharField("Country", StringFieldParams{})
+		profile.AddCharField("Secret", StringFieldParams{Encrypted: true})
 
 		post.AddMany2OneField("User", ForeignKeyFieldParams{RelationModel: Registry.MustGet("User")})
 		post.AddCharField("Title", StringFieldParams{})
-		post.AddHTMLField("Content", StringFieldParams{})
+		post.AddHTMLField("Content", StringFieldParams{FullTextSearch: true, TextSearchLanguage: "en_US"})
 		post.AddMany2ManyField("Tags", Many2ManyFieldParams{RelationModel: Registry.MustGet("Tag")})
 		post.AddRev2OneField("BestPostProfile", ReverseFieldParams{RelationModel: Registry.MustGet("Profile"),
 			ReverseFK: "BestPost"})
@@ -95,6 +98,16 @@ func TestModelDeclaration(t *testing.T) {
 		viewModel.AddCharField("Name", StringFieldParams{})
 		viewModel.AddCharField("City", StringFieldParams{})
 
+		company.AddCharField("Name", StringFieldParams{})
+		companyMI := DeclareCompanyMixin(company)
+		resource.AddCharField("Name", StringFieldParams{})
+		resource.AddMany2OneField("Parent", ForeignKeyFieldParams{RelationModel: Registry.MustGet("Resource")})
+		resource.AddParentPathField()
+		resource.SetDefaultOrder("Name", "ID desc")
+		So(func() { resource.SetDefaultOrder("Name upwards") }, ShouldPanic)
+		So(resource.DefaultOrder(), ShouldResemble, []string{"Name", "ID desc"})
+		resource.InheritModel(companyMI)
+
 		user.AddMethod("PrefixedUser", "",
 			func(rc RecordCollection, prefix string) []string {
 				var res []string
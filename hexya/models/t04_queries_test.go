@@ -102,6 +102,52 @@ func TestConditions(t *testing.T) {
 					sql, _ := rs.query.selectQuery(fields)
 					So(sql, ShouldEqual, `SELECT DISTINCT "user".name AS name, "user".email AS email, "user".id AS id FROM "user" "user"  WHERE ("user".email ILIKE ? )  ORDER BY "user".email , "user".id  `)
 				})
+				Convey("Testing query with NULLS FIRST/LAST in ORDER BY clauses", func() {
+					rs = env.Pool("User").Search(rs.Model().Field("email").IContains("jane.smith@example.com")).OrderBy("Email DESC NULLS LAST", "ID nulls first")
+					sql, _ := rs.query.selectQuery([]string{"name"})
+					So(sql, ShouldEqual, `SELECT DISTINCT "user".name AS name, "user".email AS email, "user".id AS id FROM "user" "user"  WHERE ("user".email ILIKE ? )  ORDER BY "user".email desc nulls last, "user".id  nulls first `)
+				})
+				Convey("Testing query with model default order", func() {
+					resources := env.Pool("Resource")
+					rs := resources.Search(resources.Model().Field("Name").Equals("Resource"))
+					sql, _ := rs.query.selectQuery([]string{"parent_id"})
+					So(sql, ShouldEqual, `SELECT DISTINCT "resource".parent_id AS parent_id, "resource".name AS name, "resource".id AS id FROM "resource" "resource"  WHERE ("resource".name = ? )  ORDER BY "resource".name , "resource".id desc `)
+				})
+				Convey("Testing grouped query ordered by aggregates", func() {
+					rs = env.Pool("User").GroupBy(FieldName("IsStaff")).OrderBy("Nums:avg desc nulls last")
+					sql, _ := rs.query.selectGroupQuery(map[string]string{"is_staff": ""}, nil)
+					So(sql, ShouldEqual, `SELECT DISTINCT ("user".is_staff) AS is_staff, avg("user".nums) AS "nums:avg", count(1) AS __count FROM "user" "user"   GROUP BY "user".is_staff  ORDER BY "nums:avg" desc nulls last `)
+				})
+				Convey("Testing multi-row INSERT queries", func() {
+					sql, args := env.Pool("Tag").query.insertMultiQuery([]FieldMap{
+						{"name": "Tag A", "rate": 2.5},
+						{"name": "Tag B"},
+					})
+					So(sql, ShouldEqual, `INSERT INTO "tag" (name, rate) VALUES (?, ?), (?, DEFAULT)`)
+					So(args, ShouldResemble, SQLParams{"Tag A", 2.5, "Tag B"})
+				})
+				Convey("Invalid ORDER BY clauses should panic", func() {
+					users := env.Pool("User")
+					So(func() { users.OrderBy("Email sideways") }, ShouldPanic)
+					So(func() { users.OrderBy("Email desc nulls") }, ShouldPanic)
+					So(func() { users.OrderBy("Unknown") }, ShouldPanic)
+					So(func() { users.OrderBy("DecoratedName") }, ShouldPanic)
+					So(func() { users.OrderBy("Name:month") }, ShouldPanic)
+					So(func() { users.OrderBy("CreateDate:1) FROM pg_user; --") }, ShouldPanic)
+					So(func() { users.query.sqlFieldExpression("CreateDate:millennium") }, ShouldPanic)
+					So(func() { users.query.sqlFieldExpression("Nums:pg_sleep") }, ShouldPanic)
+					So(func() { users.OrderBy("Nums:avg").Fetch() }, ShouldPanic)
+					So(func() { users.GroupBy(FieldName("IsStaff")).OrderBy("Name").Aggregates(FieldName("IsStaff")) }, ShouldPanic)
+				})
+				Convey("Aggregate expressions in WHERE clauses should panic", func() {
+					users := env.Pool("User")
+					So(func() { users.Search(users.Model().Field("Nums:sum").Greater(12)).query.sqlWhereClause() }, ShouldPanic)
+					So(func() { users.Search(users.Model().Field("Name:evil").Equals("x")).query.sqlWhereClause() }, ShouldPanic)
+					So(func() {
+						users.Search(users.Model().Field("Name").Equals("x").
+							AndCond(users.Model().Field("Nums:max").Lower(3))).query.sqlWhereClause()
+					}, ShouldPanic)
+				})
 				Convey("Testing complex conditions", func() {
 					rs = env.Pool("User").Search(rs.Model().Field("Profile.Age").GreaterOrEqual(12).
 						AndNot().Field("Name").IContains("Jane").
@@ -220,6 +266,12 @@ func TestConditions(t *testing.T) {
 					So(sql, ShouldEqual, `SELECT DISTINCT "user".name AS name FROM "user" "user"  WHERE ("user".id = ? )  ORDER BY id `)
 					So(args, ShouldContain, 101)
 				})
+				Convey("Parent Of without parent field", func() {
+					rs = rs.Search(rs.Model().Field("ID").ParentOf(101))
+					sql, args := rs.query.selectQuery([]string{"Name"})
+					So(sql, ShouldEqual, `SELECT DISTINCT "user".name AS name FROM "user" "user"  WHERE ("user".id = ? )  ORDER BY id `)
+					So(args, ShouldContain, 101)
+				})
 			})
 		}
 	})
@@ -249,5 +301,114 @@ func TestConditionSerialization(t *testing.T) {
 			dom := cond.Serialize()
 			So(fmt.Sprint(dom), ShouldEqual, "[| [F = F Value] & | [B = B Value] [A = A Value] | [D = D Value] [C = C Value]]")
 		})
+		Convey("Testing condition with Any and None operators", func() {
+			sub := newCondition().And().Field("Title").Equals("Foo").And().Field("Tags").None(nil)
+			cond := newCondition().And().Field("Posts").Any(sub)
+			dom := cond.Serialize()
+			So(fmt.Sprint(dom), ShouldEqual, "[[Posts any [& [Title = Foo] [Tags not any []]]]]")
+		})
+	})
+	Convey("Testing domain parsing", t, func() {
+		Convey("Serialized conditions should be parsed back", func() {
+			aOrB := newCondition().And().Field("A").Equals("A Value").Or().Field("B").Equals("B Value")
+			cOrD := newCondition().And().Field("C").Equals("C Value").Or().Field("D").Equals("D Value")
+			conds := []*Condition{
+				newCondition().And().Field("Name").IContains("John").And().Field("Age").Greater(18),
+				newCondition().And().Field("Name").IContains("John").Or().Field("Age").Greater(18),
+				newCondition().And().Field("Name").IContains("John").And().Field("Age").Greater(18).Or().Field("IsStaff").Equals(true),
+				newCondition().AndCond(aOrB).AndCond(cOrD).Or().Field("F").Equals("F Value"),
+				newCondition().And().Field("Posts").All(aOrB).Or().Field("Posts").None(nil),
+			}
+			for _, cond := range conds {
+				So(fmt.Sprint(ParseDomain(cond.Serialize()).Serialize()), ShouldEqual, fmt.Sprint(cond.Serialize()))
+			}
+		})
+		Convey("JSON decoded domains should be parsed", func() {
+			dom := []interface{}{"|", []interface{}{"Name", "ilike", "John"}, []interface{}{"Age", ">", 18.0}, []interface{}{"IsStaff", "=", true}}
+			So(fmt.Sprint(ParseDomain(dom).Serialize()), ShouldEqual, "[& | [Name ilike John] [Age > 18] [IsStaff = true]]")
+		})
+		Convey("Empty domains should return nil", func() {
+			So(ParseDomain([]interface{}{}), ShouldBeNil)
+		})
+		Convey("Malformed domains should panic", func() {
+			So(func() { ParseDomain([]interface{}{"|", []interface{}{"Name", "=", "John"}}) }, ShouldPanic)
+			So(func() { ParseDomain([]interface{}{[]interface{}{"Name", "="}}) }, ShouldPanic)
+			So(func() { ParseDomain([]interface{}{12}) }, ShouldPanic)
+			So(func() { ParseDomain([]interface{}{[]interface{}{"Name", "equals", "John"}}) }, ShouldPanic)
+		})
+		Convey("Python literal domains should be parsed", func() {
+			dom, err := ParseDomainString(`[('Name', 'ilike', "O'Brien"), '|', ('Age', '>=', 18), ('IsStaff', '=', True), ('Email', '!=', None), ("Size", "<", -1.5e2),]`)
+			So(err, ShouldBeNil)
+			So(dom, ShouldResemble, []interface{}{
+				[]interface{}{"Name", "ilike", "O'Brien"},
+				"|",
+				[]interface{}{"Age", ">=", int64(18)},
+				[]interface{}{"IsStaff", "=", true},
+				[]interface{}{"Email", "!=", nil},
+				[]interface{}{"Size", "<", -150.0},
+			})
+		})
+		Convey("JSON domains should be parsed", func() {
+			dom, err := ParseDomainString(`["!", ["Name", "in", ["John", "Janeé", "Tab\t"]], ["IsStaff", "=", false]]`)
+			So(err, ShouldBeNil)
+			So(dom, ShouldResemble, []interface{}{
+				"!",
+				[]interface{}{"Name", "in", []interface{}{"John", "Janeé", "Tab\t"}},
+				[]interface{}{"IsStaff", "=", false},
+			})
+			dom, err = ParseDomainString("  ")
+			So(err, ShouldBeNil)
+			So(dom, ShouldBeNil)
+		})
+		Convey("Invalid domain strings should return an error", func() {
+			for _, dom := range []string{
+				`[('Name', '=', 'John')`,
+				`[('Name', '=', 'John)]`,
+				`[('Name' '=', 'John')]`,
+				`[('UserID', '=', uid)]`,
+				`('Name', '=', 'John')]`,
+				`'Name'`,
+				`[('Age', '=', 1-2)]`,
+			} {
+				_, err := ParseDomainString(dom)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("Domains should be validated against their model", func() {
+			userModel := Registry.MustGet("User")
+			cond, err := userModel.ParseDomain(`['|', ('Profile.Age', '>', 18), ('name', 'ilike', 'John')]`)
+			So(err, ShouldBeNil)
+			So(fmt.Sprint(cond.Serialize()), ShouldEqual, "[| [Profile.Age > 18] [name ilike John]]")
+			cond, err = userModel.ParseDomain([]interface{}{"!", []interface{}{"Posts.Title", "=", "Foo"}})
+			So(err, ShouldBeNil)
+			So(cond, ShouldNotBeNil)
+			cond, err = userModel.ParseDomain("[]")
+			So(err, ShouldBeNil)
+			So(cond, ShouldBeNil)
+			_, err = userModel.ParseDomain(`[('Nickname', '=', 'John')]`)
+			So(err, ShouldNotBeNil)
+			_, err = userModel.ParseDomain(`['|', ('Name', '=', 'John'), ('Profile.Nickname', '=', 'John')]`)
+			So(err, ShouldNotBeNil)
+			_, err = userModel.ParseDomain(`[('Name.Age', '=', 12)]`)
+			So(err, ShouldNotBeNil)
+			_, err = userModel.ParseDomain(`[('Name', 'equals', 'John')]`)
+			So(err, ShouldNotBeNil)
+			_, err = userModel.ParseDomain(`[('Name', '=')]`)
+			So(err, ShouldNotBeNil)
+			_, err = userModel.ParseDomain(12)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Sub domains of 2many fields should be validated against their related model", func() {
+			userModel := Registry.MustGet("User")
+			cond, err := userModel.ParseDomain(`[('Posts', 'any', [('Title', '=', 'Foo'), ('Tags', 'not any', [])])]`)
+			So(err, ShouldBeNil)
+			So(fmt.Sprint(cond.Serialize()), ShouldEqual, "[[Posts any [& [Title = Foo] [Tags not any []]]]]")
+			_, err = userModel.ParseDomain(`[('Posts', 'all', [('Nickname', '=', 'Foo')])]`)
+			So(err, ShouldNotBeNil)
+			_, err = userModel.ParseDomain(`[('Profile', 'any', [])]`)
+			So(err, ShouldNotBeNil)
+			_, err = userModel.ParseDomain(`[('Posts', 'any', 'Foo')]`)
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
@@ -15,9 +15,12 @@
 package models
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -403,6 +406,52 @@ func TestAdvancedQueries(t *testing.T) {
 				So(users.Len(), ShouldEqual, 1)
 				So(users.Get("ID").(int64), ShouldEqual, jane.Get("ID").(int64))
 			})
+			Convey("Conditions on o2m relation with Any, None and All operators", func() {
+				userModel := env.Pool("User").Model()
+				postModel := env.Pool("Post").Model()
+				users := env.Pool("User").Search(userModel.Field("Posts").Any(postModel.Field("Title").Equals("1st Post")))
+				So(users.Len(), ShouldEqual, 1)
+				So(users.Get("ID").(int64), ShouldEqual, jane.Get("ID").(int64))
+				users = env.Pool("User").Search(userModel.Field("Posts").Any(nil))
+				So(users.Len(), ShouldEqual, 1)
+				So(users.Get("ID").(int64), ShouldEqual, jane.Get("ID").(int64))
+				users = env.Pool("User").Search(userModel.Field("Posts").None(postModel.Field("Title").Equals("1st Post")))
+				So(users.Len(), ShouldEqual, 2)
+				So(users.Ids(), ShouldNotContain, jane.Get("ID").(int64))
+				users = env.Pool("User").Search(userModel.Field("Posts").All(postModel.Field("Title").Equals("1st Post")))
+				So(users.Len(), ShouldEqual, 2)
+				So(users.Ids(), ShouldNotContain, jane.Get("ID").(int64))
+				users = env.Pool("User").Search(userModel.Field("Posts").All(postModel.Field("Title").Contains("Post")))
+				So(users.Len(), ShouldEqual, 3)
+				users = env.Pool("User").Search(userModel.Field("Name").Equals("Jane Smith").AndNot().Field("Posts").Any(nil))
+				So(users.Len(), ShouldEqual, 0)
+			})
+			Convey("Conditions on m2m relation with Any, None and All operators", func() {
+				postModel := env.Pool("Post").Model()
+				tagModel := env.Pool("Tag").Model()
+				posts := env.Pool("Post").Search(postModel.Field("Tags").Any(tagModel.Field("Name").Equals("Books")))
+				So(posts.Len(), ShouldEqual, 1)
+				So(posts.Get("Title"), ShouldEqual, "2nd Post")
+				posts = env.Pool("Post").Search(postModel.Field("Tags").None(tagModel.Field("Name").Equals("Trending")))
+				So(posts.Len(), ShouldEqual, 1)
+				So(posts.Get("Title"), ShouldEqual, "2nd Post")
+				posts = env.Pool("Post").Search(postModel.Field("Tags").All(tagModel.Field("Name").In([]string{"Books", "Jane's"})))
+				So(posts.Len(), ShouldEqual, 1)
+				So(posts.Get("Title"), ShouldEqual, "2nd Post")
+				users := env.Pool("User").Search(env.Pool("User").Model().Field("Posts").Any(
+					postModel.Field("Tags").Any(tagModel.Field("Name").Equals("Books"))))
+				So(users.Len(), ShouldEqual, 1)
+				So(users.Get("ID").(int64), ShouldEqual, jane.Get("ID").(int64))
+			})
+			Convey("Any, None and All operators on other fields should panic", func() {
+				userModel := env.Pool("User").Model()
+				So(func() {
+					env.Pool("User").Search(userModel.Field("Profile").Any(nil)).Fetch()
+				}, ShouldPanic)
+				So(func() {
+					env.Pool("User").Search(userModel.Field("Name").All(nil)).Fetch()
+				}, ShouldPanic)
+			})
 		})
 	})
 }
@@ -423,6 +472,292 @@ func TestGroupedQueries(t *testing.T) {
 				So(groupedUsers[1].Values["is_staff"], ShouldBeTrue)
 				So(groupedUsers[1].Values["nums"], ShouldEqual, 4)
 				So(groupedUsers[1].Count, ShouldEqual, 2)
+				for _, row := range groupedUsers {
+					So(env.Pool("User").Search(row.Condition).Len(), ShouldEqual, row.Count)
+				}
+			})
+			Convey("Grouped query with explicit aggregates", func() {
+				groupedUsers := env.Pool("User").GroupBy(FieldName("IsStaff")).Aggregates(FieldName("IsStaff"),
+					FieldName("Nums:avg"), FieldName("Nums:max"), FieldName("ID:array_agg"), FieldName("Email:count_distinct"),
+					FieldName("Name:min"), FieldName("CreateDate:max"), FieldName("IsStaff:bool_and"))
+				So(len(groupedUsers), ShouldEqual, 2)
+				staff := groupedUsers[1]
+				So(staff.Values["is_staff"], ShouldBeTrue)
+				So(staff.Values["nums:avg"], ShouldEqual, 2.0)
+				So(staff.Values["nums:max"], ShouldBeGreaterThanOrEqualTo, 2)
+				So(staff.Values["email:count_distinct"], ShouldEqual, 2)
+				So(staff.Values["is_staff:bool_and"], ShouldBeTrue)
+				So(staff.Values["name:min"], ShouldHaveSameTypeAs, "")
+				So(staff.Values["create_date:max"], ShouldHaveSameTypeAs, dates.DateTime{})
+				ids := staff.Values["id:array_agg"].([]int64)
+				So(ids, ShouldHaveLength, 2)
+				So(env.Pool("User").Search(env.Pool("User").Model().Field("ID").In(ids).And().Field("IsStaff").Equals(true)).Len(), ShouldEqual, 2)
+			})
+			Convey("Grouped query ordered by aggregates", func() {
+				users := env.Pool("User")
+				groupedUsers := users.GroupBy(FieldName("IsStaff")).OrderBy("ID:count desc").Aggregates(FieldName("IsStaff"))
+				So(len(groupedUsers), ShouldEqual, 2)
+				So(groupedUsers[0].Values["is_staff"], ShouldBeTrue)
+				So(groupedUsers[0].Count, ShouldEqual, 2)
+				So(groupedUsers[0].Values, ShouldNotContainKey, "id:count")
+				groupedUsers = users.GroupBy(FieldName("IsStaff")).OrderBy("Nums desc nulls last").Aggregates(FieldName("IsStaff"), FieldName("Nums"))
+				So(len(groupedUsers), ShouldEqual, 2)
+				So(groupedUsers[0].Values["is_staff"], ShouldBeTrue)
+				So(groupedUsers[0].Values["nums"], ShouldEqual, 4)
+				So(groupedUsers[0].Values, ShouldNotContainKey, "nums:sum")
+			})
+			Convey("Grouped query with HAVING clause", func() {
+				users := env.Pool("User")
+				groupedUsers := users.GroupBy(FieldName("IsStaff")).Having(users.Model().Field("ID:count").Greater(1)).Aggregates(FieldName("IsStaff"), FieldName("Nums"))
+				So(len(groupedUsers), ShouldEqual, 1)
+				So(groupedUsers[0].Values["is_staff"], ShouldBeTrue)
+				So(groupedUsers[0].Count, ShouldEqual, 2)
+				groupedUsers = users.GroupBy(FieldName("IsStaff")).
+					Having(users.Model().Field("Nums:sum").Lower(3).Or().Field("Nums:max").Greater(100)).
+					Aggregates(FieldName("IsStaff"), FieldName("Nums"))
+				So(len(groupedUsers), ShouldEqual, 1)
+				So(groupedUsers[0].Values["is_staff"], ShouldBeFalse)
+				if dbArgs.Driver == "postgres" {
+					rs := users.GroupBy(FieldName("IsStaff")).Having(users.Model().Field("Profile.Age:avg").GreaterOrEqual(18))
+					sql, args := rs.query.sqlHavingClause()
+					So(sql, ShouldEqual, `HAVING (avg("user__profile".age) >= ? ) `)
+					So(args, ShouldContain, 18)
+				}
+			})
+			Convey("Invalid aggregates should panic", func() {
+				users := env.Pool("User").GroupBy(FieldName("IsStaff"))
+				So(func() { users.Aggregates(FieldName("Name:sum")) }, ShouldPanic)
+				So(func() { users.Aggregates(FieldName("Nums:median")) }, ShouldPanic)
+				So(func() { users.Having(users.Model().Field("IsStaff:avg").Greater(1)) }, ShouldPanic)
+			})
+			Convey("Grouped query by date granularity", func() {
+				users := env.Pool("User").WithContext("tz", "Europe/Paris")
+				groupedUsers := users.Call("GroupBy", []FieldNamer{FieldName("CreateDate:month")}).(RecordCollection).Call("Aggregates", []FieldNamer{FieldName("Nums")}).([]GroupAggregateRow)
+				var total int
+				for _, row := range groupedUsers {
+					So(row.Values, ShouldContainKey, "create_date:month")
+					month := row.Values["create_date:month"].(dates.DateTime)
+					So(month.Day(), ShouldEqual, 1)
+					So(month.Hour(), ShouldEqual, 0)
+					So(month.Location().String(), ShouldEqual, "Europe/Paris")
+					So(env.Pool("User").Search(row.Condition).Len(), ShouldEqual, row.Count)
+					total += row.Count
+				}
+				So(total, ShouldEqual, env.Pool("User").FetchAll().Len())
+			})
+			Convey("Ordering by date granularity", func() {
+				users := env.Pool("User").WithContext("tz", "Europe/Paris")
+				groupedUsers := users.GroupBy(FieldName("CreateDate:month")).OrderBy("CreateDate:month desc").Aggregates(FieldName("Nums"))
+				So(len(groupedUsers), ShouldBeGreaterThan, 0)
+				for i := 1; i < len(groupedUsers); i++ {
+					prev := groupedUsers[i-1].Values["create_date:month"].(dates.DateTime)
+					month := groupedUsers[i].Values["create_date:month"].(dates.DateTime)
+					So(month.Before(prev.Time), ShouldBeTrue)
+				}
+				So(func() { users.OrderBy("CreateDate:month").Fetch() }, ShouldPanic)
+			})
+			Convey("Grouped query on a Date field", func() {
+				groupedPosts := env.Pool("Post").Call("GroupBy", []FieldNamer{FieldName("LastRead:year")}).(RecordCollection).Call("Aggregates", []FieldNamer{FieldName("Title")}).([]GroupAggregateRow)
+				So(len(groupedPosts), ShouldBeGreaterThan, 0)
+				for _, row := range groupedPosts {
+					if year, ok := row.Values["last_read:year"].(dates.Date); ok {
+						So(year.Month(), ShouldEqual, time.January)
+						So(year.Day(), ShouldEqual, 1)
+					}
+					So(env.Pool("Post").Search(row.Condition).Len(), ShouldEqual, row.Count)
+				}
+			})
+			Convey("Date granularity SQL", func() {
+				if dbArgs.Driver == "postgres" {
+					rs := env.Pool("User").WithContext("tz", "Europe/Paris")
+					rs.query.recordSet = rs
+					rs = rs.GroupBy(FieldName("CreateDate:week"), FieldName("IsStaff"))
+					So(rs.query.sqlGroupByClause(), ShouldEqual, `GROUP BY date_trunc('week', timezone('Europe/Paris', timezone('UTC', "user".create_date))), "user".is_staff`)
+					posts := env.Pool("Post").GroupBy(FieldName("LastRead:quarter"))
+					So(posts.query.sqlGroupByClause(), ShouldEqual, `GROUP BY date_trunc('quarter', "post".last_read)::date`)
+				}
+			})
+			Convey("Invalid date granularities should panic", func() {
+				So(func() { env.Pool("User").GroupBy(FieldName("CreateDate:hour")) }, ShouldPanic)
+				So(func() { env.Pool("User").GroupBy(FieldName("Name:month")) }, ShouldPanic)
+				So(func() {
+					env.Pool("User").WithContext("tz", "Nowhere/Town").GroupBy(FieldName("CreateDate:month")).Aggregates(FieldName("Nums"))
+				}, ShouldPanic)
+			})
+		})
+	})
+}
+
+func TestCreateMultiRecordSet(t *testing.T) {
+	Convey("Testing creation of several records at once", t, func() {
+		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profiles := env.Pool("Profile").Call("CreateMulti", []FieldMapper{
+				FieldMap{"Age": 30, "City": "Paris"},
+				FieldMap{"Age": 40},
+			}).(RecordSet).Collection()
+			Convey("Records should be created in the order of data", func() {
+				So(profiles.Len(), ShouldEqual, 2)
+				recs := profiles.Records()
+				So(recs[0].Get("Age"), ShouldEqual, 30)
+				So(recs[0].Get("City"), ShouldEqual, "Paris")
+				So(recs[1].Get("Age"), ShouldEqual, 40)
+				So(recs[1].Get("City"), ShouldEqual, "")
+			})
+			Convey("Defaults, embedded records and stored computed fields should be processed", func() {
+				users := env.Pool("User").Call("CreateMulti", []FieldMapper{
+					FieldMap{"Name": "Multi 1", "Email": "multi1@example.com", "Profile": profiles.Records()[0]},
+					FieldMap{"Name": "Multi 2", "Email": "multi2@example.com", "Profile": profiles.Records()[1], "IsStaff": true},
+				}).(RecordSet).Collection()
+				So(users.Len(), ShouldEqual, 2)
+				recs := users.Records()
+				So(recs[0].Get("Name"), ShouldEqual, "Multi 1")
+				So(recs[0].Get("Status"), ShouldEqual, 12)
+				So(recs[0].Get("Age"), ShouldEqual, 30)
+				So(recs[1].Get("Age"), ShouldEqual, 40)
+				So(recs[1].Get("IsStaff"), ShouldBeTrue)
+				post1 := recs[0].Get("LastPost").(RecordCollection)
+				post2 := recs[1].Get("LastPost").(RecordCollection)
+				So(post1.IsEmpty(), ShouldBeFalse)
+				So(post2.IsEmpty(), ShouldBeFalse)
+				So(post1.Equals(post2), ShouldBeFalse)
+			})
+			Convey("Constraints should be checked on all records", func() {
+				So(func() {
+					env.Pool("Tag").Call("CreateMulti", []FieldMapper{
+						FieldMap{"Name": "Tag 1", "Rate": float32(5)},
+						FieldMap{"Name": "Tag 2", "Rate": float32(12)},
+					})
+				}, ShouldPanic)
+			})
+			Convey("Records should be created with Create if it is extended", func() {
+				So(env.Pool("Post").model.methods.MustGet("Create").isExtended(), ShouldBeTrue)
+				So(env.Pool("Tag").model.methods.MustGet("Create").isExtended(), ShouldBeFalse)
+				posts := env.Pool("Post").Call("CreateMulti", []FieldMapper{
+					FieldMap{"Title": "Multi Post 1"},
+					FieldMap{"Title": "Multi Post 2"},
+				}).(RecordSet).Collection()
+				So(posts.Len(), ShouldEqual, 2)
+				So(posts.Records()[0].Get("Title"), ShouldEqual, "Multi Post 1")
+				So(posts.Records()[1].Get("Title"), ShouldEqual, "Multi Post 2")
+			})
+			Convey("Many records should be returned in the order of data", func() {
+				var data []FieldMapper
+				for i := 0; i < 50; i++ {
+					data = append(data, FieldMap{"Name": fmt.Sprintf("Tag %02d", i)})
+				}
+				tags := env.Pool("Tag").Call("CreateMulti", data).(RecordSet).Collection()
+				So(tags.Len(), ShouldEqual, 50)
+				for i, tag := range tags.Records() {
+					So(tag.Get("Name"), ShouldEqual, fmt.Sprintf("Tag %02d", i))
+				}
+			})
+			Convey("Empty data should create no record", func() {
+				res := env.Pool("Tag").Call("CreateMulti", []FieldMapper{}).(RecordSet).Collection()
+				So(res.IsEmpty(), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestIterateRecordSet(t *testing.T) {
+	Convey("Testing iteration over RecordSets in batches", t, func() {
+		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User").FetchAll()
+			allIds := users.Ids()
+			So(len(allIds), ShouldBeGreaterThan, 2)
+			Convey("All records should be iterated over by ID", func() {
+				var (
+					ids     []int64
+					batches int
+				)
+				users.Iterate(2, func(rc RecordCollection) bool {
+					So(rc.Len(), ShouldBeLessThanOrEqualTo, 2)
+					So(env.cache.checkIfInCache(rc.model, rc.Ids(), []string{"name"}), ShouldBeTrue)
+					ids = append(ids, rc.Ids()...)
+					batches++
+					return true
+				}, FieldName("Name"))
+				So(ids, ShouldResemble, allIds)
+				So(batches, ShouldEqual, (len(allIds)+1)/2)
+				So(env.cache.checkIfInCache(users.model, allIds, []string{"name"}), ShouldBeFalse)
+			})
+			Convey("Iteration should stop when fnct returns false", func() {
+				var batches int
+				users.Iterate(1, func(rc RecordCollection) bool {
+					batches++
+					return false
+				})
+				So(batches, ShouldEqual, 1)
+			})
+			Convey("Ordered and limited RecordSets should be iterated in order", func() {
+				ordered := users.OrderBy("Name desc").Limit(3)
+				var names []string
+				ordered.Iterate(2, func(rc RecordCollection) bool {
+					for _, rec := range rc.Records() {
+						names = append(names, rec.Get("Name").(string))
+					}
+					return true
+				})
+				var expected []string
+				for _, rec := range ordered.Records() {
+					expected = append(expected, rec.Get("Name").(string))
+				}
+				So(names, ShouldResemble, expected)
+			})
+			Convey("Modifying ordered records while iterating should not skip records", func() {
+				ordered := users.OrderBy("Name")
+				expected := ordered.Fetch().Ids()
+				var ids []int64
+				ordered.Iterate(1, func(rc RecordCollection) bool {
+					ids = append(ids, rc.Ids()...)
+					rc.Set("Name", "zzzz "+rc.Get("Name").(string))
+					return true
+				})
+				So(ids, ShouldResemble, expected)
+			})
+			Convey("Fetched RecordSets should be iterated over by their ids", func() {
+				fetched := env.Pool("User").Call("Browse", allIds[:2]).(RecordSet).Collection().Fetch()
+				var ids []int64
+				fetched.Iterate(1, func(rc RecordCollection) bool {
+					ids = append(ids, rc.Ids()...)
+					return true
+				})
+				So(ids, ShouldResemble, allIds[:2])
+			})
+			Convey("Empty RecordSets should not be iterated over", func() {
+				env.Pool("User").Iterate(10, func(rc RecordCollection) bool {
+					So(false, ShouldBeTrue)
+					return true
+				})
+				So(func() { users.Iterate(0, func(rc RecordCollection) bool { return true }) }, ShouldPanic)
+			})
+		})
+	})
+}
+
+func TestRecomputeRecordSet(t *testing.T) {
+	Convey("Testing recomputation of stored computed fields", t, func() {
+		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User").FetchAll()
+			env.cr.Execute(`UPDATE "user" SET age = 99`)
+			Convey("Recompute should store the computed values again", func() {
+				users.Recompute(2, FieldName("Age"))
+				var ages []int16
+				env.cr.Select(&ages, `SELECT age FROM "user" WHERE age = 99`)
+				So(ages, ShouldBeEmpty)
+				jane := users.Search(users.Model().Field("Name").Equals("Jane Smith"))
+				So(jane.Get("Age"), ShouldEqual, 23)
+			})
+			Convey("Recompute should only recompute the given records", func() {
+				john := users.Search(users.Model().Field("Name").Equals("John Smith"))
+				john.Recompute(10)
+				var count int
+				env.cr.Get(&count, `SELECT COUNT(*) FROM "user" WHERE age = 99`)
+				So(count, ShouldEqual, len(users.Ids())-1)
+			})
+			Convey("Recomputing non stored computed fields should panic", func() {
+				So(func() { users.Recompute(10, FieldName("Name")) }, ShouldPanic)
+				So(func() { users.Recompute(10, FieldName("DecoratedName")) }, ShouldPanic)
 			})
 		})
 	})
@@ -15,10 +15,12 @@
 package models
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/hexya-erp/hexya/hexya/models/security"
 	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/lib/pq"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -81,3 +83,154 @@ func TestEnvironment(t *testing.T) {
 		})
 	})
 }
+
+func TestTransactionHooks(t *testing.T) {
+	Convey("Testing transaction hooks", t, func() {
+		var events []string
+		register := func(env Environment, name string) {
+			env.OnCommit(func() {
+				events = append(events, name+" committed")
+			})
+			env.OnRollback(func() {
+				events = append(events, name+" rolled back")
+			})
+		}
+		Convey("OnCommit hooks should run after a successful commit", func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				register(env, "first")
+				register(env.Pool("User").Env(), "second")
+				So(events, ShouldBeEmpty)
+			})
+			So(err, ShouldBeNil)
+			So(events, ShouldResemble, []string{"first committed", "second committed"})
+		})
+		Convey("OnRollback hooks should run after a rollback", func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				register(env.Pool("User").Env(), "first")
+				panic("rollback")
+			})
+			So(err, ShouldNotBeNil)
+			So(events, ShouldResemble, []string{"first rolled back"})
+			events = nil
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				register(env, "simulated")
+			})
+			So(events, ShouldResemble, []string{"simulated rolled back"})
+		})
+		Convey("Panicking hooks should not prevent other hooks from running", func() {
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.OnCommit(func() {
+					panic("hook failure")
+				})
+				register(env, "first")
+			})
+			So(err, ShouldBeNil)
+			So(events, ShouldResemble, []string{"first committed"})
+		})
+		Convey("Hooks of retried attempts should be discarded", func() {
+			var attempts int
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				attempts++
+				register(env, fmt.Sprintf("attempt %d", attempts))
+				if attempts < 3 {
+					panic(&pq.Error{Code: "40001"})
+				}
+			})
+			So(err, ShouldBeNil)
+			So(attempts, ShouldEqual, 3)
+			So(events, ShouldResemble, []string{"attempt 3 committed"})
+		})
+		Convey("Serialization errors raised at commit should be retried", func() {
+			var attempts int
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				attempts++
+				register(env, fmt.Sprintf("attempt %d", attempts))
+				if attempts < 3 {
+					// This deferred trigger raises a serialization error when committing
+					env.cr.Execute(`CREATE FUNCTION pg_temp.fail_commit() RETURNS trigger AS $$
+						BEGIN RAISE EXCEPTION 'commit conflict' USING ERRCODE = 'serialization_failure'; END
+						$$ LANGUAGE plpgsql`)
+					env.cr.Execute(`CREATE TEMPORARY TABLE commit_conflict (id integer)`)
+					env.cr.Execute(`CREATE CONSTRAINT TRIGGER commit_conflict_trigger AFTER INSERT ON commit_conflict
+						DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE PROCEDURE pg_temp.fail_commit()`)
+					env.cr.Execute(`INSERT INTO commit_conflict VALUES (1)`)
+				}
+			})
+			So(err, ShouldBeNil)
+			So(attempts, ShouldEqual, 3)
+			So(events, ShouldResemble, []string{"attempt 3 committed"})
+		})
+		Convey("Serialization errors should be retried a limited number of times", func() {
+			var attempts int
+			err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				attempts++
+				register(env, "attempt")
+				panic(&pq.Error{Code: "40001"})
+			})
+			So(err, ShouldHaveSameTypeAs, ConcurrencyError{})
+			So(attempts, ShouldEqual, int(DBSerializationMaxRetries)+1)
+			So(events, ShouldResemble, []string{"attempt rolled back"})
+		})
+	})
+}
+
+func TestSavepoint(t *testing.T) {
+	Convey("Testing savepoints", t, func() {
+		Convey("Changes made in a failed savepoint should be rolled back", func() {
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tagModel := Registry.MustGet("Tag")
+				tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Savepoint Tag"}).(RecordCollection)
+				err := env.Savepoint(func() {
+					tag.Set("Name", "Modified Savepoint Tag")
+					env.Pool("Tag").Call("Create", FieldMap{"Name": "Rolled Back Tag"})
+					So(tag.Get("Name"), ShouldEqual, "Modified Savepoint Tag")
+					panic("savepoint failure")
+				})
+				So(err, ShouldNotBeNil)
+				So(tag.Get("Name"), ShouldEqual, "Savepoint Tag")
+				So(env.Pool("Tag").Search(tagModel.Field("Name").Equals("Rolled Back Tag")).Len(), ShouldEqual, 0)
+				err = env.Savepoint(func() {
+					tag.Set("Name", "Kept Savepoint Tag")
+				})
+				So(err, ShouldBeNil)
+				So(tag.Get("Name"), ShouldEqual, "Kept Savepoint Tag")
+			})
+		})
+		Convey("Savepoints should be nestable", func() {
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tagModel := Registry.MustGet("Tag")
+				var innerErr error
+				outerErr := env.Savepoint(func() {
+					env.Pool("Tag").Call("Create", FieldMap{"Name": "Outer Tag"})
+					innerErr = env.Savepoint(func() {
+						env.Pool("Tag").Call("Create", FieldMap{"Name": "Inner Tag"})
+						panic("inner failure")
+					})
+				})
+				So(outerErr, ShouldBeNil)
+				So(innerErr, ShouldNotBeNil)
+				So(env.Pool("Tag").Search(tagModel.Field("Name").Equals("Outer Tag")).Len(), ShouldEqual, 1)
+				So(env.Pool("Tag").Search(tagModel.Field("Name").Equals("Inner Tag")).Len(), ShouldEqual, 0)
+			})
+		})
+		Convey("Hooks registered in a failed savepoint should not be committed", func() {
+			var events []string
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.OnCommit(func() {
+					events = append(events, "outer committed")
+				})
+				env.Savepoint(func() {
+					env.OnCommit(func() {
+						events = append(events, "inner committed")
+					})
+					env.OnRollback(func() {
+						events = append(events, "inner rolled back")
+					})
+					panic("savepoint failure")
+				})
+				So(events, ShouldResemble, []string{"inner rolled back"})
+			})
+			So(events, ShouldResemble, []string{"inner rolled back", "outer committed"})
+		})
+	})
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMultiCompany(t *testing.T) {
+	Convey("Testing multi-company", t, func() {
+		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			companies := env.Pool("Company")
+			company1 := companies.Call("Create", FieldMap{"Name": "Company 1"}).(RecordSet).Collection()
+			company2 := companies.Call("Create", FieldMap{"Name": "Company 2"}).(RecordSet).Collection()
+			resources := env.Pool("Resource")
+			res1 := resources.WithCompany(company1.Ids()[0]).Call("Create", FieldMap{"Name": "Resource 1"}).(RecordSet).Collection()
+			res2 := resources.Call("Create", FieldMap{"Name": "Resource 2", "Company": company2}).(RecordSet).Collection()
+			resources.Call("Create", FieldMap{"Name": "Shared Resource"})
+			Convey("Company should default to the current company", func() {
+				So(res1.Get("Company").(RecordCollection).Equals(company1), ShouldBeTrue)
+				So(res2.Get("Company").(RecordCollection).Equals(company2), ShouldBeTrue)
+			})
+			Convey("Records should be filtered on allowed companies", func() {
+				So(resources.FetchAll().Len(), ShouldEqual, 3)
+				So(resources.WithCompany(company1.Ids()[0]).FetchAll().Len(), ShouldEqual, 2)
+				So(resources.WithCompany(company2.Ids()[0]).FetchAll().Len(), ShouldEqual, 2)
+				So(resources.WithCompany(company1.Ids()[0], company1.Ids()[0], company2.Ids()[0]).FetchAll().Len(), ShouldEqual, 3)
+			})
+			Convey("Many2One fields should be consistent with the company", func() {
+				So(func() { res1.Set("Parent", res2) }, ShouldPanic)
+				shared := resources.Search(resources.Model().Field("Name").Equals("Shared Resource"))
+				So(func() { res1.Set("Parent", shared) }, ShouldNotPanic)
+				So(func() {
+					resources.Call("Create", FieldMap{"Name": "Resource 3", "Company": company1, "Parent": res2})
+				}, ShouldPanic)
+			})
+		})
+	})
+}
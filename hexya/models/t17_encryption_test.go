@@ -0,0 +1,57 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryptedFields(t *testing.T) {
+	Convey("Testing encrypted fields", t, func() {
+		Convey("Encryption should be deterministic and reversible", func() {
+			key := newEncryptionKey([]byte("another-test-key-0123456789abcde"))
+			enc := key.encrypt("my secret")
+			So(enc, ShouldStartWith, key.id+":")
+			So(enc, ShouldNotContainSubstring, "my secret")
+			So(key.encrypt("my secret"), ShouldEqual, enc)
+			So(key.encrypt("my other secret"), ShouldNotEqual, enc)
+			dec, err := key.decrypt(enc[9:])
+			So(err, ShouldBeNil)
+			So(dec, ShouldEqual, "my secret")
+			So(decryptString("not encrypted"), ShouldEqual, "not encrypted")
+			So(hideEncryptedArgs([]interface{}{"clear", encryptString("my secret")}), ShouldResemble,
+				[]interface{}{"clear", encryptedValueMask})
+		})
+		Convey("Only string fields can be encrypted", func() {
+			So(func() { Registry.MustGet("Profile").Fields().MustGet("Age").SetEncrypted(true) }, ShouldPanic)
+			Registry.MustGet("Profile").Fields().MustGet("Age").SetEncrypted(false)
+		})
+		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profiles := env.Pool("Profile")
+			profile := profiles.Call("Create", FieldMap{"Secret": "my secret", "City": "Paris"}).(RecordSet).Collection()
+			Convey("Encrypted values should be stored encrypted and read in clear", func() {
+				var dbValue string
+				env.cr.Get(&dbValue, "SELECT secret FROM profile WHERE id = ?", profile.Ids()[0])
+				So(dbValue, ShouldNotEqual, "my secret")
+				So(decryptString(dbValue), ShouldEqual, "my secret")
+				env.cache.invalidateRecord(profile.model, profile.Ids()[0])
+				So(profile.Get("Secret"), ShouldEqual, "my secret")
+			})
+			Convey("Encrypted fields can be searched for equality only", func() {
+				res := profiles.Search(profiles.Model().Field("Secret").Equals("my secret"))
+				So(res.Ids(), ShouldResemble, profile.Ids())
+				res = profiles.Search(profiles.Model().Field("Secret").In([]string{"foo", "my secret"}))
+				So(res.Ids(), ShouldResemble, profile.Ids())
+				res = profiles.Search(profiles.Model().Field("Secret").Equals("my other secret"))
+				So(res.IsEmpty(), ShouldBeTrue)
+				So(func() {
+					profiles.Search(profiles.Model().Field("Secret").Contains("secret")).Fetch()
+				}, ShouldPanic)
+			})
+		})
+	})
+}
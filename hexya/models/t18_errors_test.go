@@ -0,0 +1,87 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrors(t *testing.T) {
+	Convey("Testing models errors", t, func() {
+		Convey("User warnings should be returned as is with debug data", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				panic(NewUserWarning(env.Pool("User").T("Something is wrong"), "key", "value"))
+			})
+			So(err, ShouldHaveSameTypeAs, UserWarning{})
+			mErr := err.(Error)
+			So(mErr.UserMessage(), ShouldEqual, "Something is wrong")
+			So(mErr.Error(), ShouldEqual, "Something is wrong, [key value]")
+			So(mErr.Debug(), ShouldStartWith, "Something is wrong, [key value]")
+			So(len(mErr.Debug()), ShouldBeGreaterThan, len(mErr.Error()))
+		})
+		Convey("SQL constraint violations should be validation errors", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("User").Call("Create", FieldMap{
+					"Name":      "Premium Error",
+					"Email":     "premium.error@example.com",
+					"IsPremium": true,
+					"Nums":      0,
+				})
+			})
+			So(err, ShouldHaveSameTypeAs, ValidationError{})
+			So(err.(Error).UserMessage(), ShouldEqual, "Premium users must have positive nums")
+			err = SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("User").Call("Create", FieldMap{"Name": "Duplicate Error", "Email": "dup1@example.com"})
+				env.Pool("User").Call("Create", FieldMap{"Name": "Duplicate Error", "Email": "dup2@example.com"})
+			})
+			So(err, ShouldHaveSameTypeAs, ValidationError{})
+			So(err.(Error).UserMessage(), ShouldNotContainSubstring, "pq:")
+			So(err.(Error).Debug(), ShouldContainSubstring, "pq:")
+		})
+		Convey("Writing on non existent records should be a missing error", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				Registry.MustGet("User").Browse(env, []int64{999999}).Call("Write", FieldMap{"Name": "Nobody"})
+			})
+			So(err, ShouldHaveSameTypeAs, MissingError{})
+		})
+		Convey("Writing on empty record sets should not fail", func() {
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				users := env.Pool("User").Search(env.Pool("User").Model().Field("Name").Equals("Nobody"))
+				users.Call("Write", FieldMap{"Nums": 3})
+			})
+			So(err, ShouldBeNil)
+		})
+		Convey("Writing on records filtered out by record rules should be an access error", func() {
+			userModel := Registry.MustGet("User")
+			userModel.AddRecordRule(&RecordRule{
+				Name:      "writeNobody",
+				Global:    true,
+				Condition: userModel.Field("Name").Equals("Nobody"),
+				Perms:     security.Write,
+			})
+			err := SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				users := env.Pool("User").FetchAll()
+				So(users.Len(), ShouldBeGreaterThan, 0)
+				users.Call("Write", FieldMap{"Nums": 3})
+			})
+			userModel.RemoveRecordRule("writeNobody")
+			So(err, ShouldHaveSameTypeAs, AccessError{})
+			err = SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				users := env.Pool("User").FetchAll()
+				Registry.MustGet("User").Browse(env, append(users.Ids(), 999999)).Call("Write", FieldMap{"Nums": 3})
+			})
+			So(err, ShouldHaveSameTypeAs, MissingError{})
+		})
+		Convey("Unauthorized method calls should be access errors", func() {
+			err := SimulateInNewEnvironment(99, func(env Environment) {
+				env.Pool("Tag").Call("Unlink")
+			})
+			So(err, ShouldHaveSameTypeAs, AccessError{})
+			So(err.(Error).UserMessage(), ShouldEqual, "You are not allowed to execute method Unlink on Tag")
+		})
+	})
+}
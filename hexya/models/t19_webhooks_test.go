@@ -0,0 +1,194 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A webhookStub is a local HTTP server recording the webhook requests it receives
+type webhookStub struct {
+	sync.Mutex
+	*httptest.Server
+	payloads   map[WebhookEvent]WebhookPayload
+	signatures map[WebhookEvent]string
+	bodies     map[WebhookEvent][]byte
+	failures   int
+}
+
+// newWebhookStub returns a started webhookStub which answers
+// the first failures requests with a 500 status.
+func newWebhookStub(failures int) *webhookStub {
+	stub := &webhookStub{
+		payloads:   make(map[WebhookEvent]WebhookPayload),
+		signatures: make(map[WebhookEvent]string),
+		bodies:     make(map[WebhookEvent][]byte),
+		failures:   failures,
+	}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.Lock()
+		defer stub.Unlock()
+		if stub.failures > 0 {
+			stub.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var payload WebhookPayload
+		json.Unmarshal(body, &payload)
+		stub.payloads[payload.Event] = payload
+		stub.signatures[payload.Event] = r.Header.Get(WebhookSignatureHeader)
+		stub.bodies[payload.Event] = body
+	}))
+	return stub
+}
+
+func TestWebhooks(t *testing.T) {
+	Convey("Testing webhooks", t, func() {
+		maxAttempts, retryDelay := Webhooks.retryPolicy()
+		Webhooks.SetRetryPolicy(3, 10*time.Millisecond)
+		defer Webhooks.SetRetryPolicy(maxAttempts, retryDelay)
+		Convey("Registering webhooks", func() {
+			_, err := Webhooks.Register(2, Webhook{Model: "Tag", Event: WebhookCreate, URL: "http://localhost"})
+			So(err, ShouldHaveSameTypeAs, AccessError{})
+			_, err = Webhooks.Register(security.SuperUserID, Webhook{Model: "NoModel", Event: WebhookCreate, URL: "http://localhost"})
+			So(err, ShouldNotBeNil)
+			_, err = Webhooks.Register(security.SuperUserID, Webhook{Model: webhookDeliveryModelName, Event: WebhookCreate, URL: "http://localhost"})
+			So(err, ShouldNotBeNil)
+			_, err = Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookWrite, Fields: []string{"NoField"}, URL: "http://localhost"})
+			So(err, ShouldNotBeNil)
+			_, err = Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookCreate, URL: "ftp://localhost"})
+			So(err, ShouldNotBeNil)
+			hook, err := Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookWrite, Fields: []string{"Rate"}, URL: "http://localhost"})
+			So(err, ShouldBeNil)
+			So(hook.ID, ShouldNotEqual, 0)
+			So(hook.Secret, ShouldNotBeBlank)
+			So(hook.Fields, ShouldResemble, []string{"rate"})
+			Webhooks.Unregister(hook.ID)
+		})
+		Convey("Webhooks should be delivered after commit only", func() {
+			stub := newWebhookStub(0)
+			defer stub.Close()
+			createHook, _ := Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookCreate, URL: stub.URL})
+			writeHook, _ := Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookWrite, Fields: []string{"Rate"}, URL: stub.URL})
+			unlinkHook, _ := Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookUnlink, URL: stub.URL})
+			defer Webhooks.Unregister(createHook.ID)
+			defer Webhooks.Unregister(writeHook.ID)
+			defer Webhooks.Unregister(unlinkHook.ID)
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Rolled Back Tag"})
+			})
+			var tagID int64
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Webhook Tag"}).(RecordCollection)
+				tagID = tag.Ids()[0]
+				tag.Call("Write", FieldMap{"Description": "Not watched"})
+				tag.Call("Write", FieldMap{"Rate": 5})
+				tag.Call("Unlink")
+			})
+			Webhooks.Wait()
+			So(stub.payloads, ShouldHaveLength, 3)
+			So(stub.payloads[WebhookCreate].Model, ShouldEqual, "Tag")
+			So(stub.payloads[WebhookCreate].IDs, ShouldResemble, []int64{tagID})
+			So(stub.payloads[WebhookWrite].Fields, ShouldContain, "rate")
+			So(stub.payloads[WebhookWrite].Fields, ShouldNotContain, "description")
+			So(stub.payloads[WebhookUnlink].IDs, ShouldResemble, []int64{tagID})
+			createBody, createSignature := stub.bodies[WebhookCreate], stub.signatures[WebhookCreate]
+			So(VerifyWebhookSignature(createHook.Secret, createBody, createSignature), ShouldBeTrue)
+			So(VerifyWebhookSignature(writeHook.Secret, createBody, createSignature), ShouldBeFalse)
+			deliveries := Webhooks.Deliveries(createHook.ID)
+			So(deliveries, ShouldHaveLength, 1)
+			So(deliveries[0].Delivered, ShouldBeTrue)
+			So(deliveries[0].Attempts, ShouldEqual, 1)
+			So(deliveries[0].Status, ShouldEqual, http.StatusOK)
+		})
+		Convey("Failed deliveries should be retried", func() {
+			stub := newWebhookStub(2)
+			defer stub.Close()
+			hook, _ := Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookUnlink, URL: stub.URL})
+			defer Webhooks.Unregister(hook.ID)
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Retried Tag"}).(RecordCollection)
+				tag.Call("Unlink")
+			})
+			Webhooks.Wait()
+			So(stub.payloads, ShouldHaveLength, 1)
+			deliveries := Webhooks.Deliveries(hook.ID)
+			So(deliveries, ShouldHaveLength, 1)
+			So(deliveries[0].Delivered, ShouldBeTrue)
+			So(deliveries[0].Attempts, ShouldEqual, 3)
+		})
+		Convey("Deliveries should stop after the maximum number of attempts", func() {
+			stub := newWebhookStub(5)
+			defer stub.Close()
+			hook, _ := Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookUnlink, URL: stub.URL})
+			defer Webhooks.Unregister(hook.ID)
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Failed Tag"}).(RecordCollection)
+				tag.Call("Unlink")
+			})
+			Webhooks.Wait()
+			So(stub.payloads, ShouldBeEmpty)
+			deliveries := Webhooks.Deliveries(hook.ID)
+			So(deliveries, ShouldHaveLength, 1)
+			So(deliveries[0].Delivered, ShouldBeFalse)
+			So(deliveries[0].Attempts, ShouldEqual, 3)
+			So(deliveries[0].Status, ShouldEqual, http.StatusInternalServerError)
+		})
+		Convey("Recomputing fields should not trigger webhooks", func() {
+			hook, _ := Webhooks.Register(security.SuperUserID, Webhook{Model: "User", Event: WebhookWrite, Fields: []string{"Age"}, URL: "http://localhost"})
+			defer Webhooks.Unregister(hook.ID)
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.cr.Execute(`UPDATE "user" SET age = 99`)
+				env.Pool("User").FetchAll().Recompute(10, FieldName("Age"))
+				jobs := env.Pool(jobModelName)
+				So(jobs.Search(jobs.Model().Field("Channel").Equals(WebhookJobChannel)).SearchCount(), ShouldEqual, 0)
+			})
+		})
+		Convey("Pending deliveries should be stored as jobs", func() {
+			stub := newWebhookStub(0)
+			defer stub.Close()
+			hook, _ := Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookCreate, URL: stub.URL})
+			defer Webhooks.Unregister(hook.ID)
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Queued Tag"})
+			})
+			So(Jobs.hasPending(WebhookJobChannel), ShouldBeTrue)
+			So(stub.payloads, ShouldBeEmpty)
+			Webhooks.Wait()
+			So(Jobs.hasPending(WebhookJobChannel), ShouldBeFalse)
+			So(stub.payloads, ShouldHaveLength, 1)
+		})
+		Convey("Webhooks should be stored in the database", func() {
+			stub := newWebhookStub(0)
+			defer stub.Close()
+			hook, _ := Webhooks.Register(security.SuperUserID, Webhook{Model: "Tag", Event: WebhookCreate, Fields: []string{"Rate"}, URL: stub.URL})
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				stored, ok := modelWebhookStore{}.Get(env, hook.ID)
+				So(ok, ShouldBeTrue)
+				So(stored, ShouldResemble, hook)
+			})
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Unregistered Tag"})
+			})
+			Webhooks.Unregister(hook.ID)
+			Webhooks.Wait()
+			So(stub.payloads, ShouldBeEmpty)
+			So(Webhooks.Deliveries(hook.ID), ShouldBeEmpty)
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				_, ok := modelWebhookStore{}.Get(env, hook.ID)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
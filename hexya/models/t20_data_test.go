@@ -115,5 +115,15 @@ func TestDataLoading(t *testing.T) {
 				So(nickPost.Get("Tags").(RecordCollection).Len(), ShouldEqual, 3)
 			})
 		})
+		Convey("Invalid lines should be skipped", func() {
+			LoadCSVDataFile("testdata/Tag_invalid.csv")
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tagModel := Registry.MustGet("Tag")
+				tags := env.Pool("Tag").Search(tagModel.Field("HexyaExternalID").Like("tag_%valid"))
+				So(tags.Len(), ShouldEqual, 2)
+				So(env.Pool("Tag").Search(tagModel.Field("HexyaExternalID").In([]string{"tag_bad_rate", "tag_out_of_range"})).Len(), ShouldEqual, 0)
+				tags.Call("Unlink")
+			})
+		})
 	})
 }
@@ -0,0 +1,113 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJobs(t *testing.T) {
+	Convey("Testing background jobs", t, func() {
+		maxAttempts, retryDelay := Jobs.maxAttempts, Jobs.retryDelay
+		Jobs.SetRetryPolicy(3, 0)
+		defer Jobs.SetRetryPolicy(int(maxAttempts), retryDelay)
+		var tagIDs []int64
+		ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagIDs = env.Pool("Tag").Call("Create", FieldMap{"Name": "Job Tag", "Rate": 2}).(RecordCollection).Ids()
+		})
+		tagRate := func() float32 {
+			var rate float32
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				rate = Registry.MustGet("Tag").Browse(env, tagIDs).Get("Rate").(float32)
+			})
+			return rate
+		}
+		Reset(func() {
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				Registry.MustGet("Tag").Browse(env, tagIDs).Call("Unlink")
+			})
+		})
+		Convey("Jobs should be executed only if their transaction is committed", func() {
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				Registry.MustGet("Tag").Browse(env, tagIDs).Delay().Call("Write", FieldMap{"Rate": 9})
+			})
+			So(Jobs.RunPending(DefaultJobChannel), ShouldEqual, 0)
+			var jobID int64
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				jobID = Registry.MustGet("Tag").Browse(env, tagIDs).Delay().Call("Write", FieldMap{"Rate": 7})
+				So(jobID, ShouldNotEqual, 0)
+			})
+			job, ok := Jobs.Job(jobID)
+			So(ok, ShouldBeTrue)
+			So(job.State, ShouldEqual, JobPending)
+			So(job.Model, ShouldEqual, "Tag")
+			So(job.Method, ShouldEqual, "Write")
+			So(job.IDs, ShouldResemble, tagIDs)
+			So(job.UID, ShouldEqual, security.SuperUserID)
+			So(Jobs.RunPending(DefaultJobChannel), ShouldEqual, 1)
+			job, _ = Jobs.Job(jobID)
+			So(job.State, ShouldEqual, JobDone)
+			So(job.Attempts, ShouldEqual, 1)
+			var res bool
+			So(json.Unmarshal(job.Result, &res), ShouldBeNil)
+			So(res, ShouldBeTrue)
+			So(tagRate(), ShouldEqual, 7)
+		})
+		Convey("Failed jobs should be retried up to their maximum attempts", func() {
+			var jobID int64
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				jobID = Registry.MustGet("Tag").Browse(env, tagIDs).Delay().MaxAttempts(2).Call("Write", FieldMap{"Rate": 12})
+			})
+			So(Jobs.RunPending(DefaultJobChannel), ShouldEqual, 2)
+			job, _ := Jobs.Job(jobID)
+			So(job.State, ShouldEqual, JobFailed)
+			So(job.Attempts, ShouldEqual, 2)
+			So(job.Error, ShouldContainSubstring, "Tag rate must be between 0 and 10")
+			So(tagRate(), ShouldEqual, 2)
+		})
+		Convey("Jobs should only be run by their channel and after their ETA", func() {
+			var otherID, laterID int64
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				rs := Registry.MustGet("Tag").Browse(env, tagIDs)
+				otherID = rs.Delay().Channel("other").Call("Write", FieldMap{"Rate": 4})
+				laterID = rs.Delay().After(time.Hour).Call("Write", FieldMap{"Rate": 5})
+			})
+			So(Jobs.RunPending(DefaultJobChannel), ShouldEqual, 0)
+			So(Jobs.RunPending("other"), ShouldEqual, 1)
+			job, _ := Jobs.Job(otherID)
+			So(job.State, ShouldEqual, JobDone)
+			job, _ = Jobs.Job(laterID)
+			So(job.State, ShouldEqual, JobPending)
+			So(tagRate(), ShouldEqual, 4)
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				Registry.MustGet(jobModelName).Browse(env, []int64{laterID}).Call("Unlink")
+			})
+			_, ok := Jobs.Job(laterID)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Workers should process jobs in the background", func() {
+			Jobs.SetPollInterval(10 * time.Millisecond)
+			Jobs.Start()
+			var jobID int64
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				jobID = Registry.MustGet("Tag").Browse(env, tagIDs).Delay().Call("Write", FieldMap{"Rate": 3})
+			})
+			var job Job
+			for i := 0; i < 100; i++ {
+				if job, _ = Jobs.Job(jobID); job.State != JobPending {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			Jobs.Stop()
+			So(job.State, ShouldEqual, JobDone)
+			So(tagRate(), ShouldEqual, 3)
+		})
+	})
+}
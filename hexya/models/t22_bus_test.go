@@ -0,0 +1,67 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// receiveChange returns the next RecordChange of sub and true,
+// or false if none is received within a short delay.
+func receiveChange(sub *BusSubscription) (RecordChange, bool) {
+	select {
+	case change := <-sub.C:
+		return change, true
+	case <-time.After(500 * time.Millisecond):
+		return RecordChange{}, false
+	}
+}
+
+func TestBus(t *testing.T) {
+	Convey("Testing the notification bus", t, func() {
+		Bus.Start()
+		adminSub := Bus.Subscribe(security.SuperUserID)
+		otherSub := Bus.Subscribe(99)
+		defer Bus.Unsubscribe(adminSub)
+		defer Bus.Unsubscribe(otherSub)
+		Convey("Rolled back changes should not be published", func() {
+			SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Rolled Back Bus Tag"})
+			})
+			_, ok := receiveChange(adminSub)
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Committed changes should be published to allowed users", func() {
+			var tagID int64
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Bus Tag"}).(RecordCollection)
+				tagID = tag.Ids()[0]
+			})
+			change, ok := receiveChange(adminSub)
+			So(ok, ShouldBeTrue)
+			So(change.Model, ShouldEqual, "Tag")
+			So(change.Event, ShouldEqual, WebhookCreate)
+			So(change.IDs, ShouldResemble, []int64{tagID})
+			ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				tag := Registry.MustGet("Tag").Browse(env, []int64{tagID})
+				tag.Call("Write", FieldMap{"Rate": 3})
+				tag.Call("Unlink")
+			})
+			change, ok = receiveChange(adminSub)
+			So(ok, ShouldBeTrue)
+			So(change.Event, ShouldEqual, WebhookWrite)
+			So(change.Fields, ShouldContain, "rate")
+			change, ok = receiveChange(adminSub)
+			So(ok, ShouldBeTrue)
+			So(change.Event, ShouldEqual, WebhookUnlink)
+			So(change.IDs, ShouldResemble, []int64{tagID})
+			_, ok = receiveChange(otherSub)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTextSearch(t *testing.T) {
+	Convey("Testing full text search", t, func() {
+		Convey("Only clear string fields can have full text search", func() {
+			So(func() { Registry.MustGet("Profile").Fields().MustGet("Age").SetFullTextSearch(true) }, ShouldPanic)
+			Registry.MustGet("Profile").Fields().MustGet("Age").SetFullTextSearch(false)
+			So(func() { Registry.MustGet("Profile").Fields().MustGet("Secret").SetFullTextSearch(true) }, ShouldPanic)
+			Registry.MustGet("Profile").Fields().MustGet("Secret").SetFullTextSearch(false)
+		})
+		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			posts := env.Pool("Post").WithContext("lang", "en_US")
+			zebras := posts.Call("Create", FieldMap{
+				"Title":   "Zebras",
+				"Content": "<p>Zebras are running in the savannah</p>",
+			}).(RecordSet).Collection()
+			lions := posts.Call("Create", FieldMap{
+				"Title":   "Lions",
+				"Content": "<p>Lions run after zebras, and zebras run away from lions</p>",
+			}).(RecordSet).Collection()
+			Convey("Text search vectors should be stored with the field language", func() {
+				var vector string
+				env.cr.Get(&vector, "SELECT content_tsv FROM post WHERE id = ?", zebras.Ids()[0])
+				So(vector, ShouldContainSubstring, "'zebra'")
+				So(vector, ShouldContainSubstring, "'run'")
+				frPost := env.Pool("Post").WithContext("lang", "fr_FR").Call("Create", FieldMap{
+					"Title":   "Elephants",
+					"Content": "<p>Elephants are running to the river</p>",
+				}).(RecordSet).Collection()
+				env.cr.Get(&vector, "SELECT content_tsv FROM post WHERE id = ?", frPost.Ids()[0])
+				So(vector, ShouldContainSubstring, "'river'")
+				So(vector, ShouldContainSubstring, "'run'")
+			})
+			Convey("Matches should use the field language whatever the context language", func() {
+				frPosts := env.Pool("Post").WithContext("lang", "fr_FR")
+				res := frPosts.Search(frPosts.Model().Field("Content").Matches("running zebra"))
+				So(res.Len(), ShouldEqual, 2)
+			})
+			Convey("Matches should find records by their stemmed words", func() {
+				res := posts.Search(posts.Model().Field("Content").Matches("running zebra"))
+				So(res.Len(), ShouldEqual, 2)
+				res = posts.Search(posts.Model().Field("Content").Matches("savannah"))
+				So(res.Ids(), ShouldResemble, zebras.Ids())
+				res = posts.Search(posts.Model().Field("Content").Matches("tiger"))
+				So(res.IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Records should be ordered by text search rank", func() {
+				res := posts.Search(posts.Model().Field("Content").Matches("zebra")).OrderBy("Content:rank desc", "ID")
+				So(res.Ids(), ShouldResemble, []int64{lions.Ids()[0], zebras.Ids()[0]})
+				res = posts.Search(posts.Model().Field("Content").Matches("zebra")).OrderBy("Content:rank", "ID")
+				So(res.Ids(), ShouldResemble, []int64{zebras.Ids()[0], lions.Ids()[0]})
+			})
+			Convey("Text search vectors should be updated on write", func() {
+				zebras.Set("Content", "Giraffes eat leaves")
+				res := posts.Search(posts.Model().Field("Content").Matches("savannah"))
+				So(res.IsEmpty(), ShouldBeTrue)
+				res = posts.Search(posts.Model().Field("Content").Matches("giraffe"))
+				So(res.Ids(), ShouldResemble, zebras.Ids())
+			})
+			Convey("Full text search SQL", func() {
+				if dbArgs.Driver == "postgres" {
+					rs := env.Pool("Post").WithContext("lang", "fr_FR")
+					rs = rs.Search(rs.Model().Field("Content").Matches("voiture")).OrderBy("Content:rank desc")
+					sql, args := rs.query.selectQuery([]string{"Title"})
+					So(sql, ShouldEqual, `SELECT DISTINCT "post".title AS title, ts_rank("post".content_tsv, plainto_tsquery('english', ?)) AS "content:rank" FROM "post" "post"  WHERE ("post".content_tsv @@ plainto_tsquery('english', ?) )  ORDER BY "content:rank" desc `)
+					So(args, ShouldResemble, SQLParams{"voiture", "voiture"})
+				}
+			})
+			Convey("Invalid full text searches should panic", func() {
+				So(func() {
+					posts.Search(posts.Model().Field("Title").Matches("zebra")).Fetch()
+				}, ShouldPanic)
+				So(func() {
+					posts.Search(posts.Model().Field("Title").Equals("Zebras")).OrderBy("Content:rank").Fetch()
+				}, ShouldPanic)
+			})
+		})
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHierarchy(t *testing.T) {
+	Convey("Testing hierarchies", t, func() {
+		Convey("ParentPath requires a Parent field on the same model", func() {
+			So(func() { Registry.MustGet("User").AddParentPathField() }, ShouldPanic)
+		})
+		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			resources := env.Pool("Resource")
+			res1 := resources.Call("Create", FieldMap{"Name": "Root 1"}).(RecordSet).Collection()
+			res2 := resources.Call("Create", FieldMap{"Name": "Child 1", "Parent": res1}).(RecordSet).Collection()
+			res3 := resources.Call("Create", FieldMap{"Name": "Grand Child 1", "Parent": res2}).(RecordSet).Collection()
+			res4 := resources.Call("Create", FieldMap{"Name": "Root 2"}).(RecordSet).Collection()
+			Convey("ParentPath should be set on create", func() {
+				So(res1.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/", res1.Ids()[0]))
+				So(res3.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/%d/%d/", res1.Ids()[0], res2.Ids()[0], res3.Ids()[0]))
+				So(res4.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/", res4.Ids()[0]))
+			})
+			Convey("ChildOf and ParentOf should use ParentPath", func() {
+				children := resources.Search(resources.Model().Field("ID").ChildOf(res1.Ids()[0])).OrderBy("ID")
+				So(children.Ids(), ShouldResemble, []int64{res1.Ids()[0], res2.Ids()[0], res3.Ids()[0]})
+				parents := resources.Search(resources.Model().Field("ID").ParentOf(res3.Ids()[0])).OrderBy("ID")
+				So(parents.Ids(), ShouldResemble, []int64{res1.Ids()[0], res2.Ids()[0], res3.Ids()[0]})
+				parents = resources.Search(resources.Model().Field("ID").ParentOf(res2.Ids()[0])).OrderBy("ID")
+				So(parents.Ids(), ShouldResemble, []int64{res1.Ids()[0], res2.Ids()[0]})
+				roots := resources.Search(resources.Model().Field("ID").ParentOf(res4.Ids()[0]))
+				So(roots.Ids(), ShouldResemble, res4.Ids())
+			})
+			Convey("Moving a record should update the ParentPath of its descendants", func() {
+				res2.Set("Parent", res4)
+				So(res2.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/%d/", res4.Ids()[0], res2.Ids()[0]))
+				So(res3.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/%d/%d/", res4.Ids()[0], res2.Ids()[0], res3.Ids()[0]))
+				children := resources.Search(resources.Model().Field("ID").ChildOf(res1.Ids()[0]))
+				So(children.Ids(), ShouldResemble, res1.Ids())
+				children = resources.Search(resources.Model().Field("ID").ChildOf(res4.Ids()[0]))
+				So(children.Len(), ShouldEqual, 3)
+				res2.Set("Parent", nil)
+				So(res3.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/%d/", res2.Ids()[0], res3.Ids()[0]))
+			})
+			Convey("ParentPath should not be writable", func() {
+				res5 := resources.Call("Create", FieldMap{"Name": "Root 3", "ParentPath": "1/"}).(RecordSet).Collection()
+				So(res5.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/", res5.Ids()[0]))
+				res3.Set("ParentPath", fmt.Sprintf("%d/", res3.Ids()[0]))
+				So(res3.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/%d/%d/", res1.Ids()[0], res2.Ids()[0], res3.Ids()[0]))
+			})
+			Convey("Records without ParentPath should not match ChildOf and ParentOf", func() {
+				env.Cr().Execute(`UPDATE resource SET parent_path = NULL WHERE id = ?`, res4.Ids()[0])
+				env.cache.invalidateRecord(res4.model, res4.Ids()[0])
+				So(resources.Search(resources.Model().Field("ID").ChildOf(res4.Ids()[0])).IsEmpty(), ShouldBeTrue)
+				So(resources.Search(resources.Model().Field("ID").ParentOf(res4.Ids()[0])).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Deleting a record should update the ParentPath of its orphaned descendants", func() {
+				res2.Call("Unlink")
+				So(res3.Get("ParentPath"), ShouldEqual, fmt.Sprintf("%d/", res3.Ids()[0]))
+				children := resources.Search(resources.Model().Field("ID").ChildOf(res1.Ids()[0]))
+				So(children.Ids(), ShouldResemble, res1.Ids())
+				parents := resources.Search(resources.Model().Field("ID").ParentOf(res3.Ids()[0]))
+				So(parents.Ids(), ShouldResemble, res3.Ids())
+				children = resources.Search(resources.Model().Field("ID").ChildOf(res3.Ids()[0]))
+				So(children.Ids(), ShouldResemble, res3.Ids())
+			})
+			Convey("Creating a loop in the hierarchy should panic", func() {
+				So(func() { res1.Set("Parent", res3) }, ShouldPanic)
+			})
+			Convey("ChildOf and ParentOf should work on relation fields", func() {
+				grandChildren := resources.Search(resources.Model().Field("Parent").ChildOf(res2.Ids()[0]))
+				So(grandChildren.Ids(), ShouldResemble, res3.Ids())
+			})
+			Convey("ParentOf should work without ParentPath", func() {
+				tags := env.Pool("Tag")
+				tag1 := tags.Call("Create", FieldMap{"Name": "Root Tag"}).(RecordSet).Collection()
+				tag2 := tags.Call("Create", FieldMap{"Name": "Child Tag", "Parent": tag1}).(RecordSet).Collection()
+				tag3 := tags.Call("Create", FieldMap{"Name": "Grand Child Tag", "Parent": tag2}).(RecordSet).Collection()
+				parents := tags.Search(tags.Model().Field("ID").ParentOf(tag3.Ids()[0])).OrderBy("ID")
+				So(parents.Ids(), ShouldResemble, []int64{tag1.Ids()[0], tag2.Ids()[0], tag3.Ids()[0]})
+				children := tags.Search(tags.Model().Field("ID").ChildOf(tag2.Ids()[0])).OrderBy("ID")
+				So(children.Ids(), ShouldResemble, []int64{tag2.Ids()[0], tag3.Ids()[0]})
+			})
+		})
+	})
+}
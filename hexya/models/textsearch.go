@@ -0,0 +1,129 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/operator"
+	"github.com/spf13/viper"
+)
+
+// TextSearchRank can be appended to a full text search field with GroupSep
+// in OrderBy expressions to order records by relevance for the text searched
+// on this field with the Matches operator, e.g. "Description:rank desc".
+const TextSearchRank = "rank"
+
+// textSearchColumnSuffix is the suffix of the name of the column
+// that holds the text search vector of a field.
+const textSearchColumnSuffix = "_tsv"
+
+// checkTextSearchField panics if the given field has the FullTextSearch
+// option but cannot be searched with full text search.
+func checkTextSearchField(fi *Field) {
+	if !fi.fullTextSearch {
+		return
+	}
+	switch fi.fieldType {
+	case fieldtype.Char, fieldtype.Text, fieldtype.HTML:
+	default:
+		log.Panic("Only char, text and html fields can have full text search", "model", fi.model.name,
+			"field", fi.name, "type", fi.fieldType)
+	}
+	if fi.encrypted {
+		log.Panic("Encrypted fields cannot have full text search", "model", fi.model.name, "field", fi.name)
+	}
+}
+
+// textSearchColumn returns the name of the column
+// that holds the text search vector of this field.
+func (f *Field) textSearchColumn() string {
+	return f.json + textSearchColumnSuffix
+}
+
+// isTextSearchColumn returns true if colName is the text search
+// vector column of a stored field of this model.
+func (m *Model) isTextSearchColumn(colName string) bool {
+	if !strings.HasSuffix(colName, textSearchColumnSuffix) {
+		return false
+	}
+	fi, ok := m.fields.registryByJSON[strings.TrimSuffix(colName, textSearchColumnSuffix)]
+	return ok && fi.fullTextSearch && fi.isStored()
+}
+
+// textSearchConfig returns the text search configuration of this field.
+//
+// The same configuration is used to compute the vectors and to query them
+// whatever the language of the context, so that all the records can be
+// found with the same stemming rules.
+func (f *Field) textSearchConfig() string {
+	lang := f.textSearchLang
+	if lang == "" {
+		lang = viper.GetString("TextSearchLanguage")
+	}
+	return adapters[db.DriverName()].textSearchConfig(lang)
+}
+
+// textSearchVectorExpression returns the sql expression of the text search
+// vector column of the field fi pointed at by exprs.
+func (q *Query) textSearchVectorExpression(exprs []string, fi *Field) string {
+	if !fi.fullTextSearch || !fi.isStored() {
+		log.Panic("Full text search is not enabled on this field", "model", q.recordSet.model,
+			"field", strings.Join(exprs, ExprSep))
+	}
+	joins := q.generateTableJoins(exprs)
+	return fmt.Sprintf("%s.%s", joins[len(joins)-1].alias, fi.textSearchColumn())
+}
+
+// textSearchRanksSQL returns the SQL string of the text search ranks to select for the
+// TextSearchRank order expressions of this query, and the searched texts as parameters.
+//
+// Ranks are selected with their groupAlias so that the ORDER BY clause can refer to them.
+func (q *Query) textSearchRanksSQL() (string, SQLParams) {
+	var (
+		res  string
+		args SQLParams
+	)
+	adapter := adapters[db.DriverName()]
+	for _, order := range q.orderExprs() {
+		path, suffix := splitGroupExpr(order.field)
+		if suffix != TextSearchRank {
+			continue
+		}
+		exprs := jsonizeExpr(q.recordSet.model, strings.Split(path, ExprSep))
+		text, ok := q.cond.textSearchText(q.recordSet.model, exprs)
+		if !ok {
+			log.Panic("Ordering by text search rank requires a Matches condition on the field",
+				"model", q.recordSet.model, "order", order.field)
+		}
+		fi := q.recordSet.model.getRelatedFieldInfo(path)
+		vector := q.textSearchVectorExpression(exprs, fi)
+		res += fmt.Sprintf(`, %s AS "%s"`, adapter.textSearchRankSQL(vector, fi.textSearchConfig()), groupAlias(q.recordSet.model, order.field))
+		args = append(args, text)
+	}
+	return res, args
+}
+
+// textSearchText returns the text searched with the Matches operator on the
+// field given by exprs (in json format) in this condition or its sub conditions.
+// The second returned value is false if there is no such predicate.
+func (c *Condition) textSearchText(mi *Model, exprs []string) (interface{}, bool) {
+	for _, p := range c.predicates {
+		if p.cond != nil {
+			if text, ok := p.cond.textSearchText(mi, exprs); ok {
+				return text, true
+			}
+			continue
+		}
+		if p.operator != operator.Matches || p.isNot {
+			continue
+		}
+		if strings.Join(jsonizeExpr(mi, p.exprs), ExprSep) == strings.Join(exprs, ExprSep) {
+			return p.arg, true
+		}
+	}
+	return nil, false
+}
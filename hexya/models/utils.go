@@ -16,10 +16,14 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hexya-erp/hexya/hexya/models/fieldtype"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
 )
 
 var (
@@ -107,6 +111,10 @@ func inflate2ManyConditions(mi *Model, cond *Condition) {
 			inflate2ManyConditions(mi, cv.cond)
 			continue
 		}
+		if cv.operator.IsSubQuery() {
+			// Sub query predicates are applied on the 2many field itself
+			continue
+		}
 		path := strings.Join(cv.exprs, ExprSep)
 		fi := mi.getRelatedFieldInfo(path)
 		switch fi.fieldType {
@@ -311,12 +319,226 @@ func ConvertToFieldNameSlice(fields []string) []FieldNamer {
 	return res
 }
 
+// splitGroupExpr returns the field path and the date granularity
+// of the given GROUP BY expression. granularity is empty if the
+// expression has none.
+func splitGroupExpr(group string) (path string, granularity string) {
+	i := strings.LastIndex(group, GroupSep)
+	if i < 0 {
+		return group, ""
+	}
+	return group[:i], group[i+1:]
+}
+
+// groupAlias returns the key of the values of the given GROUP BY or aggregate
+// expression in aggregates rows (e.g. 'profile_id__age', 'create_date:month'
+// or 'nums:avg')
+func groupAlias(m *Model, group string) string {
+	path, granularity := splitGroupExpr(group)
+	res := strings.Replace(jsonizePath(m, path), ExprSep, sqlSep, -1)
+	if granularity == "" {
+		return res
+	}
+	return res + GroupSep + granularity
+}
+
+// addDateGranularity returns the start of the period following
+// the one of the given granularity that starts at t.
+func addDateGranularity(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case GroupByDay:
+		return t.AddDate(0, 0, 1)
+	case GroupByWeek:
+		return t.AddDate(0, 0, 7)
+	case GroupByMonth:
+		return t.AddDate(0, 1, 0)
+	case GroupByQuarter:
+		return t.AddDate(0, 3, 0)
+	case GroupByYear:
+		return t.AddDate(1, 0, 0)
+	}
+	log.Panic("Unknown date granularity", "granularity", granularity)
+	return t
+}
+
+// convertGroupDates converts in place the values of vals that are grouped
+// by date granularity into dates.Date or into dates.DateTime in loc.
+func convertGroupDates(m *Model, groups []string, vals map[string]interface{}, loc *time.Location) {
+	for _, group := range groups {
+		path, granularity := splitGroupExpr(group)
+		if granularity == "" {
+			continue
+		}
+		alias := groupAlias(m, group)
+		t, ok := vals[alias].(time.Time)
+		if !ok {
+			continue
+		}
+		if m.getRelatedFieldInfo(path).fieldType == fieldtype.Date {
+			vals[alias] = dates.Date{Time: time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}
+			continue
+		}
+		vals[alias] = dates.DateTime{Time: time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)}
+	}
+}
+
+// checkAggregateExpr panics if the given aggregate expression
+// (e.g. 'Profile.Age:avg') is not valid on model m.
+func checkAggregateExpr(m *Model, expr string) {
+	path, fnct := splitGroupExpr(expr)
+	fTypes, ok := aggregateFieldTypes[fnct]
+	if !ok {
+		log.Panic("Unknown aggregate function", "model", m.name, "expr", expr)
+	}
+	fi := m.getRelatedFieldInfo(path)
+	if !fi.isStored() {
+		log.Panic("Aggregated fields must be stored", "model", m.name, "expr", expr)
+	}
+	if fTypes != nil && !fTypes[fi.fieldType] {
+		log.Panic("Aggregate function cannot be applied on this field type", "model", m.name, "expr", expr, "type", fi.fieldType)
+	}
+}
+
+// checkHavingCondition panics if an aggregate expression of the
+// given HAVING condition is not valid on model m.
+func checkHavingCondition(m *Model, cond *Condition) {
+	for _, p := range cond.predicates {
+		if p.isCond {
+			checkHavingCondition(m, p.cond)
+			continue
+		}
+		if _, fnct := p.splitAggregate(); fnct != "" {
+			checkAggregateExpr(m, strings.Join(p.exprs, ExprSep))
+		}
+	}
+}
+
+// An orderExpr is a parsed ORDER BY expression
+type orderExpr struct {
+	// field is the field path of the expression, which can be suffixed with GroupSep
+	// and a date granularity, an aggregate function or TextSearchRank.
+	field string
+	// direction is "asc", "desc" or empty
+	direction string
+	// nulls is "first", "last" or empty
+	nulls string
+}
+
+// parseOrderExpr parses the given ORDER BY expression of the form
+// 'Field [asc|desc] [nulls first|last]' and panics if it is not valid on model m.
+func parseOrderExpr(m *Model, order string) orderExpr {
+	res := splitOrderExpr(m, order)
+	path, suffix := splitGroupExpr(res.field)
+	fi := m.getRelatedFieldInfo(path)
+	if !fi.isStored() {
+		log.Panic("Order expressions must be on stored fields", "model", m.name, "order", order)
+	}
+	switch {
+	case suffix == "", suffix == TextSearchRank:
+	case groupGranularities[suffix]:
+		if fi.fieldType != fieldtype.Date && fi.fieldType != fieldtype.DateTime {
+			log.Panic("Date granularities can only be applied on date fields", "model", m.name, "order", order)
+		}
+	default:
+		checkAggregateExpr(m, res.field)
+	}
+	return res
+}
+
+// splitOrderExpr splits the given ORDER BY expression of the form
+// 'Field [asc|desc] [nulls first|last]' of model m into an orderExpr.
+// It panics if the expression syntax is not valid, but does not check
+// its field path, which may not be declared yet.
+func splitOrderExpr(m *Model, order string) orderExpr {
+	tokens := strings.Fields(order)
+	if len(tokens) == 0 {
+		log.Panic("Empty order expression", "model", m.name)
+	}
+	res := orderExpr{field: tokens[0]}
+	tokens = tokens[1:]
+	if len(tokens) > 0 {
+		switch dir := strings.ToLower(tokens[0]); dir {
+		case "asc", "desc":
+			res.direction = dir
+			tokens = tokens[1:]
+		}
+	}
+	if len(tokens) == 2 && strings.ToLower(tokens[0]) == "nulls" {
+		switch pos := strings.ToLower(tokens[1]); pos {
+		case "first", "last":
+			res.nulls = pos
+			tokens = nil
+		}
+	}
+	if len(tokens) > 0 {
+		log.Panic("Invalid order expression", "model", m.name, "order", order)
+	}
+	return res
+}
+
+// convertAggregates converts in place the values of the given aggregate
+// expressions in vals to the Go types of their results:
+// - float64 for numeric values returned as strings by the database
+// - string for Char values
+// - []int64 for arrays
+// - dates.Date and dates.DateTime for dates
+func convertAggregates(m *Model, aggregates []string, vals map[string]interface{}) {
+	for _, expr := range aggregates {
+		alias := groupAlias(m, expr)
+		path, fnct := splitGroupExpr(expr)
+		fi := m.getRelatedFieldInfo(path)
+		switch val := vals[alias].(type) {
+		case []byte, string:
+			strVal := fmt.Sprintf("%s", val)
+			switch {
+			case fnct == AggregateArray:
+				ids := make([]int64, 0)
+				for _, idStr := range strings.Split(strVal, ",") {
+					if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+						ids = append(ids, id)
+					}
+				}
+				vals[alias] = ids
+			case fi.fieldType == fieldtype.Char:
+				vals[alias] = strVal
+			default:
+				vals[alias], _ = strconv.ParseFloat(strVal, 64)
+			}
+		case time.Time:
+			if fi.fieldType == fieldtype.Date {
+				vals[alias] = dates.Date{Time: val}
+				continue
+			}
+			vals[alias] = dates.DateTime{Time: val}
+		}
+	}
+}
+
 // getGroupCondition returns the condition to retrieve the individual aggregated rows in vals
-// knowing that they were grouped by groups and that we had the given initial condition
-func getGroupCondition(groups []string, vals map[string]interface{}, initialCondition *Condition) *Condition {
+// knowing that they were grouped by groups and that we had the given initial condition.
+//
+// Groups with a date granularity give the date range of the period, vals being
+// converted beforehand by convertGroupDates.
+func getGroupCondition(m *Model, groups []string, vals map[string]interface{}, initialCondition *Condition) *Condition {
 	res := initialCondition
 	for _, group := range groups {
-		res = res.And().Field(group).Equals(vals[group])
+		path, granularity := splitGroupExpr(group)
+		val := vals[groupAlias(m, group)]
+		switch {
+		case granularity == "" || val == nil:
+			res = res.And().Field(path).Equals(val)
+		case m.getRelatedFieldInfo(path).fieldType == fieldtype.Date:
+			start := val.(dates.Date).Time
+			end := addDateGranularity(start, granularity)
+			res = res.And().Field(path).GreaterOrEqual(dates.Date{Time: start}).
+				And().Field(path).Lower(dates.Date{Time: end})
+		default:
+			// DateTime values are stored in UTC
+			start := val.(dates.DateTime).Time
+			end := addDateGranularity(start, granularity)
+			res = res.And().Field(path).GreaterOrEqual(dates.DateTime{Time: start.UTC()}).
+				And().Field(path).Lower(dates.DateTime{Time: end.UTC()})
+		}
 	}
 	return res
 }
@@ -363,9 +585,19 @@ func consumeAndPredicates(i int, predicates []predicate, res []interface{}) ([]i
 // appendPredicateToSerial appends the given predicate to the given serialized
 // predicate list and returns the result.
 func appendPredicateToSerial(res []interface{}, predicate predicate) []interface{} {
-	if predicate.isCond {
+	switch {
+	case predicate.isCond:
 		res = append(res, serializePredicates(predicate.cond.predicates)...)
-	} else {
+	case predicate.operator.IsSubQuery():
+		var subDomain []interface{}
+		if cond, ok := predicate.arg.(*Condition); ok {
+			subDomain = cond.Serialize()
+		}
+		if subDomain == nil {
+			subDomain = []interface{}{}
+		}
+		res = append(res, []interface{}{strings.Join(predicate.exprs, ExprSep), predicate.operator, subDomain})
+	default:
 		res = append(res, []interface{}{strings.Join(predicate.exprs, ExprSep), predicate.operator, predicate.arg})
 	}
 	return res
@@ -0,0 +1,409 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+)
+
+// HTTP headers of webhook requests
+const (
+	// WebhookSignatureHeader holds the HMAC-SHA256 signature of the
+	// request body with the webhook secret, as "sha256=<hex digest>".
+	WebhookSignatureHeader = "X-Hexya-Signature"
+	// WebhookEventHeader holds the event as "<model>.<event>"
+	WebhookEventHeader = "X-Hexya-Event"
+	// WebhookDeliveryHeader holds the id of the delivery. It is the
+	// same for all attempts of a delivery.
+	WebhookDeliveryHeader = "X-Hexya-Delivery"
+)
+
+const (
+	// WebhookJobChannel is the job channel in which webhook deliveries are processed
+	WebhookJobChannel = "webhooks"
+	// webhookJobMethod is the method of the job model that delivers webhooks
+	webhookJobMethod = "deliverWebhook"
+)
+
+// Webhooks is the webhooks registry of the application
+var Webhooks *WebhookRegistry
+
+// A WebhookEvent is a record event that triggers webhooks
+type WebhookEvent string
+
+// Webhook events
+const (
+	WebhookCreate WebhookEvent = "create"
+	WebhookWrite  WebhookEvent = "write"
+	WebhookUnlink WebhookEvent = "unlink"
+)
+
+// A Webhook notifies an external URL of the events on the records of a model.
+type Webhook struct {
+	ID    int64
+	Model string
+	Event WebhookEvent
+	// Fields restricts write webhooks to updates of at least one of these
+	// fields. If empty, all updates trigger the webhook.
+	Fields []string
+	URL    string
+	// Secret is the key of the HMAC signature of the requests
+	Secret string
+}
+
+// triggeredBy returns true if this webhook is triggered by the given
+// event on its model with the given updated fields.
+func (wh Webhook) triggeredBy(event WebhookEvent, fields []string) bool {
+	if wh.Event != event {
+		return false
+	}
+	if event != WebhookWrite || len(wh.Fields) == 0 {
+		return true
+	}
+	for _, f := range wh.Fields {
+		for _, updated := range fields {
+			if f == updated {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A WebhookPayload is the JSON body sent to webhooks URLs
+type WebhookPayload struct {
+	Model string       `json:"model"`
+	Event WebhookEvent `json:"event"`
+	IDs   []int64      `json:"ids"`
+	// Fields are the updated fields JSON names of a write event
+	Fields []string  `json:"fields,omitempty"`
+	UID    int64     `json:"uid"`
+	Time   time.Time `json:"time"`
+}
+
+// A WebhookDelivery is the log of the delivery of a payload to a webhook URL
+type WebhookDelivery struct {
+	ID        int64
+	WebhookID int64
+	Payload   []byte
+	Attempts  int
+	// Status is the HTTP status of the last attempt or 0 if
+	// there has been no response.
+	Status    int
+	Error     string
+	Delivered bool
+	CreatedAt time.Time
+	// LastAttempt is the time of the last attempt
+	LastAttempt time.Time
+}
+
+// A WebhookStore persists webhooks and their delivery log.
+//
+// Each method is called with the Environment of the transaction in which
+// the data must be read or written. The default store keeps webhooks and
+// deliveries in the Webhook and WebhookDelivery system models. Modules can
+// provide their own store with WebhookRegistry.SetStore.
+type WebhookStore interface {
+	// Add saves the given new webhook and returns it with its ID set
+	Add(env Environment, hook Webhook) Webhook
+	// Remove deletes the webhook with the given ID
+	Remove(env Environment, id int64)
+	// Get returns the webhook with the given ID. The second
+	// returned value is false if there is no such webhook.
+	Get(env Environment, id int64) (Webhook, bool)
+	// ModelWebhooks returns all the webhooks of the given model
+	ModelWebhooks(env Environment, model string) []Webhook
+	// SaveDelivery creates or updates the given delivery and
+	// returns it with its ID set
+	SaveDelivery(env Environment, delivery WebhookDelivery) WebhookDelivery
+	// Delivery returns the delivery with the given ID. The second
+	// returned value is false if there is no such delivery.
+	Delivery(env Environment, id int64) (WebhookDelivery, bool)
+	// Deliveries returns the deliveries of the given webhook
+	Deliveries(env Environment, webhookID int64) []WebhookDelivery
+}
+
+// A WebhookRegistry registers webhooks and delivers their payloads.
+//
+// Each payload is delivered by a job of the WebhookJobChannel of the job
+// queue, which is enqueued in the transaction of the triggering event. Pending
+// deliveries are thus persisted in the database and only run if the
+// transaction is committed, and deliveries to different URLs are processed
+// concurrently by the workers of the channel. Failed deliveries are retried
+// with exponential backoff by new jobs.
+type WebhookRegistry struct {
+	sync.RWMutex
+	store       WebhookStore
+	client      *http.Client
+	maxAttempts int
+	retryDelay  time.Duration
+}
+
+// SetStore sets the store in which webhooks of this registry are persisted
+func (wr *WebhookRegistry) SetStore(store WebhookStore) {
+	wr.Lock()
+	defer wr.Unlock()
+	wr.store = store
+}
+
+// getStore returns the current store of this registry
+func (wr *WebhookRegistry) getStore() WebhookStore {
+	wr.RLock()
+	defer wr.RUnlock()
+	return wr.store
+}
+
+// SetRetryPolicy sets the maximum number of delivery attempts of a payload
+// and the delay before the first retry. This delay doubles after each attempt.
+func (wr *WebhookRegistry) SetRetryPolicy(maxAttempts int, retryDelay time.Duration) {
+	wr.Lock()
+	defer wr.Unlock()
+	wr.maxAttempts = maxAttempts
+	wr.retryDelay = retryDelay
+}
+
+// retryPolicy returns the maximum number of attempts and the first retry delay
+func (wr *WebhookRegistry) retryPolicy() (int, time.Duration) {
+	wr.RLock()
+	defer wr.RUnlock()
+	return wr.maxAttempts, wr.retryDelay
+}
+
+// Register registers the given webhook on behalf of the user with the given uid
+// and returns it with its ID set. If hook has no secret, a random one is generated.
+//
+// Only administrators can register webhooks. It returns an error if the
+// user is not an administrator or if hook is invalid.
+func (wr *WebhookRegistry) Register(uid int64, hook Webhook) (Webhook, error) {
+	if !security.Registry.HasMembership(uid, security.GroupAdmin) {
+		return Webhook{}, NewAccessError("Only administrators can register webhooks", "uid", uid)
+	}
+	model, ok := Registry.Get(hook.Model)
+	if !ok || model.IsMixin() || model.isSystem() {
+		return Webhook{}, fmt.Errorf("unknown model %s", hook.Model)
+	}
+	hook.Model = model.name
+	switch hook.Event {
+	case WebhookCreate, WebhookWrite, WebhookUnlink:
+	default:
+		return Webhook{}, fmt.Errorf("unknown webhook event %s", hook.Event)
+	}
+	fields := make([]string, len(hook.Fields))
+	for i, f := range hook.Fields {
+		fi, ok := model.fields.get(f)
+		if !ok {
+			return Webhook{}, fmt.Errorf("unknown field %s in model %s", f, model.name)
+		}
+		fields[i] = fi.json
+	}
+	hook.Fields = fields
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Webhook{}, fmt.Errorf("invalid webhook URL %s", hook.URL)
+	}
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Panic("Unable to generate webhook secret", "error", err)
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	var res Webhook
+	err = ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		res = wr.getStore().Add(env, hook)
+	})
+	return res, err
+}
+
+// Unregister removes the webhook with the given ID.
+// Pending deliveries of this webhook are dropped.
+func (wr *WebhookRegistry) Unregister(id int64) {
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		wr.getStore().Remove(env, id)
+	})
+	if err != nil {
+		log.Panic("Unable to unregister webhook", "webhook", id, "error", err)
+	}
+}
+
+// Deliveries returns the delivery log of the webhook with the given ID
+func (wr *WebhookRegistry) Deliveries(webhookID int64) []WebhookDelivery {
+	var res []WebhookDelivery
+	SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+		res = wr.getStore().Deliveries(env, webhookID)
+	})
+	return res
+}
+
+// Wait runs the pending deliveries of the WebhookJobChannel, including their
+// retries, until they have all either succeeded or failed after their last
+// attempt. It is mainly meant for tests and does not need the job queue
+// to be started.
+func (wr *WebhookRegistry) Wait() {
+	for Jobs.hasPending(WebhookJobChannel) {
+		if Jobs.RunPending(WebhookJobChannel) == 0 {
+			// Remaining jobs are retries that are not ready yet
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// triggered returns the webhooks of the given model that are triggered
+// by the given event with the given updated fields in the given Environment.
+func (wr *WebhookRegistry) triggered(env Environment, model string, event WebhookEvent, fields []string) []Webhook {
+	if Registry.MustGet(model).isSystem() {
+		// Webhooks cannot be registered on system models
+		return nil
+	}
+	var res []Webhook
+	for _, hook := range wr.getStore().ModelWebhooks(env, model) {
+		if hook.triggeredBy(event, fields) {
+			res = append(res, hook)
+		}
+	}
+	return res
+}
+
+// attempt makes a delivery attempt to the webhook with the given ID in the
+// given Environment. If deliveryID is 0, a new delivery of the given payload
+// is created. A new job is enqueued to retry the delivery if it failed.
+func (wr *WebhookRegistry) attempt(env Environment, hookID, deliveryID int64, payload string) {
+	store := wr.getStore()
+	hook, ok := store.Get(env, hookID)
+	if !ok {
+		log.Warn("Dropping delivery of unknown webhook", "webhook", hookID, "delivery", deliveryID)
+		return
+	}
+	delivery, ok := store.Delivery(env, deliveryID)
+	if !ok {
+		delivery = store.SaveDelivery(env, WebhookDelivery{
+			WebhookID: hookID,
+			Payload:   []byte(payload),
+			CreatedAt: time.Now(),
+		})
+	}
+	delivery.Attempts++
+	delivery.LastAttempt = time.Now()
+	delivery.Status, delivery.Error = wr.post(hook, delivery)
+	delivery.Delivered = delivery.Error == ""
+	store.SaveDelivery(env, delivery)
+	maxAttempts, retryDelay := wr.retryPolicy()
+	switch {
+	case delivery.Delivered:
+	case delivery.Attempts >= maxAttempts:
+		log.Warn("Webhook delivery failed", "webhook", hook.ID, "url", hook.URL, "delivery", delivery.ID,
+			"attempts", delivery.Attempts, "error", delivery.Error)
+	default:
+		enqueueWebhookJob(env, hook.ID, delivery.ID, payload, retryDelay<<uint(delivery.Attempts-1))
+	}
+}
+
+// enqueueWebhookJob enqueues in the given Environment a job that will attempt
+// the delivery of the given payload to the webhook with the given ID after
+// the given delay. deliveryID is the ID of the delivery to retry, or 0.
+func enqueueWebhookJob(env Environment, hookID, deliveryID int64, payload string, delay time.Duration) {
+	env.Pool(jobModelName).Sudo().Delay().
+		Channel(WebhookJobChannel).
+		After(delay).
+		MaxAttempts(1).
+		Call(webhookJobMethod, hookID, deliveryID, payload)
+}
+
+// post sends the payload of the given delivery to the URL of hook.
+// It returns the HTTP status of the response and an error message
+// if the delivery failed.
+func (wr *WebhookRegistry) post(hook Webhook, delivery WebhookDelivery) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	var payload WebhookPayload
+	json.Unmarshal(delivery.Payload, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(hook.Secret, delivery.Payload))
+	req.Header.Set(WebhookEventHeader, fmt.Sprintf("%s.%s", payload.Model, payload.Event))
+	req.Header.Set(WebhookDeliveryHeader, fmt.Sprintf("%d", delivery.ID))
+	resp, err := wr.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Sprintf("unexpected HTTP status %s", resp.Status)
+	}
+	return resp.StatusCode, ""
+}
+
+// SignWebhookPayload returns the signature of the given payload with the given
+// secret, as sent in the WebhookSignatureHeader of webhook requests.
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature returns true if signature is the valid
+// signature of the given payload with the given secret.
+func VerifyWebhookSignature(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, payload)), []byte(signature))
+}
+
+// NewWebhookRegistry returns a new WebhookRegistry which stores its
+// webhooks and deliveries in the Webhook and WebhookDelivery models.
+func NewWebhookRegistry() *WebhookRegistry {
+	return &WebhookRegistry{
+		store:       modelWebhookStore{},
+		client:      &http.Client{Timeout: 30 * time.Second},
+		maxAttempts: 8,
+		retryDelay:  10 * time.Second,
+	}
+}
+
+// enqueueWebhooks enqueues in the Environment of rc the delivery jobs of the
+// payloads of the given webhooks for the given event on the records with the
+// given ids. The payloads will be delivered once the transaction is committed.
+//
+// No payload is enqueued if notifications are disabled in the Environment.
+func (rc RecordCollection) enqueueWebhooks(hooks []Webhook, event WebhookEvent, ids []int64, fields []string) {
+	if len(ids) == 0 || len(hooks) == 0 || rc.env.noNotify {
+		return
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		Model:  rc.model.name,
+		Event:  event,
+		IDs:    ids,
+		Fields: fields,
+		UID:    rc.env.uid,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		log.Panic("Unable to marshal webhook payload", "model", rc.model.name, "event", event, "error", err)
+	}
+	for _, hook := range hooks {
+		enqueueWebhookJob(rc.env, hook.ID, 0, string(payload), 0)
+	}
+}
+
+// updatedFieldsJSON returns the sorted JSON names of the fields of fMap
+func (rc RecordCollection) updatedFieldsJSON(fMap FieldMap) []string {
+	res := make([]string, 0, len(fMap))
+	for f := range fMap {
+		res = append(res, rc.model.JSONizeFieldName(f))
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,191 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	"github.com/hexya-erp/hexya/hexya/models/types/dates"
+)
+
+const (
+	// webhookModelName is the name of the system model storing the webhooks
+	webhookModelName = "Webhook"
+	// webhookDeliveryModelName is the name of the system model storing the delivery log of the webhooks
+	webhookDeliveryModelName = "WebhookDelivery"
+)
+
+// webhookRecord holds the values of a Webhook record as stored in the database
+type webhookRecord struct {
+	ID            int64
+	RecordModel   string
+	Event         string
+	WatchedFields string
+	URL           string
+	Secret        string
+}
+
+// webhook returns the Webhook defined by this webhookRecord
+func (wr webhookRecord) webhook() Webhook {
+	res := Webhook{
+		ID:     wr.ID,
+		Model:  wr.RecordModel,
+		Event:  WebhookEvent(wr.Event),
+		URL:    wr.URL,
+		Secret: wr.Secret,
+	}
+	json.Unmarshal([]byte(wr.WatchedFields), &res.Fields)
+	return res
+}
+
+// webhookDeliveryRecord holds the values of a WebhookDelivery record as stored in the database
+type webhookDeliveryRecord struct {
+	ID          int64
+	Webhook     int64
+	Payload     string
+	Attempts    int64
+	HTTPStatus  int64
+	LastError   string
+	Delivered   bool
+	CreatedAt   dates.DateTime
+	LastAttempt dates.DateTime
+}
+
+// delivery returns the WebhookDelivery defined by this webhookDeliveryRecord
+func (dr webhookDeliveryRecord) delivery() WebhookDelivery {
+	return WebhookDelivery{
+		ID:          dr.ID,
+		WebhookID:   dr.Webhook,
+		Payload:     []byte(dr.Payload),
+		Attempts:    int(dr.Attempts),
+		Status:      int(dr.HTTPStatus),
+		Error:       dr.LastError,
+		Delivered:   dr.Delivered,
+		CreatedAt:   dr.CreatedAt.Time,
+		LastAttempt: dr.LastAttempt.Time,
+	}
+}
+
+// A modelWebhookStore is a WebhookStore that keeps webhooks and
+// deliveries in the Webhook and WebhookDelivery system models.
+type modelWebhookStore struct{}
+
+// Add saves the given new webhook and returns it with its ID set
+func (ms modelWebhookStore) Add(env Environment, hook Webhook) Webhook {
+	fields, _ := json.Marshal(hook.Fields)
+	rec := env.Pool(webhookModelName).Sudo().Call("Create", FieldMap{
+		"RecordModel":   hook.Model,
+		"Event":         string(hook.Event),
+		"WatchedFields": string(fields),
+		"URL":           hook.URL,
+		"Secret":        hook.Secret,
+	}).(RecordCollection)
+	hook.ID = rec.ids[0]
+	return hook
+}
+
+// Remove deletes the webhook with the given ID and its deliveries
+func (ms modelWebhookStore) Remove(env Environment, id int64) {
+	rc := env.Pool(webhookModelName).Sudo()
+	rc.Search(rc.Model().Field("ID").Equals(id)).Call("Unlink")
+}
+
+// Get returns the webhook with the given ID
+func (ms modelWebhookStore) Get(env Environment, id int64) (Webhook, bool) {
+	rc := env.Pool(webhookModelName).Sudo()
+	rc = rc.Search(rc.Model().Field("ID").Equals(id))
+	if rc.IsEmpty() {
+		return Webhook{}, false
+	}
+	var wr webhookRecord
+	rc.First(&wr)
+	return wr.webhook(), true
+}
+
+// ModelWebhooks returns all the webhooks of the given model
+func (ms modelWebhookStore) ModelWebhooks(env Environment, model string) []Webhook {
+	rc := env.Pool(webhookModelName).Sudo()
+	var res []Webhook
+	for _, rec := range rc.Search(rc.Model().Field("RecordModel").Equals(model)).Records() {
+		var wr webhookRecord
+		rec.First(&wr)
+		res = append(res, wr.webhook())
+	}
+	return res
+}
+
+// SaveDelivery creates or updates the given delivery
+func (ms modelWebhookStore) SaveDelivery(env Environment, delivery WebhookDelivery) WebhookDelivery {
+	values := FieldMap{
+		"Payload":     string(delivery.Payload),
+		"Attempts":    int64(delivery.Attempts),
+		"HTTPStatus":  int64(delivery.Status),
+		"LastError":   delivery.Error,
+		"Delivered":   delivery.Delivered,
+		"LastAttempt": dates.DateTime{Time: delivery.LastAttempt},
+	}
+	rc := env.Pool(webhookDeliveryModelName).Sudo()
+	if delivery.ID != 0 {
+		rc.Search(rc.Model().Field("ID").Equals(delivery.ID)).Call("Write", values)
+		return delivery
+	}
+	values["Webhook"] = delivery.WebhookID
+	values["CreatedAt"] = dates.DateTime{Time: delivery.CreatedAt}
+	delivery.ID = rc.Call("Create", values).(RecordCollection).ids[0]
+	return delivery
+}
+
+// Delivery returns the delivery with the given ID
+func (ms modelWebhookStore) Delivery(env Environment, id int64) (WebhookDelivery, bool) {
+	rc := env.Pool(webhookDeliveryModelName).Sudo()
+	rc = rc.Search(rc.Model().Field("ID").Equals(id))
+	if rc.IsEmpty() {
+		return WebhookDelivery{}, false
+	}
+	var dr webhookDeliveryRecord
+	rc.First(&dr)
+	return dr.delivery(), true
+}
+
+// Deliveries returns the deliveries of the given webhook sorted by ID
+func (ms modelWebhookStore) Deliveries(env Environment, webhookID int64) []WebhookDelivery {
+	rc := env.Pool(webhookDeliveryModelName).Sudo()
+	var res []WebhookDelivery
+	for _, rec := range rc.Search(rc.Model().Field("Webhook").Equals(webhookID)).OrderBy("ID").Records() {
+		var dr webhookDeliveryRecord
+		rec.First(&dr)
+		res = append(res, dr.delivery())
+	}
+	return res
+}
+
+var _ WebhookStore = modelWebhookStore{}
+
+// declareWebhookModels declares the system models storing the webhooks and their delivery log
+func declareWebhookModels() {
+	webhookModel := createModel(webhookModelName, SystemModel)
+	webhookModel.InheritModel(Registry.MustGet("CommonMixin"))
+	webhookModel.AddCharField("RecordModel", StringFieldParams{Required: true, Index: true})
+	webhookModel.AddSelectionField("Event", SelectionFieldParams{Required: true, Selection: types.Selection{
+		string(WebhookCreate): "Create",
+		string(WebhookWrite):  "Write",
+		string(WebhookUnlink): "Unlink",
+	}})
+	webhookModel.AddTextField("WatchedFields", StringFieldParams{})
+	webhookModel.AddCharField("URL", StringFieldParams{JSON: "url", Required: true})
+	webhookModel.AddCharField("Secret", StringFieldParams{})
+
+	deliveryModel := createModel(webhookDeliveryModelName, SystemModel)
+	deliveryModel.InheritModel(Registry.MustGet("CommonMixin"))
+	deliveryModel.AddMany2OneField("Webhook", ForeignKeyFieldParams{RelationModel: webhookModel, Required: true,
+		Index: true, OnDelete: Cascade})
+	deliveryModel.AddTextField("Payload", StringFieldParams{})
+	deliveryModel.AddIntegerField("Attempts", SimpleFieldParams{})
+	deliveryModel.AddIntegerField("HTTPStatus", SimpleFieldParams{JSON: "http_status"})
+	deliveryModel.AddTextField("LastError", StringFieldParams{})
+	deliveryModel.AddBooleanField("Delivered", SimpleFieldParams{})
+	deliveryModel.AddDateTimeField("CreatedAt", SimpleFieldParams{})
+	deliveryModel.AddDateTimeField("LastAttempt", SimpleFieldParams{})
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+)
+
+const (
+	// uidKey is the key of the authenticated user id in the context
+	// and in the session
+	uidKey = "uid"
+	// apiKeyKey is the key of the APIKey used for authentication in the context
+	apiKeyKey = "apiKey"
+	// pendingUIDKey is the session key of the uid of a user who passed the
+	// first authentication factor but not the second one yet.
+	pendingUIDKey = "pending_uid"
+)
+
+var (
+	// ErrSecondFactorNeeded is returned by LogIn when the user must give
+	// a TOTP code with VerifySecondFactor to complete the login.
+	ErrSecondFactorNeeded = errors.New("second authentication factor needed")
+	// ErrEnrolmentNeeded is returned by LogIn when two-factor authentication
+	// is enforced for the user but the user has not enrolled yet.
+	ErrEnrolmentNeeded = errors.New("two-factor authentication enrolment needed")
+	// ErrNoPendingLogin is returned by VerifySecondFactor when the first
+	// factor has not been checked in this session, and by EnrolSecondFactor
+	// and ConfirmSecondFactor when no user may enrol in this session.
+	ErrNoPendingLogin = errors.New("no pending login in this session")
+)
+
+// UID returns the id of the user authenticated for this request.
+//
+// The uid set by an authentication middleware (such as BearerAuth) takes
+// precedence over the uid stored in the session. It returns 0 if no user
+// is authenticated.
+func (c *Context) UID() int64 {
+	if uid, ok := c.Get(uidKey); ok {
+		return uid.(int64)
+	}
+	if uid, ok := c.Session().Get(uidKey).(int64); ok {
+		return uid
+	}
+	return 0
+}
+
+// LogIn authenticates the user with the given login and secret through the
+// security.AuthenticationRegistry and stores its uid in the session.
+//
+// If the user has enabled two-factor authentication, the uid is not set in
+// the session yet and ErrSecondFactorNeeded is returned: VerifySecondFactor
+// must then be called with a valid code. If 2FA is enforced for the user's
+// groups and the user has not enrolled, ErrEnrolmentNeeded is returned and
+// the enrolment must be done with EnrolSecondFactor and ConfirmSecondFactor.
+func (c *Context) LogIn(login, secret string, context *types.Context) error {
+	uid, err := security.AuthenticationRegistry.Authenticate(login, secret, context)
+	if err != nil {
+		return err
+	}
+	sess := c.Session()
+	sess.Delete(uidKey)
+	switch {
+	case security.TwoFactor.IsEnabled(uid):
+		err = ErrSecondFactorNeeded
+	case security.TwoFactor.IsRequired(uid):
+		err = ErrEnrolmentNeeded
+	default:
+		sess.Delete(pendingUIDKey)
+		sess.Set(uidKey, uid)
+		sess.Save()
+		return nil
+	}
+	sess.Set(pendingUIDKey, uid)
+	sess.Save()
+	return err
+}
+
+// PendingUID returns the id of the user who passed the first authentication
+// factor in this session, but not the second one. It returns 0 if there is
+// no pending login.
+func (c *Context) PendingUID() int64 {
+	uid, _ := c.Session().Get(pendingUIDKey).(int64)
+	return uid
+}
+
+// VerifySecondFactor completes a login started with LogIn by checking the
+// given TOTP or recovery code. On success, the uid is stored in the session.
+func (c *Context) VerifySecondFactor(code string) error {
+	uid := c.PendingUID()
+	if uid == 0 {
+		return ErrNoPendingLogin
+	}
+	if err := security.TwoFactor.Verify(uid, code); err != nil {
+		return err
+	}
+	sess := c.Session()
+	sess.Delete(pendingUIDKey)
+	sess.Set(uidKey, uid)
+	sess.Save()
+	return nil
+}
+
+// enrolmentUID returns the id of the user that can enrol for two-factor
+// authentication in this session, or 0 if there is none.
+//
+// This is the authenticated user if any. Otherwise, a user with a pending
+// login can only enrol if 2FA is enforced and not enabled yet for this user,
+// so that knowing a password is not enough to replace the TOTP secret.
+func (c *Context) enrolmentUID() int64 {
+	if uid := c.UID(); uid != 0 {
+		return uid
+	}
+	uid := c.PendingUID()
+	if uid == 0 || !security.TwoFactor.IsRequired(uid) || security.TwoFactor.IsEnabled(uid) {
+		return 0
+	}
+	return uid
+}
+
+// EnrolSecondFactor creates a new TOTP secret for the user of this session
+// and returns it with its otpauth URI (see security.TwoFactorRegistry.Enrol).
+//
+// It returns ErrNoPendingLogin if no user is allowed to enrol in this session.
+func (c *Context) EnrolSecondFactor(account string) (string, string, error) {
+	uid := c.enrolmentUID()
+	if uid == 0 {
+		return "", "", ErrNoPendingLogin
+	}
+	secret, uri := security.TwoFactor.Enrol(uid, account)
+	return secret, uri, nil
+}
+
+// ConfirmSecondFactor confirms the enrolment started with EnrolSecondFactor
+// with the given TOTP code and returns the recovery codes of the user.
+//
+// If the enrolment was done during a pending login, the login is completed
+// and the uid is stored in the session.
+func (c *Context) ConfirmSecondFactor(code string) ([]string, error) {
+	uid := c.enrolmentUID()
+	if uid == 0 {
+		return nil, ErrNoPendingLogin
+	}
+	codes, err := security.TwoFactor.Confirm(uid, code)
+	if err != nil {
+		return nil, err
+	}
+	if c.UID() == 0 {
+		sess := c.Session()
+		sess.Delete(pendingUIDKey)
+		sess.Set(uidKey, uid)
+		sess.Save()
+	}
+	return codes, nil
+}
+
+// LogOut removes the authenticated and pending users from the session
+func (c *Context) LogOut() {
+	sess := c.Session()
+	sess.Delete(uidKey)
+	sess.Delete(pendingUIDKey)
+	sess.Save()
+}
+
+// SetUID sets the id of the authenticated user for this request only.
+// It does not modify the session.
+func (c *Context) SetUID(uid int64) {
+	c.Set(uidKey, uid)
+}
+
+// APIKey returns the APIKey that was used to authenticate this request.
+// The second returned value is false if the request was not authenticated
+// with an API key.
+func (c *Context) APIKey() (security.APIKey, bool) {
+	key, ok := c.Get(apiKeyKey)
+	if !ok {
+		return security.APIKey{}, false
+	}
+	return key.(security.APIKey), true
+}
+
+// BearerAuth returns a middleware that authenticates requests with
+// an API key given in the "Authorization: Bearer <token>" header.
+//
+// On success, the uid of the key's user is set on the Context and no session
+// is used. The request is aborted with status 401 if the header is missing
+// or the key is invalid or expired, and with status 403 if the key does not
+// have all the given scopes.
+func BearerAuth(scopes ...string) HandlerFunc {
+	return func(c *Context) {
+		authHeader := c.Request.Header.Get("Authorization")
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			c.Header("WWW-Authenticate", `Bearer realm="hexya"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+		key, err := security.APIKeys.Authenticate(token)
+		if err != nil {
+			log.Debug("API key authentication failed", "error", err, "remote", c.ClientIP())
+			c.Header("WWW-Authenticate", `Bearer realm="hexya", error="invalid_token"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		for _, scope := range scopes {
+			if !key.HasScope(scope) {
+				c.Header("WWW-Authenticate", `Bearer realm="hexya", error="insufficient_scope"`)
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+		c.SetUID(key.UID)
+		c.Set(apiKeyKey, key)
+		c.Next()
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/models/types"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testAuthBackend authenticates the users of the map with password "secret"
+type testAuthBackend map[string]int64
+
+func (tb testAuthBackend) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	uid, ok := tb[login]
+	if !ok {
+		return 0, security.UserNotFoundError(login)
+	}
+	if secret != "secret" {
+		return 0, security.InvalidCredentialsError(login)
+	}
+	return uid, nil
+}
+
+// testClient sends requests to a handler with the session cookie it received
+type testClient struct {
+	handler http.Handler
+	cookies []*http.Cookie
+}
+
+// post sends a POST request to the given path with the given query
+// parameters and returns the response body.
+func (tc *testClient) post(path string, params ...string) string {
+	query := url.Values{}
+	for i := 0; i+1 < len(params); i += 2 {
+		query.Set(params[i], params[i+1])
+	}
+	req, _ := http.NewRequest(http.MethodPost, path+"?"+query.Encode(), nil)
+	for _, cookie := range tc.cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	tc.handler.ServeHTTP(w, req)
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		tc.cookies = cookies
+	}
+	return w.Body.String()
+}
+
+// newAuthTestServer returns a server with a session store and routes
+// that call the authentication methods of the Context.
+func newAuthTestServer() *Server {
+	srv := &Server{Engine: gin.New()}
+	srv.Use(sessions.Sessions("hexya-test-session", sessions.NewCookieStore([]byte("hexya-test-session-secret"))))
+	result := func(err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		return "ok"
+	}
+	grp := srv.Group("/")
+	grp.POST("/login", func(c *Context) {
+		c.String(http.StatusOK, result(c.LogIn(c.Query("login"), c.Query("password"), types.NewContext())))
+	})
+	grp.POST("/verify", func(c *Context) {
+		c.String(http.StatusOK, result(c.VerifySecondFactor(c.Query("code"))))
+	})
+	grp.POST("/enrol", func(c *Context) {
+		secret, _, err := c.EnrolSecondFactor("test")
+		if err != nil {
+			c.String(http.StatusOK, err.Error())
+			return
+		}
+		c.String(http.StatusOK, secret)
+	})
+	grp.POST("/confirm", func(c *Context) {
+		_, err := c.ConfirmSecondFactor(c.Query("code"))
+		c.String(http.StatusOK, result(err))
+	})
+	grp.POST("/logout", func(c *Context) {
+		c.LogOut()
+		c.String(http.StatusOK, "ok")
+	})
+	grp.POST("/uid", func(c *Context) {
+		c.String(http.StatusOK, fmt.Sprintf("%d/%d", c.UID(), c.PendingUID()))
+	})
+	return srv
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	Convey("Testing logins with two-factor authentication", t, func() {
+		oldAuth, oldTwoFactor := security.AuthenticationRegistry, security.TwoFactor
+		defer func() {
+			security.AuthenticationRegistry, security.TwoFactor = oldAuth, oldTwoFactor
+		}()
+		security.AuthenticationRegistry = new(security.AuthBackendRegistry)
+		security.AuthenticationRegistry.RegisterBackend(testAuthBackend{"enabled": 10, "plain": 11, "required": 12})
+		security.TwoFactor = security.NewTwoFactorRegistry()
+		group := security.Registry.NewGroup("group_2fa_login_test", "2FA Login Group")
+		defer security.Registry.UnregisterGroup(group)
+		security.Registry.AddMembership(12, group)
+		security.TwoFactor.RequireForGroup(group)
+		secret, _ := security.TwoFactor.Enrol(10, "enabled")
+		code, _ := security.TOTPCode(secret, time.Now().Add(-security.TOTPPeriod))
+		recoveryCodes, err := security.TwoFactor.Confirm(10, code)
+		So(err, ShouldBeNil)
+		client := &testClient{handler: newAuthTestServer()}
+
+		Convey("Users without 2FA should be logged in directly", func() {
+			So(client.post("/login", "login", "plain", "password", "secret"), ShouldEqual, "ok")
+			So(client.post("/uid"), ShouldEqual, "11/0")
+		})
+		Convey("A wrong code should keep the session pending", func() {
+			So(client.post("/login", "login", "enabled", "password", "secret"), ShouldEqual, ErrSecondFactorNeeded.Error())
+			So(client.post("/uid"), ShouldEqual, "0/10")
+			So(client.post("/verify", "code", "000000"), ShouldEqual, security.ErrInvalidTOTPCode.Error())
+			So(client.post("/uid"), ShouldEqual, "0/10")
+			code, _ := security.TOTPCode(secret, time.Now())
+			So(client.post("/verify", "code", code), ShouldEqual, "ok")
+			So(client.post("/uid"), ShouldEqual, "10/0")
+		})
+		Convey("A recovery code should only be used once", func() {
+			So(client.post("/login", "login", "enabled", "password", "secret"), ShouldEqual, ErrSecondFactorNeeded.Error())
+			So(client.post("/verify", "code", recoveryCodes[0]), ShouldEqual, "ok")
+			So(client.post("/uid"), ShouldEqual, "10/0")
+			So(client.post("/logout"), ShouldEqual, "ok")
+			So(client.post("/login", "login", "enabled", "password", "secret"), ShouldEqual, ErrSecondFactorNeeded.Error())
+			So(client.post("/verify", "code", recoveryCodes[0]), ShouldEqual, security.ErrInvalidTOTPCode.Error())
+			So(client.post("/uid"), ShouldEqual, "0/10")
+		})
+		Convey("Verifying without a pending login should fail", func() {
+			So(client.post("/verify", "code", recoveryCodes[1]), ShouldEqual, ErrNoPendingLogin.Error())
+			So(client.post("/uid"), ShouldEqual, "0/0")
+		})
+		Convey("Enrolment should be refused from a pending login of an enrolled user", func() {
+			So(client.post("/login", "login", "enabled", "password", "secret"), ShouldEqual, ErrSecondFactorNeeded.Error())
+			So(client.post("/enrol"), ShouldEqual, ErrNoPendingLogin.Error())
+			So(client.post("/confirm", "code", "000000"), ShouldEqual, ErrNoPendingLogin.Error())
+			So(client.post("/uid"), ShouldEqual, "0/10")
+			So(security.TwoFactor.IsEnabled(10), ShouldBeTrue)
+		})
+		Convey("Users required to use 2FA should enrol to complete their login", func() {
+			So(client.post("/login", "login", "required", "password", "secret"), ShouldEqual, ErrEnrolmentNeeded.Error())
+			So(client.post("/uid"), ShouldEqual, "0/12")
+			newSecret := client.post("/enrol")
+			So(client.post("/confirm", "code", "000000"), ShouldEqual, security.ErrInvalidTOTPCode.Error())
+			So(client.post("/uid"), ShouldEqual, "0/12")
+			code, _ := security.TOTPCode(newSecret, time.Now())
+			So(client.post("/confirm", "code", code), ShouldEqual, "ok")
+			So(client.post("/uid"), ShouldEqual, "12/0")
+			So(security.TwoFactor.IsEnabled(12), ShouldBeTrue)
+		})
+	})
+}
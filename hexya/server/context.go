@@ -19,6 +19,9 @@ type Context struct {
 }
 
 // RPC serializes the given struct as JSON-RPC into the response body.
+//
+// If an error is given, a JSON-RPC error built by NewJSONRPCError is
+// sent instead, with code as HTTP status.
 func (c *Context) RPC(code int, obj interface{}, err ...error) {
 	id, ok := c.Get("id")
 	if !ok {
@@ -33,14 +36,7 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 		respErr := ResponseError{
 			JsonRPC: "2.0",
 			ID:      id.(int64),
-			Error: JSONRPCError{
-				Code:    code,
-				Message: "Hexya Server Error",
-				Data: JSONRPCErrorData{
-					Arguments: "Internal Server Error",
-					Debug:     err[0].Error(),
-				},
-			},
+			Error:   NewJSONRPCError(err[0]),
 		}
 		c.JSON(code, respErr)
 		return
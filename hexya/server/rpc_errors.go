@@ -0,0 +1,46 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/spf13/viper"
+)
+
+// NewJSONRPCError returns the JSONRPCError to send to the client for the given error.
+//
+// Models errors are given their own code and message, and their user message
+// as arguments. Other errors are internal server errors.
+// The error details, including the stack trace, are only sent in Debug mode.
+func NewJSONRPCError(err error) JSONRPCError {
+	res := JSONRPCError{
+		Code:    RPCInternalError,
+		Message: "Hexya Server Error",
+	}
+	data := JSONRPCErrorData{
+		Arguments: "Internal Server Error",
+	}
+	debug := err.Error()
+	if mErr, ok := err.(models.Error); ok {
+		data.Arguments = mErr.UserMessage()
+		debug = mErr.Debug()
+	}
+	switch err.(type) {
+	case models.AccessError:
+		res.Code, res.Message = RPCAccessError, "Access Error"
+	case models.ValidationError:
+		res.Code, res.Message = RPCValidationError, "Validation Error"
+	case models.MissingError:
+		res.Code, res.Message = RPCMissingError, "Missing Record"
+	case models.ConcurrencyError:
+		res.Code, res.Message = RPCConcurrencyError, "Concurrency Error"
+	case models.UserWarning:
+		res.Code, res.Message = RPCUserWarning, "User Warning"
+	}
+	if viper.GetBool("Debug") {
+		data.Debug = debug
+	}
+	res.Data = data
+	return res
+}
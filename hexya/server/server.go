@@ -67,6 +67,24 @@ type JSONRPCErrorData struct {
 	Debug     string `json:"debug"`
 }
 
+// Standard JSON-RPC 2.0 error codes
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+// Hexya JSON-RPC error codes for models errors
+const (
+	RPCAccessError      = -32001
+	RPCValidationError  = -32002
+	RPCMissingError     = -32003
+	RPCConcurrencyError = -32004
+	RPCUserWarning      = -32005
+)
+
 // JSONRPCError is the format of an Error in a ResponseError
 type JSONRPCError struct {
 	Code    int         `json:"code"`
@@ -0,0 +1,111 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/hexya/controllers"
+	"github.com/hexya-erp/hexya/hexya/models"
+	"github.com/hexya-erp/hexya/hexya/models/security"
+	"github.com/hexya-erp/hexya/hexya/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// performRESTRequest sends a request with the given JSON body and
+// authorization header to r and returns the response.
+func performRESTRequest(r http.Handler, method, path, body, authorization string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authorization)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// restRecordResponse decodes the record returned in the body of r
+func restRecordResponse(r *httptest.ResponseRecorder) map[string]interface{} {
+	var res map[string]interface{}
+	json.Unmarshal(r.Body.Bytes(), &res)
+	return res
+}
+
+func TestRESTCRUD(t *testing.T) {
+	Convey("Testing REST API CRUD requests", t, func() {
+		gin.SetMode(gin.ReleaseMode)
+		srv := &server.Server{Engine: gin.New()}
+		api := srv.Group("/api", server.BearerAuth())
+		api.GET("/:model/:id", controllers.RESTRead)
+		api.POST("/:model", controllers.RESTCreate)
+		api.PATCH("/:model/:id", controllers.RESTWrite)
+		api.DELETE("/:model/:id", controllers.RESTUnlink)
+		token, _ := security.APIKeys.NewKey(security.SuperUserID, "REST CRUD key", 0)
+		auth := "Bearer " + token
+
+		r := performRESTRequest(srv, http.MethodPost, "/api/Tag", `{"name": "REST Tag", "description": "Created"}`, auth)
+		So(r.Code, ShouldEqual, http.StatusCreated)
+		created := restRecordResponse(r)
+		So(created["name"], ShouldEqual, "REST Tag")
+		id := int64(created["id"].(float64))
+		path := fmt.Sprintf("/api/Tag/%d", id)
+
+		Convey("Created records should be read, updated and deleted", func() {
+			r := performRESTRequest(srv, http.MethodGet, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(restRecordResponse(r)["description"], ShouldEqual, "Created")
+			r = performRESTRequest(srv, http.MethodPatch, path, `{"description": "Updated"}`, auth)
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(restRecordResponse(r)["description"], ShouldEqual, "Updated")
+			r = performRESTRequest(srv, http.MethodGet, path, "", auth)
+			So(restRecordResponse(r)["description"], ShouldEqual, "Updated")
+			r = performRESTRequest(srv, http.MethodDelete, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusNoContent)
+			r = performRESTRequest(srv, http.MethodGet, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Missing records should not be found", func() {
+			performRESTRequest(srv, http.MethodDelete, path, "", auth)
+			r := performRESTRequest(srv, http.MethodGet, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+			r = performRESTRequest(srv, http.MethodPatch, path, `{"description": "Updated"}`, auth)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+			r = performRESTRequest(srv, http.MethodDelete, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Records hidden by record rules should not be found", func() {
+			tagModel := models.Registry.MustGet("Tag")
+			tagModel.AddRecordRule(&models.RecordRule{
+				Name:      "restHiddenTag",
+				Global:    true,
+				Condition: tagModel.Field("Name").NotEquals("REST Tag"),
+				Perms:     security.All,
+			})
+			r := performRESTRequest(srv, http.MethodGet, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+			r = performRESTRequest(srv, http.MethodPatch, path, `{"description": "Updated"}`, auth)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+			r = performRESTRequest(srv, http.MethodDelete, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+			tagModel.RemoveRecordRule("restHiddenTag")
+			r = performRESTRequest(srv, http.MethodGet, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(restRecordResponse(r)["description"], ShouldEqual, "Created")
+			r = performRESTRequest(srv, http.MethodDelete, path, "", auth)
+			So(r.Code, ShouldEqual, http.StatusNoContent)
+		})
+		Convey("Invalid bodies should be rejected", func() {
+			r := performRESTRequest(srv, http.MethodPatch, path, `{"description":`, auth)
+			So(r.Code, ShouldEqual, http.StatusBadRequest)
+			r = performRESTRequest(srv, http.MethodPost, "/api/Tag", `not json`, auth)
+			So(r.Code, ShouldEqual, http.StatusBadRequest)
+			performRESTRequest(srv, http.MethodDelete, path, "", auth)
+		})
+	})
+}
@@ -24,6 +24,7 @@ type fieldData struct {
 	Type     string
 	SanType  string
 	IsRS     bool
+	Is2Many  bool
 }
 
 // A returnType characterizes a return value of a method
@@ -57,6 +58,8 @@ type fieldType struct {
 	Type      string
 	SanType   string
 	IsRS      bool
+	Is2Many   bool
+	RelModel  string
 	Operators []operatorDef
 }
 
@@ -210,13 +213,21 @@ func addFieldsToModelData(modelASTData ModelASTData, modelData *modelData, depsM
 			typStr = fmt.Sprintf("%sSet", fieldASTData.RelModel)
 		}
 		jsonName := strutils.GetDefaultString(fieldASTData.JSON, strutils.SnakeCaseString(fieldName))
+		sanType := createTypeIdent(typStr)
+		if fieldASTData.Is2Many {
+			// 2many fields get their own condition field type with
+			// subquery operators, distinct from many2one fields
+			// pointing to the same model.
+			sanType += "2Many"
+		}
 		modelData.Fields = append(modelData.Fields, fieldData{
 			Name:     fieldName,
 			JSON:     jsonName,
 			Type:     typStr,
 			IsRS:     fieldASTData.IsRS,
+			Is2Many:  fieldASTData.Is2Many,
 			RelModel: fieldASTData.RelModel,
-			SanType:  createTypeIdent(typStr),
+			SanType:  sanType,
 		})
 		(*depsMap)[fieldASTData.Type.ImportPath] = true
 	}
@@ -224,22 +235,28 @@ func addFieldsToModelData(modelASTData ModelASTData, modelData *modelData, depsM
 
 // addFieldsToModelData extracts field types from mData.Fields
 // and add them to mData.Types
+//
+// Field types are identified by their SanType, so that One2Many and
+// Many2Many fields have a different type than Many2One fields of the
+// same Go type.
 func addFieldTypesToModelData(mData *modelData) {
 	fTypes := make(map[string]bool)
 	for _, f := range mData.Fields {
-		if fTypes[f.Type] {
+		if fTypes[f.SanType] {
 			continue
 		}
-		fTypes[f.Type] = true
+		fTypes[f.SanType] = true
 		mData.Types = append(mData.Types, fieldType{
-			Type:    f.Type,
-			SanType: f.SanType,
-			IsRS:    f.IsRS,
+			Type:     f.Type,
+			SanType:  f.SanType,
+			IsRS:     f.IsRS,
+			Is2Many:  f.Is2Many,
+			RelModel: f.RelModel,
 			Operators: []operatorDef{
 				{Name: "Equals"}, {Name: "NotEquals"}, {Name: "Greater"}, {Name: "GreaterOrEqual"}, {Name: "Lower"},
 				{Name: "LowerOrEqual"}, {Name: "Like"}, {Name: "Contains"}, {Name: "NotContains"}, {Name: "IContains"},
 				{Name: "NotIContains"}, {Name: "ILike"}, {Name: "In", Multi: true}, {Name: "NotIn", Multi: true},
-				{Name: "ChildOf"},
+				{Name: "ChildOf"}, {Name: "ParentOf"}, {Name: "Matches"},
 			},
 		})
 	}
@@ -325,6 +342,14 @@ func (m {{ .Name }}Model) Create(env models.Environment, data interface{}) {{ .N
 	}
 }
 
+// CreateMulti creates new {{ .Name }} records and returns the newly created
+// {{ .Name }}Set instance, in the order of data.
+func (m {{ .Name }}Model) CreateMulti(env models.Environment, data []models.FieldMapper) {{ .Name }}Set {
+	return {{ .Name }}Set{
+		RecordCollection: m.Model.CreateMulti(env, data),
+	}
+}
+
 // Search searches the database and returns a new {{ .Name }}Set instance
 // with the records found.
 func (m {{ .Name }}Model) Search(env models.Environment, cond {{ .Name }}Condition) {{ .Name }}Set {
@@ -567,6 +592,29 @@ func (c {{ $.Name }}{{ $typ.SanType }}ConditionField) IsNotNull() {{ $.Name }}Co
 	}
 }
 
+{{ if $typ.Is2Many }}
+// Any checks if at least one of the related records matches cond
+func (c {{ $.Name }}{{ $typ.SanType }}ConditionField) Any(cond {{ $typ.RelModel }}Condition) {{ $.Name }}Condition {
+	return {{ $.Name }}Condition{
+		Condition: c.ConditionField.Any(cond.Condition),
+	}
+}
+
+// None checks if none of the related records matches cond
+func (c {{ $.Name }}{{ $typ.SanType }}ConditionField) None(cond {{ $typ.RelModel }}Condition) {{ $.Name }}Condition {
+	return {{ $.Name }}Condition{
+		Condition: c.ConditionField.None(cond.Condition),
+	}
+}
+
+// All checks if all the related records match cond
+func (c {{ $.Name }}{{ $typ.SanType }}ConditionField) All(cond {{ $typ.RelModel }}Condition) {{ $.Name }}Condition {
+	return {{ $.Name }}Condition{
+		Condition: c.ConditionField.All(cond.Condition),
+	}
+}
+{{ end }}
+
 {{ end }}
 
 // ------- DATA STRUCT ---------
@@ -648,6 +696,17 @@ func (s {{ .Name }}Set) Records() []{{ .Name }}Set {
 	return res
 }
 
+// Iterate calls fnct with successive batches of at most batchSize records of this
+// {{ .Name }}Set loaded with the given fields, until all records have been iterated
+// over or fnct returns false. See models.RecordCollection.Iterate for details.
+func (s {{ .Name }}Set) Iterate(batchSize int, fnct func({{ .Name }}Set) bool, fields ...models.FieldNamer) {
+	s.RecordCollection.Iterate(batchSize, func(rc models.RecordCollection) bool {
+		return fnct({{ .Name }}Set{
+			RecordCollection: rc,
+		})
+	}, fields...)
+}
+
 // Search returns a new {{ $.Name }}Set filtering on the current one with the
 // additional given Condition
 func (s {{ $.Name }}Set) Search(condition {{ .Name }}Condition) {{ .Name }}Set {
@@ -117,6 +117,7 @@ type FieldASTData struct {
 	RelModel    string
 	Type        TypeData
 	IsRS        bool
+	Is2Many     bool
 	embed       bool
 }
 
@@ -346,12 +347,14 @@ func parseAddField(node *ast.CallExpr, modInfo *ModuleInfo, modelsData *map[stri
 	if typeStr == "Date" || typeStr == "DateTime" {
 		importPath = DatesPath
 	}
+	fType := fieldtype.Type(strings.ToLower(typeStr))
 	fData := FieldASTData{
 		Name: fieldName,
 		Type: TypeData{
-			Type:       fieldtype.Type(strings.ToLower(typeStr)).DefaultGoType().String(),
+			Type:       fType.DefaultGoType().String(),
 			ImportPath: importPath,
 		},
+		Is2Many: fType == fieldtype.One2Many || fType == fieldtype.Many2Many,
 	}
 	var fieldElems []ast.Expr
 	switch fd := node.Args[1].(type) {